@@ -0,0 +1,300 @@
+package cfh
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+const (
+	defaultTagSize = 16
+	minTagSize     = 8
+	minAuthKeySize = 16
+)
+
+// maxAuthRecordSize is the maximum size of the record that be authenticated.
+const maxAuthRecordSize = 65535
+
+// ErrAuthentication is returned by AuthReader when the tag of record is
+// mismatched, the record is discarded before it is read by the Reader.
+var ErrAuthentication = errors.New("record is not authenticated")
+
+// AuthOptions contains the options about AuthWriter and AuthReader.
+type AuthOptions struct {
+	// TagSize is the size of the truncated HMAC-SHA256 tag,
+	// it must be between 8 and 32, default is 16.
+	TagSize int
+
+	// Datagram is used to authenticate each datagram that written by the
+	// Writer in datagram mode. In this mode the record is not framed and
+	// the forged datagrams are discarded by AuthReader silently. The tag
+	// covers the sequence of the datagram that is extended to 64-bit, the
+	// replayed datagrams and the datagrams that are older than the last 64
+	// sequences are also discarded. The sequence is restarted by the Reset
+	// of Writer, so create new AuthWriter and AuthReader with it. If more
+	// than 32767 datagrams are lost continuously, the following datagrams
+	// cannot be authenticated.
+	Datagram bool
+}
+
+func (opts *AuthOptions) apply() (*AuthOptions, error) {
+	o := AuthOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.TagSize == 0 {
+		o.TagSize = defaultTagSize
+	}
+	if o.TagSize < minTagSize || o.TagSize > sha256.Size {
+		return nil, fmt.Errorf("invalid tag size: %d", o.TagSize)
+	}
+	return &o, nil
+}
+
+func newAuthMAC(key []byte) (hash.Hash, error) {
+	if len(key) < minAuthKeySize {
+		return nil, errors.New("authentication key is too short")
+	}
+	k := make([]byte, len(key))
+	copy(k, key)
+	return hmac.New(sha256.New, k), nil
+}
+
+// authTag is used to calculate the truncated tag about the record. The
+// counter of record is included, in stream mode the replayed, reordered
+// and dropped records will be rejected. In datagram mode the counter is
+// the extended sequence, it is used to detect the replayed datagrams.
+func authTag(mac hash.Hash, counter uint64, record []byte) []byte {
+	mac.Reset()
+	var c [8]byte
+	binary.BigEndian.PutUint64(c[:], counter)
+	mac.Write(c[:])
+	mac.Write(record)
+	return mac.Sum(nil)
+}
+
+// datagramCounter is used to extend the 16-bit sequence at the beginning of
+// datagram to 64-bit with the last counter, the closest one is selected, so
+// the counter keeps increasing after the sequence wraps around.
+func datagramCounter(last uint64, record []byte) uint64 {
+	seq := binary.BigEndian.Uint16(record[:2])
+	diff := int64(int16(seq - uint16(last)))
+	if diff < 0 && uint64(-diff) > last {
+		return uint64(seq)
+	}
+	return uint64(int64(last) + diff)
+}
+
+// AuthWriter is used to append the HMAC-SHA256 tag to each record that
+// written by the Writer with the pre-shared key, it must be the under
+// writer of the Writer. Each Write call is authenticated as one record,
+// so a batch written by one call only has one tag.
+//
+// In stream mode the record is framed as [length uint16][record][tag].
+// In datagram mode the tag is appended to the datagram directly.
+type AuthWriter struct {
+	w       io.Writer
+	opts    *AuthOptions
+	mac     hash.Hash
+	counter uint64
+	buf     bytes.Buffer
+}
+
+// NewAuthWriter is used to create a new authentication layer with the key.
+func NewAuthWriter(w io.Writer, key []byte, opts *AuthOptions) (*AuthWriter, error) {
+	opts, err := opts.apply()
+	if err != nil {
+		return nil, err
+	}
+	mac, err := newAuthMAC(key)
+	if err != nil {
+		return nil, err
+	}
+	writer := AuthWriter{
+		w:    w,
+		opts: opts,
+		mac:  mac,
+	}
+	return &writer, nil
+}
+
+// Write is used to authenticate the record and write it to the under w.
+func (aw *AuthWriter) Write(b []byte) (int, error) {
+	l := len(b)
+	if l > maxAuthRecordSize {
+		return 0, errors.New("record is too large")
+	}
+	counter := aw.counter
+	aw.buf.Reset()
+	if aw.opts.Datagram {
+		if l < 2 {
+			return 0, errors.New("datagram without sequence")
+		}
+		counter = datagramCounter(aw.counter, b)
+	} else {
+		aw.buf.WriteByte(byte(l >> 8))
+		aw.buf.WriteByte(byte(l))
+	}
+	aw.buf.Write(b)
+	tag := authTag(aw.mac, counter, b)
+	aw.buf.Write(tag[:aw.opts.TagSize])
+	_, err := aw.w.Write(aw.buf.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	if aw.opts.Datagram {
+		aw.counter = counter
+	} else {
+		aw.counter++
+	}
+	return l, nil
+}
+
+// AuthReader is used to verify the tag of each record before the Reader
+// read it, it must be the under reader of the Reader.
+type AuthReader struct {
+	r        io.Reader
+	opts     *AuthOptions
+	mac      hash.Hash
+	counter  uint64
+	seen     uint64 // the replay window in datagram mode
+	rejected uint64
+	buf      []byte
+	rem      bytes.Buffer
+	err      error
+}
+
+// NewAuthReader is used to create a new authentication layer with the key.
+func NewAuthReader(r io.Reader, key []byte, opts *AuthOptions) (*AuthReader, error) {
+	opts, err := opts.apply()
+	if err != nil {
+		return nil, err
+	}
+	mac, err := newAuthMAC(key)
+	if err != nil {
+		return nil, err
+	}
+	reader := AuthReader{
+		r:    r,
+		opts: opts,
+		mac:  mac,
+	}
+	if opts.Datagram {
+		reader.buf = make([]byte, maxDatagramSize+opts.TagSize+1)
+	} else {
+		reader.buf = make([]byte, 2+maxAuthRecordSize+opts.TagSize)
+	}
+	return &reader, nil
+}
+
+// Read is used to read the authenticated record from the under r. In stream
+// mode the error is sticky, in datagram mode the forged or malformed
+// datagrams are discarded, each Read call will return one datagram.
+func (ar *AuthReader) Read(b []byte) (int, error) {
+	if ar.opts.Datagram {
+		return ar.readDatagram(b)
+	}
+	if ar.rem.Len() != 0 {
+		return ar.rem.Read(b)
+	}
+	if ar.err != nil {
+		return 0, ar.err
+	}
+	record, err := ar.readRecord()
+	if err != nil {
+		ar.err = err
+		return 0, err
+	}
+	ar.rem.Write(record)
+	return ar.rem.Read(b)
+}
+
+func (ar *AuthReader) readRecord() ([]byte, error) {
+	_, err := io.ReadFull(ar.r, ar.buf[:2])
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err == io.ErrUnexpectedEOF {
+		err = fmt.Errorf("%w: %w", ErrTruncated, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read record size: %w", err)
+	}
+	size := int(binary.BigEndian.Uint16(ar.buf[:2]))
+	frame := ar.buf[2 : 2+size+ar.opts.TagSize]
+	err = readFull(ar.r, frame)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read authenticated record: %w", err)
+	}
+	record := frame[:size]
+	tag := authTag(ar.mac, ar.counter, record)
+	if !hmac.Equal(tag[:ar.opts.TagSize], frame[size:]) {
+		ar.rejected++
+		return nil, ErrAuthentication
+	}
+	ar.counter++
+	return record, nil
+}
+
+func (ar *AuthReader) readDatagram(b []byte) (int, error) {
+	for {
+		n, err := ar.r.Read(ar.buf)
+		if err != nil {
+			return 0, err
+		}
+		size := n - ar.opts.TagSize
+		if size < 2 || n == len(ar.buf) {
+			ar.rejected++
+			continue
+		}
+		record := ar.buf[:size]
+		counter := datagramCounter(ar.counter, record)
+		tag := authTag(ar.mac, counter, record)
+		if !hmac.Equal(tag[:ar.opts.TagSize], ar.buf[size:n]) {
+			ar.rejected++
+			continue
+		}
+		if !ar.acceptDatagram(counter) {
+			ar.rejected++
+			continue
+		}
+		return copy(b, record), nil
+	}
+}
+
+// acceptDatagram is used to check the datagram is not replayed with the
+// window about the last 64 counters, the bit n of seen is set if the
+// counter that is n before the last one is received. It is called after
+// the tag is verified, so the forged datagrams cannot move the window.
+func (ar *AuthReader) acceptDatagram(counter uint64) bool {
+	if counter > ar.counter {
+		diff := counter - ar.counter
+		if diff < 64 {
+			ar.seen = ar.seen<<diff | 1
+		} else {
+			ar.seen = 1
+		}
+		ar.counter = counter
+		return true
+	}
+	diff := ar.counter - counter
+	if diff >= 64 {
+		return false
+	}
+	bit := uint64(1) << diff
+	if ar.seen&bit != 0 {
+		return false
+	}
+	ar.seen |= bit
+	return true
+}
+
+// Rejected is used to get the number of records that are rejected.
+func (ar *AuthReader) Rejected() uint64 {
+	return ar.rejected
+}
@@ -0,0 +1,259 @@
+package cfh
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testAuthKey = []byte("0123456789abcdef")
+
+func TestDatagramCounter(t *testing.T) {
+	for _, item := range []*struct {
+		last     uint64
+		seq      uint16
+		expected uint64
+	}{
+		{0, 1, 1},
+		{0, 0xFFFF, 0xFFFF},
+		{1, 0, 0},
+		{0xFFFF, 0, 0x10000},
+		{0x10001, 0xFFFF, 0xFFFF},
+		{0x12345, 0x2340, 0x12340},
+		{0x18000, 0x0001, 0x10001},
+		{0x18002, 0x0001, 0x20001},
+	} {
+		record := []byte{byte(item.seq >> 8), byte(item.seq)}
+		require.Equal(t, item.expected, datagramCounter(item.last, record))
+	}
+}
+
+func TestAuth(t *testing.T) {
+	headers := testGenerateFrameHeaders(t)
+
+	t.Run("stream", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 4*1024*1024))
+		aw, err := NewAuthWriter(output, testAuthKey, nil)
+		require.NoError(t, err)
+		w := NewWriter(aw)
+		for _, header := range headers {
+			_, err = w.Write(header)
+			require.NoError(t, err)
+		}
+
+		ar, err := NewAuthReader(output, testAuthKey, nil)
+		require.NoError(t, err)
+		r := NewReader(ar)
+		for _, header := range headers {
+			buf := make([]byte, len(header))
+			_, err = r.Read(buf)
+			require.NoError(t, err)
+			require.Equal(t, header, buf)
+		}
+		require.Zero(t, ar.Rejected())
+
+		_, err = ar.Read(make([]byte, 1))
+		require.Equal(t, io.EOF, err)
+	})
+
+	t.Run("datagram", func(t *testing.T) {
+		queue := new(testPacketQueue)
+		authOpts := AuthOptions{
+			TagSize:  8,
+			Datagram: true,
+		}
+		aw, err := NewAuthWriter(queue, testAuthKey, &authOpts)
+		require.NoError(t, err)
+		ar, err := NewAuthReader(queue, testAuthKey, &authOpts)
+		require.NoError(t, err)
+
+		opts := Options{Datagram: true}
+		w, err := NewWriterWithOptions(aw, &opts)
+		require.NoError(t, err)
+		r, err := NewReaderWithOptions(ar, &opts)
+		require.NoError(t, err)
+		for _, header := range headers[:1024] {
+			_, err = w.Write(header)
+			require.NoError(t, err)
+			// the forged datagram is discarded
+			queue.packets = append([][]byte{{0, 1, cmdReset}}, queue.packets...)
+
+			buf := make([]byte, len(header))
+			_, err = r.Read(buf)
+			require.NoError(t, err)
+			require.Equal(t, header, buf)
+		}
+		require.Equal(t, uint64(1024), ar.Rejected())
+	})
+
+	t.Run("tampered record", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+		aw, err := NewAuthWriter(output, testAuthKey, nil)
+		require.NoError(t, err)
+		w := NewWriter(aw)
+		_, err = w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+		output.Bytes()[2+2] ^= 1
+
+		ar, err := NewAuthReader(output, testAuthKey, nil)
+		require.NoError(t, err)
+		r := NewReader(ar)
+		buf := make([]byte, len(testIPv4TCPFrameHeader1))
+		_, err = r.Read(buf)
+		errStr := "failed to read decompress command: record is not authenticated"
+		require.EqualError(t, err, errStr)
+		require.Nil(t, r.dict[0])
+		require.Equal(t, uint64(1), ar.Rejected())
+
+		// the error is sticky in stream mode
+		_, err = ar.Read(buf)
+		require.Equal(t, ErrAuthentication, err)
+	})
+
+	t.Run("replayed record", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+		aw, err := NewAuthWriter(output, testAuthKey, nil)
+		require.NoError(t, err)
+		_, err = aw.Write([]byte{cmdLast})
+		require.NoError(t, err)
+		output.Write(bytes.Clone(output.Bytes()))
+
+		ar, err := NewAuthReader(output, testAuthKey, nil)
+		require.NoError(t, err)
+		buf := make([]byte, 16)
+		n, err := ar.Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, []byte{cmdLast}, buf[:n])
+		_, err = ar.Read(buf)
+		require.Equal(t, ErrAuthentication, err)
+	})
+
+	t.Run("replayed datagram", func(t *testing.T) {
+		queue := new(testPacketQueue)
+		opts := AuthOptions{Datagram: true}
+		aw, err := NewAuthWriter(queue, testAuthKey, &opts)
+		require.NoError(t, err)
+		ar, err := NewAuthReader(queue, testAuthKey, &opts)
+		require.NoError(t, err)
+
+		// write the datagrams until the sequence wraps around
+		var captured [][]byte
+		record := []byte{0, 0, cmdReset}
+		for seq := 1; seq < 1<<16+8; seq++ {
+			record[0] = byte(seq >> 8)
+			record[1] = byte(seq)
+			_, err = aw.Write(record)
+			require.NoError(t, err)
+			if seq == 4 || seq == 1<<16+4 {
+				captured = append(captured, bytes.Clone(queue.packets[len(queue.packets)-1]))
+			}
+		}
+		// the reordered datagram is accepted
+		packets := queue.packets
+		packets[len(packets)-1], packets[len(packets)-2] = packets[len(packets)-2], packets[len(packets)-1]
+		buf := make([]byte, 16)
+		for len(queue.packets) != 0 {
+			_, err = ar.Read(buf)
+			require.NoError(t, err)
+		}
+		require.Zero(t, ar.Rejected())
+
+		// the replayed datagram before and after the sequence wraps around
+		queue.packets = captured
+		_, err = ar.Read(buf)
+		require.Equal(t, io.EOF, err)
+		require.Equal(t, uint64(2), ar.Rejected())
+	})
+
+	t.Run("datagram without sequence", func(t *testing.T) {
+		opts := AuthOptions{Datagram: true}
+		aw, err := NewAuthWriter(io.Discard, testAuthKey, &opts)
+		require.NoError(t, err)
+		n, err := aw.Write([]byte{cmdReset})
+		require.EqualError(t, err, "datagram without sequence")
+		require.Zero(t, n)
+	})
+
+	t.Run("mismatched key", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+		aw, err := NewAuthWriter(output, testAuthKey, nil)
+		require.NoError(t, err)
+		_, err = aw.Write([]byte{cmdLast})
+		require.NoError(t, err)
+
+		ar, err := NewAuthReader(output, []byte("fedcba9876543210"), nil)
+		require.NoError(t, err)
+		_, err = ar.Read(make([]byte, 16))
+		require.Equal(t, ErrAuthentication, err)
+	})
+
+	t.Run("truncated record", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+		output.Write([]byte{0})
+
+		ar, err := NewAuthReader(output, testAuthKey, nil)
+		require.NoError(t, err)
+		_, err = ar.Read(make([]byte, 16))
+		errStr := "failed to read record size: record is truncated: unexpected EOF"
+		require.EqualError(t, err, errStr)
+
+		output.Write([]byte{0, 1, cmdLast})
+		ar, err = NewAuthReader(output, testAuthKey, nil)
+		require.NoError(t, err)
+		_, err = ar.Read(make([]byte, 16))
+		errStr = "failed to read authenticated record: record is truncated: unexpected EOF"
+		require.EqualError(t, err, errStr)
+	})
+
+	t.Run("truncated datagram", func(t *testing.T) {
+		queue := &testPacketQueue{packets: [][]byte{{1, 2, 3}}}
+		opts := AuthOptions{Datagram: true}
+		ar, err := NewAuthReader(queue, testAuthKey, &opts)
+		require.NoError(t, err)
+		_, err = ar.Read(make([]byte, 16))
+		require.Equal(t, io.EOF, err)
+		require.Equal(t, uint64(1), ar.Rejected())
+	})
+
+	t.Run("record is too large", func(t *testing.T) {
+		aw, err := NewAuthWriter(io.Discard, testAuthKey, nil)
+		require.NoError(t, err)
+		n, err := aw.Write(make([]byte, maxAuthRecordSize+1))
+		require.EqualError(t, err, "record is too large")
+		require.Zero(t, n)
+	})
+
+	t.Run("failed to write", func(t *testing.T) {
+		pr, pw := io.Pipe()
+		err := pr.Close()
+		require.NoError(t, err)
+		aw, err := NewAuthWriter(pw, testAuthKey, nil)
+		require.NoError(t, err)
+		n, err := aw.Write([]byte{cmdLast})
+		require.Error(t, err)
+		require.Zero(t, n)
+		require.Zero(t, aw.counter)
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		aw, err := NewAuthWriter(io.Discard, testAuthKey[:15], nil)
+		require.EqualError(t, err, "authentication key is too short")
+		require.Nil(t, aw)
+
+		opts := AuthOptions{TagSize: 33}
+		aw, err = NewAuthWriter(io.Discard, testAuthKey, &opts)
+		require.EqualError(t, err, "invalid tag size: 33")
+		require.Nil(t, aw)
+
+		ar, err := NewAuthReader(bytes.NewReader(nil), testAuthKey[:15], nil)
+		require.EqualError(t, err, "authentication key is too short")
+		require.Nil(t, ar)
+
+		opts = AuthOptions{TagSize: 7}
+		ar, err = NewAuthReader(bytes.NewReader(nil), testAuthKey, &opts)
+		require.EqualError(t, err, "invalid tag size: 7")
+		require.Nil(t, ar)
+	})
+}
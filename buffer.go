@@ -0,0 +1,110 @@
+package cfh
+
+// buffered is used to check the records need to be buffered before
+// write to the under w, it is only used in stream mode.
+func (w *Writer) buffered() bool {
+	if w.batch {
+		return true
+	}
+	if w.opts == nil {
+		return false
+	}
+	return w.opts.BufferSize > 0 || w.opts.BufferRecords > 0
+}
+
+// output is used to write the record in buf to the under w. In buffered
+// mode, the record is appended to the pending records, and they will be
+// flushed when the size or the number of them reaches the threshold.
+func (w *Writer) output() error {
+	if !w.buffered() {
+		_, err := w.w.Write(w.buf.Bytes())
+		return err
+	}
+	w.pend.Write(w.buf.Bytes())
+	w.pending++
+	if w.batch {
+		return nil
+	}
+	size := w.opts.BufferSize
+	if size > 0 && w.pend.Len() >= size {
+		return w.flush()
+	}
+	records := w.opts.BufferRecords
+	if records > 0 && w.pending >= records {
+		return w.flush()
+	}
+	return nil
+}
+
+// flush is used to write all pending records by one Write call.
+func (w *Writer) flush() error {
+	if w.pend.Len() == 0 {
+		return nil
+	}
+	_, err := w.w.Write(w.pend.Bytes())
+	w.discardPending()
+	return err
+}
+
+func (w *Writer) discardPending() {
+	w.pend.Reset()
+	w.pending = 0
+}
+
+// Flush is used to write the pending records to the under w in buffered
+// mode, it does nothing if there are no pending records.
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	err := w.flush()
+	if err != nil {
+		w.err = err
+	}
+	return err
+}
+
+// Buffered is used to get the size of the pending records that are not
+// written to the under w.
+func (w *Writer) Buffered() int {
+	return w.pend.Len()
+}
+
+// WriteBatch is used to compress the frame headers and write them to the
+// under w by one Write call, the pending records in buffered mode are also
+// written. It returns the number of the compressed frame headers. In the
+// datagram mode, each frame header is still written by one Write call.
+func (w *Writer) WriteBatch(headers [][]byte) (int, error) {
+	if w.dgram != nil {
+		for i := 0; i < len(headers); i++ {
+			_, err := w.Write(headers[i])
+			if err != nil {
+				return i, err
+			}
+		}
+		return len(headers), nil
+	}
+	w.batch = true
+	n, err := w.writeBatch(headers)
+	w.batch = false
+	// the compressed frame headers must be written even if
+	// a frame header is invalid, otherwise the stream is broken
+	if w.err != nil {
+		return n, err
+	}
+	fErr := w.Flush()
+	if err == nil {
+		err = fErr
+	}
+	return n, err
+}
+
+func (w *Writer) writeBatch(headers [][]byte) (int, error) {
+	for i := 0; i < len(headers); i++ {
+		_, err := w.Write(headers[i])
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(headers), nil
+}
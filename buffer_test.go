@@ -0,0 +1,260 @@
+package cfh
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testCountWriter is used to count the Write calls to the under writer.
+type testCountWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *testCountWriter) Write(b []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(b)
+}
+
+func testReadFrameHeaders(t *testing.T, r *Reader, headers [][]byte) {
+	for _, header := range headers {
+		buf, err := r.ReadHeader()
+		require.NoError(t, err)
+		require.Equal(t, header, buf)
+	}
+	_, err := r.ReadHeader()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestWriter_Buffered(t *testing.T) {
+	headers := testGenerateFrameHeaders(t)
+
+	t.Run("buffer size", func(t *testing.T) {
+		output := new(testCountWriter)
+		opts := Options{BufferSize: 4096}
+		w, err := NewWriterWithOptions(output, &opts)
+		require.NoError(t, err)
+		for _, header := range headers {
+			_, err = w.Write(header)
+			require.NoError(t, err)
+			require.Less(t, w.Buffered(), 4096)
+		}
+		err = w.Flush()
+		require.NoError(t, err)
+		require.Zero(t, w.Buffered())
+		require.Less(t, output.writes, len(headers)/16)
+
+		testReadFrameHeaders(t, NewReader(output), headers)
+	})
+
+	t.Run("buffer records", func(t *testing.T) {
+		output := new(testCountWriter)
+		opts := Options{BufferRecords: 16}
+		w, err := NewWriterWithOptions(output, &opts)
+		require.NoError(t, err)
+		for _, header := range headers[:1024] {
+			_, err = w.Write(header)
+			require.NoError(t, err)
+		}
+		require.Equal(t, 1024/16, output.writes)
+		require.Zero(t, w.Buffered())
+
+		testReadFrameHeaders(t, NewReader(output), headers[:1024])
+	})
+
+	t.Run("flush without pending records", func(t *testing.T) {
+		output := new(testCountWriter)
+		w := NewWriter(output)
+		err := w.Flush()
+		require.NoError(t, err)
+		require.Zero(t, output.writes)
+	})
+
+	t.Run("failed to flush", func(t *testing.T) {
+		pr, pw := io.Pipe()
+		err := pr.Close()
+		require.NoError(t, err)
+
+		opts := Options{BufferRecords: 2}
+		w, err := NewWriterWithOptions(pw, &opts)
+		require.NoError(t, err)
+		_, err = w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+		n, err := w.Write(testIPv4TCPFrameHeader2)
+		require.Equal(t, io.ErrClosedPipe, err)
+		require.Zero(t, n)
+		require.Zero(t, w.Buffered())
+
+		// the error is sticky
+		err = w.Flush()
+		require.Equal(t, io.ErrClosedPipe, err)
+	})
+
+	t.Run("resync", func(t *testing.T) {
+		output := new(testCountWriter)
+		opts := Options{BufferRecords: 1024}
+		w, err := NewWriterWithOptions(output, &opts)
+		require.NoError(t, err)
+		for _, header := range testFrameHeaders {
+			_, err = w.Write(header)
+			require.NoError(t, err)
+		}
+		err = w.Resync()
+		require.NoError(t, err)
+		require.Equal(t, 1, output.writes)
+		require.Equal(t, byte(cmdReset), output.Bytes()[output.Len()-1])
+
+		testReadFrameHeaders(t, NewReader(output), testFrameHeaders)
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		output := new(testCountWriter)
+		opts := Options{BufferSize: 4096}
+		w, err := NewWriterWithOptions(output, &opts)
+		require.NoError(t, err)
+		_, err = w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+		require.NotZero(t, w.Buffered())
+
+		w.Reset(output)
+		require.Zero(t, w.Buffered())
+		require.Zero(t, output.writes)
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		opts := Options{BufferSize: -1}
+		w, err := NewWriterWithOptions(io.Discard, &opts)
+		require.EqualError(t, err, "invalid buffer size: -1")
+		require.Nil(t, w)
+
+		opts = Options{BufferRecords: -1}
+		w, err = NewWriterWithOptions(io.Discard, &opts)
+		require.EqualError(t, err, "invalid buffer records: -1")
+		require.Nil(t, w)
+	})
+}
+
+func TestWriter_WriteBatch(t *testing.T) {
+	headers := testGenerateFrameHeaders(t)
+
+	t.Run("common", func(t *testing.T) {
+		output := new(testCountWriter)
+		w := NewWriter(output)
+		n, err := w.WriteBatch(headers)
+		require.NoError(t, err)
+		require.Equal(t, len(headers), n)
+		require.Equal(t, 1, output.writes)
+
+		// the Writer is not in buffered mode
+		_, err = w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+		require.Equal(t, 2, output.writes)
+
+		testReadFrameHeaders(t, NewReader(output), append(headers, testIPv4TCPFrameHeader1))
+	})
+
+	t.Run("buffered mode", func(t *testing.T) {
+		output := new(testCountWriter)
+		opts := Options{BufferRecords: 4}
+		w, err := NewWriterWithOptions(output, &opts)
+		require.NoError(t, err)
+		_, err = w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+
+		n, err := w.WriteBatch(testFrameHeaders)
+		require.NoError(t, err)
+		require.Equal(t, len(testFrameHeaders), n)
+		require.Equal(t, 1, output.writes)
+
+		expected := append([][]byte{testIPv4TCPFrameHeader1}, testFrameHeaders...)
+		testReadFrameHeaders(t, NewReader(output), expected)
+	})
+
+	t.Run("datagram", func(t *testing.T) {
+		opts := Options{Datagram: true}
+		w, r, queue := testNewDatagramPair(t, &opts)
+		n, err := w.WriteBatch(testFrameHeaders)
+		require.NoError(t, err)
+		require.Equal(t, len(testFrameHeaders), n)
+		require.Len(t, queue.packets, len(testFrameHeaders))
+
+		for _, header := range testFrameHeaders {
+			buf, err := r.ReadHeader()
+			require.NoError(t, err)
+			require.Equal(t, header, buf)
+		}
+	})
+
+	t.Run("invalid frame header", func(t *testing.T) {
+		output := new(testCountWriter)
+		w := NewWriter(output)
+		batch := [][]byte{
+			testIPv4TCPFrameHeader1,
+			make([]byte, MaxFrameHeaderSize+1),
+			testIPv4TCPFrameHeader2,
+		}
+		n, err := w.WriteBatch(batch)
+		require.ErrorIs(t, err, ErrHeaderTooLarge)
+		require.Equal(t, 1, n)
+
+		// the compressed frame headers are written
+		require.Equal(t, 1, output.writes)
+		testReadFrameHeaders(t, NewReader(output), batch[:1])
+	})
+
+	t.Run("invalid frame header in datagram", func(t *testing.T) {
+		opts := Options{Datagram: true}
+		w, _, queue := testNewDatagramPair(t, &opts)
+		batch := [][]byte{
+			testIPv4TCPFrameHeader1,
+			make([]byte, MaxFrameHeaderSize+1),
+		}
+		n, err := w.WriteBatch(batch)
+		require.ErrorIs(t, err, ErrHeaderTooLarge)
+		require.Equal(t, 1, n)
+		require.Len(t, queue.packets, 1)
+	})
+
+	t.Run("sticky error", func(t *testing.T) {
+		pr, pw := io.Pipe()
+		err := pr.Close()
+		require.NoError(t, err)
+
+		w := NewWriter(pw)
+		n, err := w.WriteBatch(testFrameHeaders)
+		require.Equal(t, io.ErrClosedPipe, err)
+		require.Equal(t, len(testFrameHeaders), n)
+
+		n, err = w.WriteBatch(testFrameHeaders)
+		require.Equal(t, io.ErrClosedPipe, err)
+		require.Zero(t, n)
+	})
+}
+
+func BenchmarkWriter_WriteBatch(b *testing.B) {
+	headers := make([][]byte, 64)
+	for i := 0; i < len(headers); i++ {
+		header := make([]byte, len(testIPv4TCPFrameHeader1))
+		copy(header, testIPv4TCPFrameHeader1)
+		header[19] = byte(i) // IPv4 ID [byte 2]
+		headers[i] = header
+	}
+	output := new(testCountWriter)
+	w := NewWriter(output)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, err := w.WriteBatch(headers)
+		if err != nil {
+			b.Fatal(err)
+		}
+		output.Reset()
+	}
+
+	b.StopTimer()
+}
@@ -0,0 +1,112 @@
+package cfh
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"time"
+)
+
+// checkpointSize is the size of the dictionary table hash in checkpoint.
+const checkpointSize = 8
+
+// ErrCheckpointMismatch is returned by Reader when the dictionary table
+// hash in checkpoint is mismatched with the table of Reader. After it is
+// returned, the Reader will discard the following records until receive
+// the reset record, so the caller should request the Writer to call the
+// Resync method through the other channel, then continue to read.
+var ErrCheckpointMismatch = errors.New("dictionary table is mismatched with checkpoint")
+
+// tableHash is used to calculate the hash about the dictionary
+// table and the last frame header, it is used in checkpoint.
+func tableHash(dict [][]byte, last []byte) [checkpointSize]byte {
+	h := fnv.New64a()
+	for i := 0; i < len(dict); i++ {
+		_, _ = h.Write([]byte{byte(len(dict[i]) >> 8), byte(len(dict[i]))})
+		_, _ = h.Write(dict[i])
+	}
+	_, _ = h.Write(last)
+	var hash [checkpointSize]byte
+	copy(hash[:], h.Sum(nil))
+	return hash
+}
+
+// needCheckpoint is used to check the Writer need to write a checkpoint
+// before the next record, it only be used in stream mode.
+func (w *Writer) needCheckpoint() bool {
+	if w.opts == nil || w.dgram != nil {
+		return false
+	}
+	interval := w.opts.CheckpointInterval
+	if interval > 0 && w.records >= interval {
+		return true
+	}
+	period := w.opts.CheckpointPeriod
+	return period > 0 && time.Since(w.checked) >= period
+}
+
+// writeCheckpoint is used to write checkpoint to the buffer before record.
+func (w *Writer) writeCheckpoint() {
+	hash := tableHash(w.dict, w.last.Bytes())
+	w.buf.WriteByte(cmdCheckpoint)
+	w.buf.Write(hash[:])
+}
+
+// resetCheckpoint is used to reset the status after write checkpoint.
+func (w *Writer) resetCheckpoint() {
+	w.records = 0
+	w.checked = time.Now()
+}
+
+// readCheckpoint is used to read checkpoint and compare it with the table.
+func (r *Reader) readCheckpoint() error {
+	err := r.readFull(r.buf[:checkpointSize])
+	if err != nil {
+		return fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	hash := tableHash(r.dict, r.last.Bytes())
+	if string(hash[:]) == string(r.buf[:checkpointSize]) {
+		return nil
+	}
+	r.desync = true
+	return ErrCheckpointMismatch
+}
+
+// skipRecord is used to discard the record when wait the reset record.
+func (r *Reader) skipRecord(cmd byte) error {
+	var size int64
+	switch cmd {
+	case cmdAddDict:
+		b, err := r.readByte()
+		if err != nil {
+			return fmt.Errorf("failed to read dictionary size: %w", err)
+		}
+		size = int64(decodeDictSize(b))
+	case cmdData, cmdSwap:
+		err := r.readFull(r.buf[:2])
+		if err != nil {
+			return fmt.Errorf("failed to read changed data size: %w", err)
+		}
+		size = int64(r.buf[1]) * 2
+	case cmdLast:
+	case cmdPrev:
+		size = 1
+	case cmdCheckpoint:
+		size = checkpointSize
+	default:
+		return fmt.Errorf("%w: %d", ErrInvalidCommand, cmd)
+	}
+	if r.crc && cmd != cmdCheckpoint {
+		size += checksumSize
+	}
+	n, err := io.CopyN(io.Discard, r.br, size)
+	r.consumed += uint64(n)
+	if err == io.EOF {
+		err = fmt.Errorf("%w: %w", ErrTruncated, io.ErrUnexpectedEOF)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to skip record: %w", err)
+	}
+	return nil
+}
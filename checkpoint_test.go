@@ -0,0 +1,189 @@
+package cfh
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckpoint(t *testing.T) {
+	headers := testGenerateFrameHeaders(t)
+
+	for _, item := range []*struct {
+		name string
+		opts Options
+	}{
+		{"interval", Options{CheckpointInterval: 16}},
+		{"period", Options{CheckpointPeriod: time.Nanosecond}},
+		{"checksum", Options{CheckpointInterval: 1, Checksum: true}},
+	} {
+		t.Run(item.name, func(t *testing.T) {
+			output := bytes.NewBuffer(make([]byte, 0, 4*1024*1024))
+			w, err := NewWriterWithOptions(output, &item.opts)
+			require.NoError(t, err)
+			for _, header := range headers {
+				_, err = w.Write(header)
+				require.NoError(t, err)
+			}
+
+			r := NewReader(output)
+			for _, header := range headers {
+				buf := make([]byte, len(header))
+				_, err = r.Read(buf)
+				require.NoError(t, err)
+				require.Equal(t, header, buf)
+			}
+		})
+	}
+
+	t.Run("write checkpoint", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+		opts := Options{CheckpointInterval: 2}
+		w, err := NewWriterWithOptions(output, &opts)
+		require.NoError(t, err)
+
+		for i := 0; i < 2; i++ {
+			_, err = w.Write(testIPv4TCPFrameHeader1)
+			require.NoError(t, err)
+		}
+		offset := output.Len()
+		_, err = w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+
+		record := output.Bytes()[offset:]
+		hash := tableHash(w.dict, testIPv4TCPFrameHeader1)
+		expected := append([]byte{cmdCheckpoint}, hash[:]...)
+		expected = append(expected, cmdLast)
+		require.Equal(t, expected, record)
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 1024))
+		opts := Options{CheckpointInterval: 1}
+		w, err := NewWriterWithOptions(output, &opts)
+		require.NoError(t, err)
+		r, err := NewReaderWithOptions(output, &opts)
+		require.NoError(t, err)
+
+		_, err = w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+		buf := make([]byte, len(testIPv4TCPFrameHeader1))
+		_, err = r.Read(buf)
+		require.NoError(t, err)
+
+		// make the table of Reader diverged
+		r.dict[0][0]++
+
+		for _, header := range [][]byte{
+			testIPv4TCPFrameHeader2,
+			testIPv4TCPFrameHeader1,
+			testIPv4TCPFrameHeader3,
+		} {
+			_, err = w.Write(header)
+			require.NoError(t, err)
+		}
+		n, err := r.Read(buf)
+		require.Equal(t, ErrCheckpointMismatch, err)
+		require.Zero(t, n)
+
+		// the following records are discarded until reset
+		err = w.Resync()
+		require.NoError(t, err)
+		_, err = w.Write(testIPv4TCPFrameHeader3)
+		require.NoError(t, err)
+		_, err = r.Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, testIPv4TCPFrameHeader3, buf)
+	})
+
+	t.Run("skip records with checksum", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 1024))
+		opts := Options{CheckpointInterval: 1, Checksum: true}
+		w, err := NewWriterWithOptions(output, &opts)
+		require.NoError(t, err)
+		r, err := NewReaderWithOptions(output, &opts)
+		require.NoError(t, err)
+
+		changed := bytes.Clone(testIPv4TCPFrameHeader1)
+		changed[len(changed)-1]++
+		_, err = w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+		buf := make([]byte, len(testIPv4TCPFrameHeader1))
+		_, err = r.Read(buf)
+		require.NoError(t, err)
+		r.last.Reset()
+
+		for _, header := range [][]byte{
+			testIPv4TCPFrameHeader1,
+			testIPv4TCPFrameHeader1,
+			changed,
+			testIPv4TCPFrameHeader1,
+			testIPv6TCPFrameHeader1,
+		} {
+			_, err = w.Write(header)
+			require.NoError(t, err)
+		}
+		_, err = r.Read(buf)
+		require.Equal(t, ErrCheckpointMismatch, err)
+
+		err = w.Resync()
+		require.NoError(t, err)
+		_, err = w.Write(changed)
+		require.NoError(t, err)
+		_, err = r.Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, changed, buf)
+	})
+
+	t.Run("failed to read checkpoint", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+		output.Write([]byte{cmdCheckpoint, 0})
+
+		r := NewReader(output)
+		buf := make([]byte, 16)
+		n, err := r.Read(buf)
+		errStr := "failed to read checkpoint: record is truncated: unexpected EOF"
+		require.EqualError(t, err, errStr)
+		require.Zero(t, n)
+	})
+
+	t.Run("failed to skip record", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+		output.Write([]byte{cmdAddDict, 4, 1})
+
+		r := NewReader(output)
+		r.desync = true
+		buf := make([]byte, 16)
+		n, err := r.Read(buf)
+		require.EqualError(t, err, "failed to skip record: record is truncated: unexpected EOF")
+		require.Zero(t, n)
+	})
+
+	t.Run("invalid command", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+		output.Write([]byte{0})
+
+		r := NewReader(output)
+		r.desync = true
+		buf := make([]byte, 16)
+		n, err := r.Read(buf)
+		require.EqualError(t, err, "invalid decompress command: 0")
+		require.Zero(t, n)
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+
+		opts := Options{CheckpointInterval: -1}
+		w, err := NewWriterWithOptions(output, &opts)
+		require.EqualError(t, err, "invalid checkpoint interval: -1")
+		require.Nil(t, w)
+
+		opts = Options{CheckpointPeriod: -time.Second}
+		w, err = NewWriterWithOptions(output, &opts)
+		require.EqualError(t, err, "invalid checkpoint period: -1s")
+		require.Nil(t, w)
+	})
+}
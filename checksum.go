@@ -0,0 +1,64 @@
+package cfh
+
+import (
+	"fmt"
+)
+
+// checksumSize is the size of the checksum appended to each record.
+const checksumSize = 2
+
+// crc16Table is the table about CRC-16/CCITT-FALSE, the polynomial
+// is 0x1021 and the initial value is 0xFFFF.
+var crc16Table = makeCRC16Table(0x1021)
+
+func makeCRC16Table(poly uint16) *[256]uint16 {
+	table := new([256]uint16)
+	for i := 0; i < 256; i++ {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ poly
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}
+
+// checksum is used to calculate the CRC-16 about the frame header.
+func checksum(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for i := 0; i < len(data); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^data[i]]
+	}
+	return crc
+}
+
+// CorruptionError is returned by Reader when the checksum of the record
+// is mismatched with the reconstructed frame header. In stream mode the
+// Reader will skip the following records until the Writer call Resync,
+// in datagram mode the affected dictionary is discarded, and the Reader
+// can continue to read the next record.
+type CorruptionError struct {
+	Expected uint16 // the checksum in the record
+	Actual   uint16 // the checksum of the reconstructed frame header
+}
+
+func (e *CorruptionError) Error() string {
+	const format = "frame header is corrupted: checksum is 0x%04X, expected 0x%04X"
+	return fmt.Sprintf(format, e.Actual, e.Expected)
+}
+
+// verifyChecksum is used to compare the checksum in record with the data.
+func verifyChecksum(expected uint16, data []byte) error {
+	actual := checksum(data)
+	if actual == expected {
+		return nil
+	}
+	return &CorruptionError{
+		Expected: expected,
+		Actual:   actual,
+	}
+}
@@ -0,0 +1,188 @@
+package cfh
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecksum(t *testing.T) {
+	require.Equal(t, uint16(0x29B1), checksum([]byte("123456789")))
+	require.Equal(t, uint16(0xFFFF), checksum(nil))
+}
+
+func TestCorruptionError(t *testing.T) {
+	err := &CorruptionError{Expected: 0x1234, Actual: 0xABCD}
+	errStr := "frame header is corrupted: checksum is 0xABCD, expected 0x1234"
+	require.EqualError(t, err, errStr)
+}
+
+func TestReader_Checksum(t *testing.T) {
+	headers := testGenerateFrameHeaders(t)
+	changed := bytes.Clone(testIPv4TCPFrameHeader1)
+	changed[len(changed)-1]++
+
+	t.Run("common", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 4*1024*1024))
+		opts := Options{Checksum: true}
+		w, err := NewWriterWithOptions(output, &opts)
+		require.NoError(t, err)
+		for _, header := range headers {
+			_, err = w.Write(header)
+			require.NoError(t, err)
+		}
+
+		// the Reader will use the checksum in stream parameters
+		r := NewReader(output)
+		for _, header := range headers {
+			buf := make([]byte, len(header))
+			_, err = r.Read(buf)
+			require.NoError(t, err)
+			require.Equal(t, header, buf)
+		}
+	})
+
+	t.Run("corrupted record", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+		opts := Options{Checksum: true}
+		w, err := NewWriterWithOptions(output, &opts)
+		require.NoError(t, err)
+		_, err = w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+		offset := output.Len()
+		_, err = w.Write(changed)
+		require.NoError(t, err)
+		// flip a bit in the changed data
+		output.Bytes()[offset+4] ^= 1
+
+		r, err := NewReaderWithOptions(output, &opts)
+		require.NoError(t, err)
+		buf := make([]byte, len(testIPv4TCPFrameHeader1))
+		_, err = r.Read(buf)
+		require.NoError(t, err)
+
+		n, err := r.Read(buf)
+		var ce *CorruptionError
+		require.True(t, errors.As(err, &ce))
+		require.Equal(t, checksum(changed), ce.Expected)
+		require.Zero(t, n)
+
+		// the records are skipped until reset in stream mode
+		_, err = w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+		err = w.Resync()
+		require.NoError(t, err)
+		_, err = w.Write(changed)
+		require.NoError(t, err)
+
+		n, err = r.Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, changed, buf[:n])
+	})
+
+	t.Run("failed to read checksum", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+		opts := Options{Checksum: true}
+		w, err := NewWriterWithOptions(output, &opts)
+		require.NoError(t, err)
+		_, err = w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+		output.Truncate(output.Len() - 1)
+
+		r, err := NewReaderWithOptions(output, &opts)
+		require.NoError(t, err)
+		buf := make([]byte, len(testIPv4TCPFrameHeader1))
+		n, err := r.Read(buf)
+		errStr := "failed to read checksum: record is truncated: unexpected EOF"
+		require.EqualError(t, err, errStr)
+		require.Zero(t, n)
+	})
+
+	t.Run("mismatched options", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+		opts := Options{Checksum: true}
+		w, err := NewWriterWithOptions(output, &opts)
+		require.NoError(t, err)
+		_, err = w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+
+		r, err := NewReaderWithOptions(output, nil)
+		require.NoError(t, err)
+		buf := make([]byte, len(testIPv4TCPFrameHeader1))
+		_, err = r.Read(buf)
+		require.EqualError(t, err, "stream parameters are mismatched with options")
+	})
+
+	t.Run("datagram", func(t *testing.T) {
+		opts := Options{
+			DictSize: 64,
+			Datagram: true,
+			Checksum: true,
+		}
+		w, r, _ := testNewDatagramPair(t, &opts)
+		for _, header := range headers {
+			_, err := w.Write(header)
+			require.NoError(t, err)
+
+			buf := make([]byte, len(header))
+			_, err = r.Read(buf)
+			require.NoError(t, err)
+			require.Equal(t, header, buf)
+		}
+	})
+
+	t.Run("corrupted datagram", func(t *testing.T) {
+		opts := Options{
+			Datagram: true,
+			Checksum: true,
+			Feedback: FeedbackOptimistic,
+		}
+		w, r, queue := testNewDatagramPair(t, &opts)
+
+		_, err := w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+		_, err = w.Write(changed)
+		require.NoError(t, err)
+		_, err = w.Write(changed)
+		require.NoError(t, err)
+		// flip a bit in the changed data
+		queue.packets[1][8] ^= 1
+
+		buf := make([]byte, len(testIPv4TCPFrameHeader1))
+		_, err = r.Read(buf)
+		require.NoError(t, err)
+		_, err = r.Read(buf)
+		var ce *CorruptionError
+		require.True(t, errors.As(err, &ce))
+
+		// the corrupted dictionary is discarded
+		_, err = r.Read(buf)
+		require.ErrorIs(t, err, ErrMissedUpdate)
+		msg := r.Feedback()
+		require.Equal(t, []byte{feedbackNACK, 0, 0, 0}, msg)
+
+		err = w.ProcessFeedback(msg)
+		require.NoError(t, err)
+		_, err = w.Write(changed)
+		require.NoError(t, err)
+		_, err = r.Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, changed, buf)
+	})
+
+	t.Run("truncated datagram", func(t *testing.T) {
+		opts := Options{
+			Datagram: true,
+			Checksum: true,
+		}
+		queue := &testPacketQueue{packets: [][]byte{{0, 1, cmdPrev, 0}}}
+		r, err := NewReaderWithOptions(queue, &opts)
+		require.NoError(t, err)
+
+		buf := make([]byte, 64)
+		_, err = r.Read(buf)
+		require.EqualError(t, err, "failed to decode datagram: record is truncated")
+	})
+}
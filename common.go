@@ -1,7 +1,12 @@
 package cfh
 
 import (
+	"crypto/sha256"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
 )
 
 const (
@@ -12,13 +17,55 @@ const (
 	MaxDictionarySize = 256
 )
 
+// errors about the Writer and the Reader.
+var (
+	// ErrInvalidCommand is returned by Reader when read an unknown command.
+	ErrInvalidCommand = errors.New("invalid decompress command")
+
+	// ErrInvalidDictIndex is returned by Reader when the record references
+	// a dictionary that is out of the table or not exists.
+	ErrInvalidDictIndex = errors.New("invalid dictionary index")
+
+	// ErrTruncated is returned by Reader when the stream is ended in the
+	// middle of a record, or the datagram is shorter than the record.
+	ErrTruncated = errors.New("record is truncated")
+
+	// ErrHeaderTooLarge is returned by Writer when write frame header that
+	// is larger than MaxFrameHeaderSize.
+	ErrHeaderTooLarge = errors.New("frame header is too large")
+)
+
 const (
 	cmdAddDict = 1 + iota
 	cmdData
 	cmdLast
 	cmdPrev
+	cmdParams
+	cmdReset
+	cmdCheckpoint
+	cmdSwap
 )
 
+// size of the stream parameters record without optional fields.
+const paramsSize = 1 + 1 + 1 + 1 + 1
+
+// flags in the stream parameters record.
+const (
+	// pre-shared dictionaries hash is appended
+	flagDictionaries = 1 << iota
+
+	// checksum is appended to each record
+	flagChecksum
+
+	// the record with swapped direction may be written
+	flagBidirectional
+)
+
+// size of the pre-shared dictionaries hash.
+const dictHashSize = 8
+
+const defaultKeyframeInterval = 32
+
 const (
 	ethernetIPv4TCPSize = 14 + 20 + 20
 	ethernetIPv4UDPSize = 14 + 20 + 8
@@ -32,6 +79,261 @@ const (
 	maxDiffDiv = 4
 )
 
+// Options contains the options about Writer and Reader, the Writer
+// created with options will write the stream parameters at the
+// beginning of the stream, then the Reader will use the same
+// parameters to maintain the dictionary table.
+type Options struct {
+	// DictSize is the number of dictionaries, default is 256.
+	DictSize int
+
+	// Policy is the dictionary eviction policy, default is MRU.
+	Policy EvictionPolicy
+
+	// PinnedSize is the number of pinned dictionaries,
+	// it is only used with the EvictPinned policy.
+	PinnedSize int
+
+	// Dictionaries are the pre-shared dictionaries, they will be
+	// added to the table in order when create Writer or Reader.
+	// The hash of them is written in the stream parameters, so
+	// the Reader can detect the mismatched dictionaries.
+	Dictionaries [][]byte
+
+	// Datagram is used to enable the loss-tolerant datagram mode,
+	// each record is written by one Write call, and the Reader
+	// must read one record by one Read call from the under r.
+	// The stream parameters are not written in this mode.
+	Datagram bool
+
+	// KeyframeInterval is the maximum number of records that reference
+	// a dictionary before the Writer write the full frame header to it
+	// again, it is only used in datagram mode, default is 32.
+	KeyframeInterval int
+
+	// Feedback is the feedback mode in datagram mode, the Reader will
+	// produce the feedback messages and the Writer will consume them,
+	// default is FeedbackNone.
+	Feedback FeedbackMode
+
+	// Checksum is used to append the CRC-16 about the frame header to
+	// each record, then the Reader can detect the corrupted record.
+	Checksum bool
+
+	// CheckpointInterval is the number of records between two checkpoints,
+	// the checkpoint contains the hash of the dictionary table, then the
+	// Reader can detect the table is diverged. It is only used in stream
+	// mode, default is zero that means disabled.
+	CheckpointInterval int
+
+	// CheckpointPeriod is the minimum time between two checkpoints, it can
+	// be used with CheckpointInterval, the checkpoint is written before the
+	// next record. It is only used in stream mode, default is disabled.
+	CheckpointPeriod time.Duration
+
+	// Bidirectional is used to make the Writer also search the dictionary
+	// about the reverse direction of the flow, then the frame header will
+	// be written as the changed data with the swapped dictionary, so one
+	// dictionary can serve both directions. It is only used in stream mode
+	// and the Ethernet frame headers that can be compressed by fast mode.
+	Bidirectional bool
+
+	// BufferSize is used to enable the buffered mode, the Writer will
+	// append the records to the buffer and write them to the under w
+	// by one Write call when the size of them reaches it, or the Flush
+	// method is called. It is only used in stream mode, default is zero
+	// that means disabled.
+	BufferSize int
+
+	// BufferRecords is like BufferSize, but the threshold is the number
+	// of the buffered records, they can be used at the same time.
+	BufferRecords int
+}
+
+func (opts *Options) apply() (*Options, error) {
+	o := Options{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.DictSize == 0 {
+		o.DictSize = MaxDictionarySize
+	}
+	err := checkDictSize(o.DictSize)
+	if err != nil {
+		return nil, err
+	}
+	if o.Policy != EvictPinned {
+		o.PinnedSize = 0
+	}
+	_, err = o.newEvictor()
+	if err != nil {
+		return nil, err
+	}
+	if o.KeyframeInterval < 0 {
+		return nil, fmt.Errorf("invalid keyframe interval: %d", o.KeyframeInterval)
+	}
+	if o.KeyframeInterval == 0 {
+		o.KeyframeInterval = defaultKeyframeInterval
+	}
+	if o.Feedback > FeedbackReliable {
+		return nil, fmt.Errorf("invalid feedback mode: %d", o.Feedback)
+	}
+	if !o.Datagram {
+		o.Feedback = FeedbackNone
+	}
+	if o.CheckpointInterval < 0 {
+		return nil, fmt.Errorf("invalid checkpoint interval: %d", o.CheckpointInterval)
+	}
+	if o.CheckpointPeriod < 0 {
+		return nil, fmt.Errorf("invalid checkpoint period: %s", o.CheckpointPeriod)
+	}
+	if o.BufferSize < 0 {
+		return nil, fmt.Errorf("invalid buffer size: %d", o.BufferSize)
+	}
+	if o.BufferRecords < 0 {
+		return nil, fmt.Errorf("invalid buffer records: %d", o.BufferRecords)
+	}
+	if o.Datagram {
+		o.CheckpointInterval = 0
+		o.CheckpointPeriod = 0
+		o.BufferSize = 0
+		o.BufferRecords = 0
+		o.Bidirectional = false
+	}
+	if len(o.Dictionaries) > o.DictSize {
+		return nil, errors.New("too many pre-shared dictionaries")
+	}
+	for i := 0; i < len(o.Dictionaries); i++ {
+		l := len(o.Dictionaries[i])
+		if l < 1 || l > MaxFrameHeaderSize {
+			return nil, fmt.Errorf("invalid pre-shared dictionary size: %d", l)
+		}
+	}
+	return &o, nil
+}
+
+func (opts *Options) newEvictor() (evictor, error) {
+	if opts.Datagram {
+		return newStableEvictor(opts.Policy, opts.DictSize, opts.PinnedSize)
+	}
+	return newEvictor(opts.Policy, opts.DictSize, opts.PinnedSize)
+}
+
+// streamParams contains the fields in stream parameters record.
+type streamParams struct {
+	dictSize int
+	policy   EvictionPolicy
+	pinned   int
+	flags    byte
+	dictHash [dictHashSize]byte
+}
+
+func (opts *Options) params() *streamParams {
+	params := streamParams{
+		dictSize: opts.DictSize,
+		policy:   opts.Policy,
+		pinned:   opts.PinnedSize,
+	}
+	if len(opts.Dictionaries) != 0 {
+		params.flags |= flagDictionaries
+		params.dictHash = dictionariesHash(opts.Dictionaries)
+	}
+	if opts.Checksum {
+		params.flags |= flagChecksum
+	}
+	if opts.Bidirectional {
+		params.flags |= flagBidirectional
+	}
+	return &params
+}
+
+// encode is used to encode the stream parameters record.
+func (p *streamParams) encode() []byte {
+	params := make([]byte, paramsSize, paramsSize+dictHashSize)
+	params[0] = cmdParams
+	params[1] = byte(p.dictSize - 1)
+	params[2] = byte(p.policy)
+	params[3] = byte(p.pinned)
+	params[4] = p.flags
+	if p.flags&flagDictionaries != 0 {
+		params = append(params, p.dictHash[:]...)
+	}
+	return params
+}
+
+// decodeParams is used to decode the stream parameters record without
+// command and optional fields, then check the parameters are valid.
+func decodeParams(params []byte) (*streamParams, error) {
+	p := streamParams{
+		dictSize: int(params[0]) + 1,
+		policy:   EvictionPolicy(params[1]),
+		pinned:   int(params[2]),
+		flags:    params[3],
+	}
+	if p.flags&^(flagDictionaries|flagChecksum|flagBidirectional) != 0 {
+		return nil, fmt.Errorf("invalid stream parameters flags: %d", p.flags)
+	}
+	_, err := newEvictor(p.policy, p.dictSize, p.pinned)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// dictionariesHash is used to calculate the identifier of the pre-shared
+// dictionaries, it is the prefix of the SHA-256 about the dictionaries.
+func dictionariesHash(dicts [][]byte) [dictHashSize]byte {
+	h := sha256.New()
+	size := make([]byte, 2)
+	for i := 0; i < len(dicts); i++ {
+		binary.BigEndian.PutUint16(size, uint16(len(dicts[i])))
+		h.Write(size)
+		h.Write(dicts[i])
+	}
+	var hash [dictHashSize]byte
+	copy(hash[:], h.Sum(nil))
+	return hash
+}
+
+// seedDictionaries is used to add the pre-shared dictionaries to table.
+func seedDictionaries(dict [][]byte, evict evictor, dicts [][]byte) {
+	for i := 0; i < len(dicts); i++ {
+		d := make([]byte, len(dicts[i]))
+		copy(d, dicts[i])
+		evict.add(dict, d)
+	}
+}
+
+// readFull is like io.ReadFull, but the io.EOF and io.ErrUnexpectedEOF
+// are replaced with ErrTruncated, it is used to read the rest of record.
+func readFull(r io.Reader, b []byte) error {
+	_, err := io.ReadFull(r, b)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%w: %w", ErrTruncated, io.ErrUnexpectedEOF)
+	}
+	return err
+}
+
+// decodeDictSize is used to decode the size of dictionary in record, the
+// size is stored in one byte, zero means MaxFrameHeaderSize because the
+// empty dictionary is invalid and byte(256) is zero.
+func decodeDictSize(size byte) int {
+	if size == 0 {
+		return MaxFrameHeaderSize
+	}
+	return int(size)
+}
+
+func checkDictSize(size int) error {
+	if size < 1 {
+		return errors.New("dictionary size cannot less than 1")
+	}
+	if size > MaxDictionarySize {
+		return errors.New("dictionary size cannot greater than 256")
+	}
+	return nil
+}
+
 // IsFrameHeaderPreferBeCompressed is used to check
 // frame header can be compressed by fast mode.
 // If frame header is preferred be compressed, it will
@@ -0,0 +1,235 @@
+package cfh
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// maxConnFrameSize is the maximum size of the payload or the frame that
+// not be compressed, it is stored in two bytes.
+const maxConnFrameSize = 65535
+
+// types of the frame in Conn.
+const (
+	// the whole frame is written without compression
+	connFrameRaw = iota
+
+	// the frame header is compressed, then the payload is written
+	connFrameCompressed
+)
+
+// Conn is used to wrap a stream connection like TCP, it will compress the
+// header of the Ethernet frame and transfer the frame with the payload.
+// WriteFrame and ReadFrame can be called concurrently with each other,
+// Read and Write are the same as ReadFrame and WriteFrame.
+//
+// Each frame is started with a type byte, the frame that the header is not
+// preferred be compressed is written without compression.
+//
+// +------+--------------+--------------+-----------+
+// | type | frame header | payload size |  payload  |
+// +------+--------------+--------------+-----------+
+// | byte |  var records |    uint16    | var bytes |
+// +------+--------------+--------------+-----------+
+//
+// +------+------------+-----------+
+// | type | frame size |   frame   |
+// +------+------------+-----------+
+// | byte |   uint16   | var bytes |
+// +------+------------+-----------+
+type Conn struct {
+	net.Conn
+
+	// about write frame
+	wmu  sync.Mutex
+	w    *Writer
+	wbuf bytes.Buffer
+	werr error
+
+	// about read frame
+	rmu   sync.Mutex
+	r     *Reader
+	br    *bufio.Reader
+	frame []byte
+	rerr  error
+}
+
+// NewConn is used to create a new Conn with options, the options are used
+// to create the Writer and the Reader, so the both sides must use the same
+// options. The datagram mode and the buffered mode are not supported.
+func NewConn(conn net.Conn, opts *Options) (*Conn, error) {
+	err := checkStreamOptions(opts, "Conn")
+	if err != nil {
+		return nil, err
+	}
+	c := Conn{
+		Conn:  conn,
+		br:    bufio.NewReader(conn),
+		frame: make([]byte, MaxFrameHeaderSize+maxConnFrameSize),
+	}
+	w, err := NewWriterWithOptions(&c.wbuf, opts)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewReaderWithOptions(c.br, opts)
+	if err != nil {
+		return nil, err
+	}
+	c.w = w
+	c.r = r
+	return &c, nil
+}
+
+// checkStreamOptions is used to check the options can be used by the type
+// that writes each record with its own framing.
+func checkStreamOptions(opts *Options, typ string) error {
+	if opts == nil {
+		return nil
+	}
+	if opts.Datagram {
+		return fmt.Errorf("datagram mode is not supported by %s", typ)
+	}
+	if opts.BufferSize != 0 || opts.BufferRecords != 0 {
+		return fmt.Errorf("buffered mode is not supported by %s", typ)
+	}
+	return nil
+}
+
+// WriteFrame is used to compress the frame header and write the frame to
+// the under connection by one Write call. The error about the under
+// connection is sticky.
+func (c *Conn) WriteFrame(frame []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.werr != nil {
+		return c.werr
+	}
+	c.wbuf.Reset()
+	size, prefer := IsFrameHeaderPreferBeCompressed(frame)
+	if prefer {
+		payload := frame[size:]
+		if len(payload) > maxConnFrameSize {
+			return fmt.Errorf("payload is too large: %d", len(payload))
+		}
+		c.wbuf.WriteByte(connFrameCompressed)
+		_, err := c.w.Write(frame[:size])
+		if err != nil {
+			return err
+		}
+		c.writeSize(len(payload))
+		c.wbuf.Write(payload)
+	} else {
+		if len(frame) > maxConnFrameSize {
+			return fmt.Errorf("frame is too large: %d", len(frame))
+		}
+		c.wbuf.WriteByte(connFrameRaw)
+		c.writeSize(len(frame))
+		c.wbuf.Write(frame)
+	}
+	_, err := c.Conn.Write(c.wbuf.Bytes())
+	if err != nil {
+		c.werr = err
+		return err
+	}
+	return nil
+}
+
+func (c *Conn) writeSize(size int) {
+	c.wbuf.WriteByte(byte(size >> 8))
+	c.wbuf.WriteByte(byte(size))
+}
+
+// ReadFrame is used to read one frame from the under connection and
+// decompress the frame header. The returned slice is only valid until
+// the next call. If the error is occurred after read a part of the frame,
+// it is sticky, so the timeout before read a frame can be retried.
+func (c *Conn) ReadFrame() ([]byte, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	if c.rerr != nil {
+		return nil, c.rerr
+	}
+	typ, err := c.br.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	frame, err := c.readFrame(typ)
+	if err != nil {
+		c.rerr = err
+		return nil, err
+	}
+	return frame, nil
+}
+
+func (c *Conn) readFrame(typ byte) ([]byte, error) {
+	switch typ {
+	case connFrameRaw:
+		size, err := c.readSize()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read frame size: %w", err)
+		}
+		frame := c.frame[:size]
+		err = readFull(c.br, frame)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read frame: %w", err)
+		}
+		return frame, nil
+	case connFrameCompressed:
+		header, err := c.r.ReadHeader()
+		if err == io.EOF {
+			err = fmt.Errorf("%w: %w", ErrTruncated, io.ErrUnexpectedEOF)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read frame header: %w", err)
+		}
+		n := copy(c.frame, header)
+		size, err := c.readSize()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read payload size: %w", err)
+		}
+		frame := c.frame[:n+size]
+		err = readFull(c.br, frame[n:])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read payload: %w", err)
+		}
+		return frame, nil
+	default:
+		return nil, fmt.Errorf("invalid frame type: %d", typ)
+	}
+}
+
+func (c *Conn) readSize() (int, error) {
+	var buf [2]byte
+	err := readFull(c.br, buf[:])
+	if err != nil {
+		return 0, err
+	}
+	return int(binary.BigEndian.Uint16(buf[:])), nil
+}
+
+// Write is used to write one frame, it is the same as WriteFrame.
+func (c *Conn) Write(b []byte) (int, error) {
+	err := c.WriteFrame(b)
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Read is used to read one frame and copy to b, if b is smaller than
+// the frame, it will return io.ErrShortBuffer and the frame is discarded.
+func (c *Conn) Read(b []byte) (int, error) {
+	frame, err := c.ReadFrame()
+	if err != nil {
+		return 0, err
+	}
+	if len(b) < len(frame) {
+		return 0, io.ErrShortBuffer
+	}
+	return copy(b, frame), nil
+}
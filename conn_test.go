@@ -0,0 +1,276 @@
+package cfh
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testGenerateFrames(t *testing.T, n int) [][]byte {
+	headers := testGenerateFrameHeaders(t)[:n]
+	frames := make([][]byte, n)
+	size := make([]byte, 1)
+	for i, header := range headers {
+		_, err := rand.Read(size)
+		require.NoError(t, err)
+		frame := make([]byte, len(header)+int(size[0]))
+		copy(frame, header)
+		_, err = rand.Read(frame[len(header):])
+		require.NoError(t, err)
+		frames[i] = frame
+	}
+	return frames
+}
+
+func testConnTransfer(t *testing.T, client, server *Conn, frames [][]byte) {
+	errCh := make(chan error, 1)
+	go func() {
+		for _, frame := range frames {
+			err := client.WriteFrame(frame)
+			if err != nil {
+				errCh <- err
+				return
+			}
+		}
+		errCh <- nil
+	}()
+	for _, frame := range frames {
+		buf, err := server.ReadFrame()
+		require.NoError(t, err)
+		require.Equal(t, frame, buf)
+	}
+	require.NoError(t, <-errCh)
+}
+
+func TestConn(t *testing.T) {
+	frames := testGenerateFrames(t, 4096)
+
+	t.Run("pipe", func(t *testing.T) {
+		p1, p2 := net.Pipe()
+		client, err := NewConn(p1, nil)
+		require.NoError(t, err)
+		server, err := NewConn(p2, nil)
+		require.NoError(t, err)
+
+		testConnTransfer(t, client, server, frames)
+		testConnTransfer(t, server, client, frames)
+
+		err = client.Close()
+		require.NoError(t, err)
+		_, err = server.ReadFrame()
+		require.Equal(t, io.EOF, err)
+	})
+
+	t.Run("tcp", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer func() { _ = listener.Close() }()
+
+		opts := Options{
+			DictSize: 64,
+			Checksum: true,
+		}
+		connCh := make(chan net.Conn, 1)
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				connCh <- nil
+				return
+			}
+			connCh <- conn
+		}()
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		require.NoError(t, err)
+		client, err := NewConn(conn, &opts)
+		require.NoError(t, err)
+		defer func() { _ = client.Close() }()
+		conn = <-connCh
+		require.NotNil(t, conn)
+		server, err := NewConn(conn, &opts)
+		require.NoError(t, err)
+		defer func() { _ = server.Close() }()
+
+		testConnTransfer(t, client, server, frames)
+		testConnTransfer(t, server, client, frames)
+	})
+
+	t.Run("Read and Write", func(t *testing.T) {
+		p1, p2 := net.Pipe()
+		client, err := NewConn(p1, nil)
+		require.NoError(t, err)
+		server, err := NewConn(p2, nil)
+		require.NoError(t, err)
+
+		frame := frames[0]
+		go func() {
+			_, _ = client.Write(frame)
+			_, _ = client.Write(frame)
+		}()
+		buf := make([]byte, 4096)
+		n, err := server.Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, frame, buf[:n])
+
+		n, err = server.Read(buf[:len(frame)-1])
+		require.Equal(t, io.ErrShortBuffer, err)
+		require.Zero(t, n)
+	})
+
+	t.Run("raw frame", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+		c, err := NewConn(&testBufferConn{Buffer: output}, nil)
+		require.NoError(t, err)
+
+		frame := []byte{1, 2, 3, 4}
+		err = c.WriteFrame(frame)
+		require.NoError(t, err)
+		require.Equal(t, []byte{connFrameRaw, 0, 4, 1, 2, 3, 4}, output.Bytes())
+
+		buf, err := c.ReadFrame()
+		require.NoError(t, err)
+		require.Equal(t, frame, buf)
+	})
+
+	t.Run("too large frame", func(t *testing.T) {
+		c, err := NewConn(&testBufferConn{Buffer: new(bytes.Buffer)}, nil)
+		require.NoError(t, err)
+
+		frame := make([]byte, maxConnFrameSize+1)
+		err = c.WriteFrame(frame)
+		require.EqualError(t, err, "frame is too large: 65536")
+
+		frame = make([]byte, ethernetIPv4TCPSize+maxConnFrameSize+1)
+		copy(frame, testIPv4TCPFrameHeader1)
+		err = c.WriteFrame(frame)
+		require.EqualError(t, err, "payload is too large: 65536")
+	})
+
+	t.Run("read timeout", func(t *testing.T) {
+		p1, p2 := net.Pipe()
+		client, err := NewConn(p1, nil)
+		require.NoError(t, err)
+		server, err := NewConn(p2, nil)
+		require.NoError(t, err)
+
+		err = server.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+		require.NoError(t, err)
+		_, err = server.ReadFrame()
+		require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+		// the timeout before read a frame is not sticky
+		err = server.SetReadDeadline(time.Time{})
+		require.NoError(t, err)
+		testConnTransfer(t, client, server, frames[:16])
+	})
+
+	t.Run("failed to write", func(t *testing.T) {
+		p1, p2 := net.Pipe()
+		err := p2.Close()
+		require.NoError(t, err)
+		c, err := NewConn(p1, nil)
+		require.NoError(t, err)
+
+		err = c.WriteFrame(frames[0])
+		require.Equal(t, io.ErrClosedPipe, err)
+		// the error is sticky
+		err = c.WriteFrame(frames[0])
+		require.Equal(t, io.ErrClosedPipe, err)
+	})
+
+	t.Run("invalid frame", func(t *testing.T) {
+		for _, item := range []*struct {
+			name   string
+			data   []byte
+			errStr string
+		}{
+			{"invalid type", []byte{2}, "invalid frame type: 2"},
+			{
+				"truncated frame size", []byte{connFrameRaw, 0},
+				"failed to read frame size: record is truncated: unexpected EOF",
+			},
+			{
+				"truncated frame", []byte{connFrameRaw, 0, 4, 1},
+				"failed to read frame: record is truncated: unexpected EOF",
+			},
+			{
+				"truncated frame header", []byte{connFrameCompressed},
+				"failed to read frame header: record is truncated: unexpected EOF",
+			},
+			{
+				"invalid frame header", []byte{connFrameCompressed, cmdParams, 0},
+				"failed to read frame header: failed to read stream parameters: " +
+					"record is truncated: unexpected EOF",
+			},
+		} {
+			t.Run(item.name, func(t *testing.T) {
+				output := bytes.NewBuffer(item.data)
+				c, err := NewConn(&testBufferConn{Buffer: output}, nil)
+				require.NoError(t, err)
+
+				frame, err := c.ReadFrame()
+				require.EqualError(t, err, item.errStr)
+				require.Nil(t, frame)
+
+				// the error is sticky
+				_, err = c.ReadFrame()
+				require.EqualError(t, err, item.errStr)
+			})
+		}
+	})
+
+	t.Run("truncated payload", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 256))
+		c, err := NewConn(&testBufferConn{Buffer: output}, nil)
+		require.NoError(t, err)
+		frame := append(bytes.Clone(testIPv4TCPFrameHeader1), "payload"...)
+		err = c.WriteFrame(frame)
+		require.NoError(t, err)
+
+		data := output.Bytes()
+		for _, l := range []int{len(data) - len(frame) + ethernetIPv4TCPSize - 1, len(data) - 1} {
+			c, err = NewConn(&testBufferConn{Buffer: bytes.NewBuffer(data[:l])}, nil)
+			require.NoError(t, err)
+			_, err = c.ReadFrame()
+			require.ErrorIs(t, err, ErrTruncated)
+		}
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		conn := &testBufferConn{Buffer: new(bytes.Buffer)}
+
+		opts := Options{Datagram: true}
+		c, err := NewConn(conn, &opts)
+		require.EqualError(t, err, "datagram mode is not supported by Conn")
+		require.Nil(t, c)
+
+		opts = Options{BufferSize: 4096}
+		c, err = NewConn(conn, &opts)
+		require.EqualError(t, err, "buffered mode is not supported by Conn")
+		require.Nil(t, c)
+
+		opts = Options{DictSize: -1}
+		c, err = NewConn(conn, &opts)
+		require.EqualError(t, err, "dictionary size cannot less than 1")
+		require.Nil(t, c)
+	})
+}
+
+// testBufferConn is used to test the Conn with a buffer.
+type testBufferConn struct {
+	net.Conn
+	*bytes.Buffer
+}
+
+func (c *testBufferConn) Read(b []byte) (int, error) {
+	return c.Buffer.Read(b)
+}
+
+func (c *testBufferConn) Write(b []byte) (int, error) {
+	return c.Buffer.Write(b)
+}
@@ -0,0 +1,236 @@
+package cfh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ContextStats contains the statistics about a context.
+type ContextStats struct {
+	// Records is the number of the frame headers in the context.
+	Records uint64
+
+	// Hits is the number of the frame headers that reuse a dictionary.
+	Hits uint64
+}
+
+// HitRate is used to get the rate of the frame headers that reuse a
+// dictionary, it will return zero if there are no frame headers.
+func (s ContextStats) HitRate() float64 {
+	if s.Records == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Records)
+}
+
+// checkContexts is used to check the number of contexts.
+func checkContexts(contexts int) error {
+	if contexts < 1 || contexts > MaxDictionarySize {
+		return fmt.Errorf("invalid number of contexts: %d", contexts)
+	}
+	return nil
+}
+
+// selectContext is used to select the context about the flow of the frame
+// header, it hashes the flow tuple if the frame header is preferred be
+// compressed, otherwise the first context is selected.
+func selectContext(header []byte, contexts int) int {
+	size, prefer := IsFrameHeaderPreferBeCompressed(header)
+	if !prefer || size != len(header) {
+		return 0
+	}
+	return int(dictionaryKey(header) % uint64(contexts))
+}
+
+// ContextWriter is used to compress frame headers with multiple independent
+// dictionary tables like the context ID in ROHC, the flows are distributed
+// to the contexts by hash, so a large number of flows will not evict the
+// dictionaries in one table constantly. Each record is started with the
+// context id, the remaining part is the record of the context.
+//
+// +------------+-----------+
+// | context id |  record   |
+// +------------+-----------+
+// |   uint8    | var bytes |
+// +------------+-----------+
+type ContextWriter struct {
+	w     io.Writer
+	ctx   []*Writer
+	stats []ContextStats
+	buf   bytes.Buffer
+	err   error
+}
+
+// NewContextWriter is used to create a new ContextWriter with the number of
+// contexts, the options are used to create the Writer of each context. The
+// datagram mode and the buffered mode are not supported.
+func NewContextWriter(w io.Writer, contexts int, opts *Options) (*ContextWriter, error) {
+	err := checkContexts(contexts)
+	if err != nil {
+		return nil, err
+	}
+	err = checkStreamOptions(opts, "ContextWriter")
+	if err != nil {
+		return nil, err
+	}
+	cw := ContextWriter{
+		w:     w,
+		ctx:   make([]*Writer, contexts),
+		stats: make([]ContextStats, contexts),
+	}
+	for i := 0; i < contexts; i++ {
+		cw.ctx[i], err = NewWriterWithOptions(&cw.buf, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &cw, nil
+}
+
+// Write is used to compress frame header data with the context about the
+// flow of it and write the record to the under w.
+func (cw *ContextWriter) Write(b []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	id := selectContext(b, len(cw.ctx))
+	cw.buf.Reset()
+	cw.buf.WriteByte(byte(id))
+	w := cw.ctx[id]
+	n, err := w.Write(b)
+	if err != nil {
+		return 0, err
+	}
+	_, err = cw.w.Write(cw.buf.Bytes())
+	if err != nil {
+		cw.err = err
+		return 0, err
+	}
+	cw.stats[id].Records++
+	if w.cmd != cmdAddDict {
+		cw.stats[id].Hits++
+	}
+	return n, nil
+}
+
+// Stats is used to get the statistics about each context.
+func (cw *ContextWriter) Stats() []ContextStats {
+	stats := make([]ContextStats, len(cw.stats))
+	copy(stats, cw.stats)
+	return stats
+}
+
+// ContextReader is used to decompress frame headers from ContextWriter.
+// If the under reader does not implement io.ByteReader, it will be wrapped
+// with an internal buffer, so it may read more data than necessary.
+type ContextReader struct {
+	ctx []*Reader
+	cur *Reader
+	br  byteReader
+	err error
+}
+
+// NewContextReader is used to create a new ContextReader, the number of
+// contexts and the options must be the same as the ContextWriter.
+func NewContextReader(r io.Reader, contexts int, opts *Options) (*ContextReader, error) {
+	err := checkContexts(contexts)
+	if err != nil {
+		return nil, err
+	}
+	err = checkStreamOptions(opts, "ContextReader")
+	if err != nil {
+		return nil, err
+	}
+	cr := ContextReader{
+		ctx: make([]*Reader, contexts),
+	}
+	// the Readers of contexts will share the same buffer
+	reader := Reader{r: r}
+	reader.initByteReader()
+	cr.br = reader.br
+	for i := 0; i < contexts; i++ {
+		cr.ctx[i], err = NewReaderWithOptions(cr.br, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &cr, nil
+}
+
+// Read is used to decompress frame header data and copy to b, if b is
+// smaller than the frame header, the remaining data will be returned by
+// the next Read call.
+func (cr *ContextReader) Read(b []byte) (int, error) {
+	l := len(b)
+	if l < 1 {
+		return 0, nil
+	}
+	if l > MaxFrameHeaderSize {
+		return 0, errors.New("read with too large buffer")
+	}
+	// read remaining data
+	if cr.cur != nil && cr.cur.rem.Len() != 0 {
+		return cr.cur.Read(b)
+	}
+	r, err := cr.next()
+	if err != nil {
+		return 0, err
+	}
+	n, err := r.Read(b)
+	if err != nil {
+		return 0, cr.fail(err)
+	}
+	return n, nil
+}
+
+// ReadHeader is used to decompress one frame header, the returned slice
+// is only valid until the next call to the ContextReader.
+func (cr *ContextReader) ReadHeader() ([]byte, error) {
+	if cr.cur != nil && cr.cur.rem.Len() != 0 {
+		return cr.cur.ReadHeader()
+	}
+	r, err := cr.next()
+	if err != nil {
+		return nil, err
+	}
+	header, err := r.ReadHeader()
+	if err != nil {
+		return nil, cr.fail(err)
+	}
+	return header, nil
+}
+
+// next is used to read the context id of the next record.
+func (cr *ContextReader) next() (*Reader, error) {
+	if cr.err != nil {
+		return nil, cr.err
+	}
+	id, err := cr.br.ReadByte()
+	if err == io.EOF {
+		// the stream is ended at the record boundary
+		return nil, io.EOF
+	}
+	if err != nil {
+		cr.err = fmt.Errorf("failed to read context id: %w", err)
+		return nil, cr.err
+	}
+	if int(id) >= len(cr.ctx) {
+		cr.err = fmt.Errorf("invalid context id: %d", id)
+		return nil, cr.err
+	}
+	cr.cur = cr.ctx[id]
+	return cr.cur, nil
+}
+
+// fail is used to process the error from the Reader of context, all errors
+// are sticky, because the Reader that waits the reset record after the
+// checkpoint is mismatched will skip the records of the other contexts.
+func (cr *ContextReader) fail(err error) error {
+	if err == io.EOF {
+		err = fmt.Errorf("failed to read context record: %w: %w", ErrTruncated, io.ErrUnexpectedEOF)
+	}
+	cr.err = err
+	return err
+}
@@ -0,0 +1,242 @@
+package cfh
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testGenerateFlows is used to generate frame headers about many flows,
+// each flow is identified by the IPv4 source address.
+func testGenerateFlows(t *testing.T, flows, n int) [][]byte {
+	headers := make([][]byte, n)
+	idx := make([]byte, 4)
+	for i := 0; i < n; i++ {
+		_, err := rand.Read(idx)
+		require.NoError(t, err)
+		header := bytes.Clone(testIPv4TCPFrameHeader1)
+		flow := binary.BigEndian.Uint32(idx) % uint32(flows)
+		binary.BigEndian.PutUint32(header[26:], flow)
+		header[18] = idx[0] // IPv4 ID [byte 1]
+		headers[i] = header
+	}
+	return headers
+}
+
+func TestContextWriter(t *testing.T) {
+	headers := testGenerateFrameHeaders(t)
+
+	t.Run("common", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 4*1024*1024))
+		cw, err := NewContextWriter(output, 16, nil)
+		require.NoError(t, err)
+		for _, header := range headers {
+			_, err = cw.Write(header)
+			require.NoError(t, err)
+		}
+
+		cr, err := NewContextReader(output, 16, nil)
+		require.NoError(t, err)
+		for _, header := range headers {
+			buf := make([]byte, len(header))
+			_, err = cr.Read(buf)
+			require.NoError(t, err)
+			require.Equal(t, header, buf)
+		}
+		_, err = cr.Read(make([]byte, 16))
+		require.Equal(t, io.EOF, err)
+
+		var records uint64
+		for _, stats := range cw.Stats() {
+			records += stats.Records
+		}
+		require.Equal(t, uint64(len(headers)), records)
+	})
+
+	t.Run("many flows", func(t *testing.T) {
+		headers := testGenerateFlows(t, 1024, 64*1024)
+
+		output := bytes.NewBuffer(make([]byte, 0, 4*1024*1024))
+		w := NewWriter(output)
+		for _, header := range headers {
+			_, err := w.Write(header)
+			require.NoError(t, err)
+		}
+		size := output.Len()
+
+		output = bytes.NewBuffer(make([]byte, 0, 4*1024*1024))
+		opts := Options{Checksum: true}
+		cw, err := NewContextWriter(output, 8, &opts)
+		require.NoError(t, err)
+		for _, header := range headers {
+			_, err = cw.Write(header)
+			require.NoError(t, err)
+		}
+		require.Less(t, output.Len(), size)
+		for _, stats := range cw.Stats() {
+			require.NotZero(t, stats.Records)
+			require.Greater(t, stats.HitRate(), 0.5)
+		}
+
+		cr, err := NewContextReader(output, 8, &opts)
+		require.NoError(t, err)
+		for _, header := range headers {
+			buf, err := cr.ReadHeader()
+			require.NoError(t, err)
+			require.Equal(t, header, buf)
+		}
+	})
+
+	t.Run("custom frame header", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+		cw, err := NewContextWriter(output, 4, nil)
+		require.NoError(t, err)
+		_, err = cw.Write([]byte{1, 2, 3, 4})
+		require.NoError(t, err)
+		require.Equal(t, byte(0), output.Bytes()[0])
+		require.Equal(t, uint64(1), cw.Stats()[0].Records)
+		require.Zero(t, cw.Stats()[0].HitRate())
+	})
+
+	t.Run("too large frame header", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+		cw, err := NewContextWriter(output, 4, nil)
+		require.NoError(t, err)
+		n, err := cw.Write(make([]byte, MaxFrameHeaderSize+1))
+		require.ErrorIs(t, err, ErrHeaderTooLarge)
+		require.Zero(t, n)
+		require.Zero(t, output.Len())
+	})
+
+	t.Run("failed to write", func(t *testing.T) {
+		pr, pw := io.Pipe()
+		err := pr.Close()
+		require.NoError(t, err)
+		cw, err := NewContextWriter(pw, 4, nil)
+		require.NoError(t, err)
+
+		n, err := cw.Write(testIPv4TCPFrameHeader1)
+		require.Equal(t, io.ErrClosedPipe, err)
+		require.Zero(t, n)
+		// the error is sticky
+		_, err = cw.Write(testIPv4TCPFrameHeader1)
+		require.Equal(t, io.ErrClosedPipe, err)
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		cw, err := NewContextWriter(io.Discard, 0, nil)
+		require.EqualError(t, err, "invalid number of contexts: 0")
+		require.Nil(t, cw)
+
+		opts := Options{Datagram: true}
+		cw, err = NewContextWriter(io.Discard, 4, &opts)
+		require.EqualError(t, err, "datagram mode is not supported by ContextWriter")
+		require.Nil(t, cw)
+
+		opts = Options{DictSize: -1}
+		cw, err = NewContextWriter(io.Discard, 4, &opts)
+		require.EqualError(t, err, "dictionary size cannot less than 1")
+		require.Nil(t, cw)
+	})
+}
+
+func TestContextReader(t *testing.T) {
+	t.Run("remaining data", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+		cw, err := NewContextWriter(output, 4, nil)
+		require.NoError(t, err)
+		for _, header := range testFrameHeaders {
+			_, err = cw.Write(header)
+			require.NoError(t, err)
+		}
+
+		cr, err := NewContextReader(&testUnbufferedReader{data: output.Bytes()}, 4, nil)
+		require.NoError(t, err)
+		for _, header := range testFrameHeaders {
+			buf := make([]byte, len(header))
+			n, err := cr.Read(buf[:16])
+			require.NoError(t, err)
+			require.Equal(t, 16, n)
+			rem, err := cr.ReadHeader()
+			require.NoError(t, err)
+			copy(buf[16:], rem)
+			require.Equal(t, header, buf)
+		}
+	})
+
+	t.Run("invalid buffer", func(t *testing.T) {
+		cr, err := NewContextReader(bytes.NewReader(nil), 4, nil)
+		require.NoError(t, err)
+		n, err := cr.Read(nil)
+		require.NoError(t, err)
+		require.Zero(t, n)
+		n, err = cr.Read(make([]byte, MaxFrameHeaderSize+1))
+		require.EqualError(t, err, "read with too large buffer")
+		require.Zero(t, n)
+	})
+
+	t.Run("invalid record", func(t *testing.T) {
+		for _, item := range []*struct {
+			name   string
+			data   []byte
+			errStr string
+		}{
+			{"invalid context id", []byte{4}, "invalid context id: 4"},
+			{
+				"truncated record", []byte{1},
+				"failed to read context record: record is truncated: unexpected EOF",
+			},
+			{
+				"invalid record", []byte{1, cmdParams, 0},
+				"failed to read stream parameters: record is truncated: unexpected EOF",
+			},
+		} {
+			t.Run(item.name, func(t *testing.T) {
+				cr, err := NewContextReader(bytes.NewReader(item.data), 4, nil)
+				require.NoError(t, err)
+				buf, err := cr.ReadHeader()
+				require.EqualError(t, err, item.errStr)
+				require.Nil(t, buf)
+
+				// the error is sticky
+				_, err = cr.Read(make([]byte, 16))
+				require.EqualError(t, err, item.errStr)
+			})
+		}
+	})
+
+	t.Run("failed to read context id", func(t *testing.T) {
+		pr, pw := io.Pipe()
+		err := pw.CloseWithError(io.ErrClosedPipe)
+		require.NoError(t, err)
+		cr, err := NewContextReader(pr, 4, nil)
+		require.NoError(t, err)
+		_, err = cr.ReadHeader()
+		require.EqualError(t, err, "failed to read context id: io: read/write on closed pipe")
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		cr, err := NewContextReader(bytes.NewReader(nil), 257, nil)
+		require.EqualError(t, err, "invalid number of contexts: 257")
+		require.Nil(t, cr)
+
+		opts := Options{BufferSize: 1}
+		cr, err = NewContextReader(bytes.NewReader(nil), 4, &opts)
+		require.EqualError(t, err, "buffered mode is not supported by ContextReader")
+		require.Nil(t, cr)
+
+		opts = Options{DictSize: -1}
+		cr, err = NewContextReader(bytes.NewReader(nil), 4, &opts)
+		require.EqualError(t, err, "dictionary size cannot less than 1")
+		require.Nil(t, cr)
+	})
+}
+
+func TestContextStats(t *testing.T) {
+	require.Zero(t, ContextStats{}.HitRate())
+	require.Equal(t, 0.75, ContextStats{Records: 4, Hits: 3}.HitRate())
+}
@@ -0,0 +1,414 @@
+package cfh
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// maxDatagramSize is the maximum size of record in datagram mode, it
+// is the size of the changed data record with 255 changes and checksum.
+const maxDatagramSize = 2 + 1 + 1 + 2 + 1 + 2*255 + checksumSize
+
+// ErrMissedUpdate is returned by Reader in datagram mode when the record
+// depends on a dictionary update that is lost or not received yet, the
+// Reader can continue to read the next record, and the dictionary will
+// be recovered after receive the next keyframe about it.
+var ErrMissedUpdate = errors.New("record depends on a missed dictionary update")
+
+// datagram contains the status about datagram mode. Each dictionary has
+// a version that is the sequence of the last record that updated it, the
+// record that references a dictionary contains the version, so the Reader
+// can detect the record that depends on a missed update.
+type datagram struct {
+	interval int
+	mode     FeedbackMode
+	seq      uint16 // the last sequence
+	started  bool   // for Reader, the first record is received
+	lost     uint64 // for Reader, the number of lost records
+	seen     uint64 // for Reader, the bitmap of the recent received sequences
+	version  []uint16
+	refs     []int  // for Writer, the number of references since keyframe
+	acked    []bool // for Writer, the Reader holds the latest version
+	feedback []byte // for Reader, the pending feedback entries
+	frame    []byte // for Reader, the decoded frame header in reliable mode
+}
+
+func newDatagram(opts *Options) *datagram {
+	d := datagram{
+		interval: opts.KeyframeInterval,
+		mode:     opts.Feedback,
+		version:  make([]uint16, opts.DictSize),
+		refs:     make([]int, opts.DictSize),
+	}
+	if d.mode == FeedbackReliable {
+		d.acked = make([]bool, opts.DictSize)
+		d.frame = make([]byte, MaxFrameHeaderSize)
+	}
+	return &d
+}
+
+// writeSequence is used to write the sequence of the next record.
+func (d *datagram) writeSequence(buf *bytes.Buffer) {
+	d.seq++
+	buf.WriteByte(byte(d.seq >> 8))
+	buf.WriteByte(byte(d.seq))
+}
+
+// track is used to update the last sequence and count the lost records.
+// The bit n of seen is set if the sequence that is n before the last one
+// is received, so the duplicated records are not counted as reordered.
+func (d *datagram) track(seq uint16) {
+	if !d.started {
+		d.started = true
+		d.seq = seq
+		d.seen = 1
+		return
+	}
+	diff := int(int16(seq - d.seq))
+	if diff > 0 {
+		d.lost += uint64(diff - 1)
+		d.seq = seq
+		if diff < 64 {
+			d.seen = d.seen<<diff | 1
+		} else {
+			d.seen = 1
+		}
+		return
+	}
+	// the record is too old to known it is duplicated or not
+	if -diff >= 64 {
+		return
+	}
+	bit := uint64(1) << -diff
+	if d.seen&bit != 0 {
+		return
+	}
+	// the reordered record is received
+	d.seen |= bit
+	if d.lost > 0 {
+		d.lost--
+	}
+}
+
+// isStale is used to check the record is older than the last update.
+func (d *datagram) isStale(idx int, seq uint16) bool {
+	return int16(seq-d.version[idx]) < 0
+}
+
+func (d *datagram) resetVersions() {
+	for i := 0; i < len(d.version); i++ {
+		d.version[i] = 0
+		d.refs[i] = 0
+	}
+	for i := 0; i < len(d.acked); i++ {
+		d.acked[i] = false
+	}
+	d.feedback = d.feedback[:0]
+}
+
+func (d *datagram) reset() {
+	d.resetVersions()
+	d.seq = 0
+	d.started = false
+	d.lost = 0
+	d.seen = 0
+}
+
+func (w *Writer) writeDatagram(b []byte) (int, error) {
+	d := w.dgram
+	n := len(b)
+	w.buf.Reset()
+	w.chg.Reset()
+	d.writeSequence(&w.buf)
+	idx, _ := w.searchDictionary(b)
+	var keyframe bool
+	switch {
+	case idx == -1:
+		keyframe = true
+	case d.mode == FeedbackReliable:
+		// only reference the dictionary that the Reader holds
+		keyframe = !d.acked[idx]
+	default:
+		keyframe = d.refs[idx] >= d.interval
+	}
+	if !keyframe {
+		dict := w.dict[idx]
+		for i := 0; i < n; i++ {
+			if dict[i] == b[i] {
+				continue
+			}
+			w.chg.WriteByte(byte(i))
+			w.chg.WriteByte(b[i])
+		}
+		// the keyframe is not larger than the changed data
+		changes := w.chg.Len() / 2
+		keyframe = changes > 255 || 1+2+1+2*changes >= 1+1+n
+	}
+	if keyframe {
+		w.chg.Reset()
+		if idx == -1 {
+			dict := make([]byte, n)
+			copy(dict, b)
+			idx = addToTable(w.dict, w.evict, dict, &w.stats, w.tracer)
+		} else {
+			copy(w.dict[idx], b)
+			w.evict.access(w.dict, idx)
+		}
+		w.buf.WriteByte(cmdAddDict)
+		w.buf.WriteByte(byte(idx))
+		w.buf.WriteByte(byte(n))
+		w.buf.Write(b)
+		w.cmd = cmdAddDict
+		d.version[idx] = d.seq
+		d.refs[idx] = 0
+		if d.acked != nil {
+			d.acked[idx] = false
+		}
+	} else {
+		if w.chg.Len() == 0 {
+			w.buf.WriteByte(cmdPrev)
+			w.buf.WriteByte(byte(idx))
+			w.buf.WriteByte(byte(d.version[idx] >> 8))
+			w.buf.WriteByte(byte(d.version[idx]))
+			w.cmd = cmdPrev
+		} else {
+			w.buf.WriteByte(cmdData)
+			w.buf.WriteByte(byte(idx))
+			w.buf.WriteByte(byte(d.version[idx] >> 8))
+			w.buf.WriteByte(byte(d.version[idx]))
+			w.buf.WriteByte(byte(w.chg.Len() / 2))
+			w.buf.Write(w.chg.Bytes())
+			w.cmd = cmdData
+			// the dictionary is not updated in reliable mode, so the
+			// next record can still reference the acknowledged version
+			if d.mode != FeedbackReliable {
+				copy(w.dict[idx], b)
+				d.version[idx] = d.seq
+			}
+		}
+		d.refs[idx]++
+		w.evict.access(w.dict, idx)
+	}
+	w.writeChecksum(b)
+	_, err := w.w.Write(w.buf.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	w.account(idx, n, w.chg.Len()/2)
+	w.updateLast(b)
+	return n, nil
+}
+
+// readDatagram will read one record from the under reader, only the
+// error about the under reader is sticky, the Reader can continue to
+// read the next record after other errors.
+func (r *Reader) readDatagram() error {
+	for {
+		n, err := r.r.Read(r.buf)
+		if err != nil {
+			r.err = fmt.Errorf("failed to read datagram: %w", err)
+			return r.err
+		}
+		if n > maxDatagramSize {
+			return errors.New("datagram is too large")
+		}
+		ok, err := r.decodeDatagram(r.buf[:n])
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+}
+
+// decodeDatagram is used to decode the record, if the record is not
+// contain frame header, it will return false.
+func (r *Reader) decodeDatagram(record []byte) (bool, error) {
+	if len(record) < 2+1 {
+		return false, fmt.Errorf("failed to decode datagram: %w", ErrTruncated)
+	}
+	r.stats.bytesIn.Add(uint64(len(record)))
+	seq := binary.BigEndian.Uint16(record)
+	cmd := record[2]
+	record = record[3:]
+	var crc uint16
+	if r.crc && cmd != cmdReset {
+		l := len(record) - checksumSize
+		if l < 0 {
+			return false, fmt.Errorf("failed to decode datagram: %w", ErrTruncated)
+		}
+		crc = binary.BigEndian.Uint16(record[l:])
+		record = record[:l]
+	}
+	var err error
+	switch cmd {
+	case cmdAddDict:
+		err = r.decodeKeyframe(seq, record)
+	case cmdData:
+		err = r.decodeChangedData(seq, record)
+	case cmdPrev:
+		err = r.decodePrevious(record)
+	case cmdReset:
+		if len(record) != 0 {
+			return false, errors.New("invalid reset record size")
+		}
+		r.resetTable()
+		r.dgram.resetVersions()
+		r.dgram.track(seq)
+		r.inspectControl(cmd)
+		return false, nil
+	default:
+		return false, fmt.Errorf("%w: %d", ErrInvalidCommand, cmd)
+	}
+	if err != nil {
+		// the record is valid, but it cannot be decoded
+		if err == ErrMissedUpdate {
+			r.dgram.track(seq)
+			if r.dgram.mode != FeedbackNone {
+				idx := int(record[0])
+				r.dgram.queueFeedback(feedbackNACK, idx, r.dgram.version[idx])
+			}
+		}
+		return false, err
+	}
+	r.dgram.track(seq)
+	if r.crc {
+		err = verifyChecksum(crc, r.data)
+		if err != nil {
+			r.discardDictionary(int(record[0]), seq)
+			return false, err
+		}
+	}
+	var changes int
+	if cmd == cmdData {
+		changes = int(record[3])
+	}
+	r.account(cmd, int(record[0]), changes)
+	r.updateLast(r.data)
+	return true, nil
+}
+
+// discardDictionary is used to discard the dictionary that is updated
+// by the corrupted record, the following records that reference it will
+// return ErrMissedUpdate until the next keyframe about it is received.
+func (r *Reader) discardDictionary(idx int, seq uint16) {
+	d := r.dgram
+	if r.dict[idx] == nil || d.version[idx] != seq {
+		return
+	}
+	r.dict[idx] = nil
+	d.version[idx] = 0
+	if d.mode != FeedbackNone {
+		d.queueFeedback(feedbackNACK, idx, 0)
+	}
+}
+
+func (r *Reader) decodeKeyframe(seq uint16, record []byte) error {
+	if len(record) < 2 {
+		return fmt.Errorf("failed to decode keyframe: %w", ErrTruncated)
+	}
+	idx := int(record[0])
+	size := decodeDictSize(record[1])
+	if len(record) != 2+size {
+		return fmt.Errorf("invalid keyframe size: %d", len(record)-2)
+	}
+	if idx >= len(r.dict) {
+		return fmt.Errorf("%w: %d", ErrInvalidDictIndex, idx)
+	}
+	data := record[2:]
+	// the dictionary is updated by a newer record
+	if r.dict[idx] != nil && r.dgram.isStale(idx, seq) {
+		r.data = data
+		return nil
+	}
+	dict := r.dict[idx]
+	if len(dict) != size {
+		dict = make([]byte, size)
+		r.dict[idx] = dict
+	}
+	copy(dict, data)
+	r.dgram.version[idx] = seq
+	if r.dgram.mode == FeedbackReliable {
+		r.dgram.queueFeedback(feedbackACK, idx, seq)
+	}
+	r.data = dict
+	return nil
+}
+
+func (r *Reader) decodeChangedData(seq uint16, record []byte) error {
+	if len(record) < 4 {
+		return fmt.Errorf("failed to decode changed data: %w", ErrTruncated)
+	}
+	dict, err := r.referenceDictionary(record)
+	if err != nil {
+		return err
+	}
+	idx := int(record[0])
+	size := int(record[3]) * 2
+	if size > len(dict)*2 {
+		return fmt.Errorf("read invalid changed data size: %d", size/2)
+	}
+	if len(record) != 4+size {
+		return fmt.Errorf("invalid changed data size: %d", len(record)-4)
+	}
+	changes := record[4:]
+	for i := 0; i < size; i += 2 {
+		if int(changes[i]) >= len(dict) {
+			return fmt.Errorf("invalid changed data index: %d", changes[i])
+		}
+	}
+	// the dictionary is not updated in reliable mode
+	if r.dgram.mode == FeedbackReliable {
+		dict = r.dgram.frame[:len(dict)]
+		copy(dict, r.dict[idx])
+	}
+	r.inspectChanges(dict, changes[:size])
+	for i := 0; i < size; i += 2 {
+		dict[changes[i]] = changes[i+1]
+	}
+	if r.dgram.mode != FeedbackReliable {
+		r.dgram.version[idx] = seq
+	}
+	r.data = dict
+	return nil
+}
+
+func (r *Reader) decodePrevious(record []byte) error {
+	if len(record) != 3 {
+		return errors.New("invalid previous data record size")
+	}
+	dict, err := r.referenceDictionary(record)
+	if err != nil {
+		return err
+	}
+	r.data = dict
+	return nil
+}
+
+// referenceDictionary is used to get the dictionary with the index and
+// the version in record, it will check the dictionary is not missed.
+func (r *Reader) referenceDictionary(record []byte) ([]byte, error) {
+	idx := int(record[0])
+	if idx >= len(r.dict) {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidDictIndex, idx)
+	}
+	version := binary.BigEndian.Uint16(record[1:3])
+	dict := r.dict[idx]
+	if dict == nil || r.dgram.version[idx] != version {
+		return nil, ErrMissedUpdate
+	}
+	return dict, nil
+}
+
+// LostRecords is used to get the number of lost records in datagram mode,
+// the reordered records that are received later are not included, and the
+// duplicated records are not counted as the reordered records.
+func (r *Reader) LostRecords() uint64 {
+	if r.dgram == nil {
+		return 0
+	}
+	return r.dgram.lost
+}
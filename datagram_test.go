@@ -0,0 +1,390 @@
+package cfh
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testPacketQueue is used to simulate a datagram transport,
+// each Write is a datagram and each Read will read one of them.
+type testPacketQueue struct {
+	packets [][]byte
+}
+
+func (q *testPacketQueue) Write(b []byte) (int, error) {
+	q.packets = append(q.packets, bytes.Clone(b))
+	return len(b), nil
+}
+
+func (q *testPacketQueue) Read(b []byte) (int, error) {
+	if len(q.packets) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, q.packets[0])
+	q.packets = q.packets[1:]
+	return n, nil
+}
+
+func testNewDatagramPair(t *testing.T, opts *Options) (*Writer, *Reader, *testPacketQueue) {
+	queue := new(testPacketQueue)
+	w, err := NewWriterWithOptions(queue, opts)
+	require.NoError(t, err)
+	r, err := NewReaderWithOptions(queue, opts)
+	require.NoError(t, err)
+	return w, r, queue
+}
+
+func TestDatagram(t *testing.T) {
+	headers := testGenerateFrameHeaders(t)
+
+	for _, policy := range testEvictionPolicies {
+		t.Run(policy.String(), func(t *testing.T) {
+			opts := Options{
+				DictSize:   64,
+				Policy:     policy,
+				PinnedSize: 4,
+				Datagram:   true,
+			}
+			w, r, queue := testNewDatagramPair(t, &opts)
+			for _, header := range headers {
+				_, err := w.Write(header)
+				require.NoError(t, err)
+				require.Len(t, queue.packets, 1)
+
+				buf := make([]byte, len(header))
+				n, err := r.Read(buf)
+				require.NoError(t, err)
+				require.Equal(t, len(header), n)
+				require.Equal(t, header, buf)
+			}
+			require.Zero(t, r.LostRecords())
+		})
+	}
+
+	t.Run("lost records", func(t *testing.T) {
+		opts := Options{
+			Datagram:         true,
+			KeyframeInterval: 4,
+		}
+		w, r, queue := testNewDatagramPair(t, &opts)
+
+		_, err := w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+		buf := make([]byte, len(testIPv4TCPFrameHeader1))
+		_, err = r.Read(buf)
+		require.NoError(t, err)
+
+		// lose the changed data record
+		changed := bytes.Clone(testIPv4TCPFrameHeader1)
+		changed[len(changed)-1]++
+		_, err = w.Write(changed)
+		require.NoError(t, err)
+		queue.packets = nil
+
+		_, err = w.Write(testIPv4TCPFrameHeader3)
+		require.NoError(t, err)
+		_, err = r.Read(buf)
+		require.ErrorIs(t, err, ErrMissedUpdate)
+		require.Equal(t, uint64(1), r.LostRecords())
+
+		// the dictionary will be recovered after keyframe
+		var recovered bool
+		for i := 0; i < 2*opts.KeyframeInterval; i++ {
+			_, err = w.Write(testIPv4TCPFrameHeader3)
+			require.NoError(t, err)
+			_, err = r.Read(buf)
+			if err == nil {
+				recovered = true
+				break
+			}
+			require.ErrorIs(t, err, ErrMissedUpdate)
+		}
+		require.True(t, recovered)
+		require.Equal(t, testIPv4TCPFrameHeader3, buf)
+	})
+
+	t.Run("reordered records", func(t *testing.T) {
+		opts := Options{Datagram: true}
+		w, r, queue := testNewDatagramPair(t, &opts)
+
+		_, err := w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+		_, err = w.Write(testIPv6TCPFrameHeader1)
+		require.NoError(t, err)
+		queue.packets[0], queue.packets[1] = queue.packets[1], queue.packets[0]
+
+		buf := make([]byte, len(testIPv6TCPFrameHeader1))
+		n, err := r.Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, testIPv6TCPFrameHeader1, buf[:n])
+		n, err = r.Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, testIPv4TCPFrameHeader1, buf[:n])
+		require.Zero(t, r.LostRecords())
+	})
+
+	t.Run("duplicated records", func(t *testing.T) {
+		opts := Options{Datagram: true}
+		w, r, queue := testNewDatagramPair(t, &opts)
+
+		headers := [][]byte{
+			testIPv4TCPFrameHeader1,
+			testIPv4TCPFrameHeader1,
+			testIPv6TCPFrameHeader1,
+			testIPv6TCPFrameHeader1,
+		}
+		for _, header := range headers {
+			_, err := w.Write(header)
+			require.NoError(t, err)
+		}
+		// lose the third record, duplicate the last one and the first one
+		packets := queue.packets
+		queue.packets = [][]byte{packets[0], packets[1], packets[3], packets[3], packets[0]}
+
+		buf := make([]byte, MaxFrameHeaderSize)
+		for len(queue.packets) != 0 {
+			_, err := r.Read(buf)
+			if err != nil {
+				require.ErrorIs(t, err, ErrMissedUpdate)
+			}
+		}
+		require.Equal(t, uint64(1), r.LostRecords())
+	})
+
+	t.Run("stale keyframe", func(t *testing.T) {
+		opts := Options{
+			Datagram:         true,
+			KeyframeInterval: 1,
+		}
+		w, r, queue := testNewDatagramPair(t, &opts)
+
+		_, err := w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+		_, err = w.Write(testIPv4TCPFrameHeader2)
+		require.NoError(t, err)
+		_, err = w.Write(testIPv4TCPFrameHeader3)
+		require.NoError(t, err)
+		// the second keyframe is received after the third
+		queue.packets[1], queue.packets[2] = queue.packets[2], queue.packets[1]
+
+		buf := make([]byte, len(testIPv4TCPFrameHeader1))
+		for _, header := range [][]byte{
+			testIPv4TCPFrameHeader1,
+			testIPv4TCPFrameHeader3,
+			testIPv4TCPFrameHeader2,
+		} {
+			_, err = r.Read(buf)
+			require.NoError(t, err)
+			require.Equal(t, header, buf)
+		}
+
+		// the dictionary is not overwritten by the stale keyframe
+		_, err = w.Write(testIPv4TCPFrameHeader3)
+		require.NoError(t, err)
+		_, err = r.Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, testIPv4TCPFrameHeader3, buf)
+	})
+
+	t.Run("read remaining data", func(t *testing.T) {
+		opts := Options{Datagram: true}
+		w, r, _ := testNewDatagramPair(t, &opts)
+
+		_, err := w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+
+		buf1 := make([]byte, 16)
+		_, err = r.Read(buf1)
+		require.NoError(t, err)
+		buf2 := make([]byte, len(testIPv4TCPFrameHeader1)-16)
+		_, err = r.Read(buf2)
+		require.NoError(t, err)
+		require.Equal(t, testIPv4TCPFrameHeader1, append(buf1, buf2...))
+	})
+
+	t.Run("resync", func(t *testing.T) {
+		opts := Options{Datagram: true}
+		w, r, _ := testNewDatagramPair(t, &opts)
+
+		_, err := w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+		err = w.Resync()
+		require.NoError(t, err)
+		_, err = w.Write(testIPv4TCPFrameHeader2)
+		require.NoError(t, err)
+
+		buf := make([]byte, len(testIPv4TCPFrameHeader1))
+		for _, header := range [][]byte{
+			testIPv4TCPFrameHeader1,
+			testIPv4TCPFrameHeader2,
+		} {
+			_, err = r.Read(buf)
+			require.NoError(t, err)
+			require.Equal(t, header, buf)
+		}
+		require.Zero(t, r.LostRecords())
+	})
+
+	t.Run("lost records with previous data", func(t *testing.T) {
+		opts := Options{Datagram: true}
+		w, r, queue := testNewDatagramPair(t, &opts)
+
+		changed := bytes.Clone(testIPv4TCPFrameHeader1)
+		changed[len(changed)-1]++
+		for _, header := range [][]byte{
+			testIPv4TCPFrameHeader1,
+			changed,
+			changed,
+		} {
+			_, err := w.Write(header)
+			require.NoError(t, err)
+		}
+		// lose the changed data record
+		queue.packets = append(queue.packets[:1], queue.packets[2:]...)
+
+		buf := make([]byte, len(testIPv4TCPFrameHeader1))
+		_, err := r.Read(buf)
+		require.NoError(t, err)
+		_, err = r.Read(buf)
+		require.ErrorIs(t, err, ErrMissedUpdate)
+	})
+}
+
+func TestReader_readDatagram(t *testing.T) {
+	opts := Options{Datagram: true}
+	buf := make([]byte, 64)
+
+	for _, item := range []*struct {
+		name   string
+		record []byte
+		err    string
+	}{
+		{"truncated", []byte{0, 1}, "failed to decode datagram: record is truncated"},
+		{"invalid command", []byte{0, 1, 0}, "invalid decompress command: 0"},
+		{"invalid reset", []byte{0, 1, cmdReset, 0}, "invalid reset record size"},
+		{"keyframe truncated", []byte{0, 1, cmdAddDict, 0}, "failed to decode keyframe: record is truncated"},
+		{"invalid keyframe", []byte{0, 1, cmdAddDict, 0, 0}, "invalid keyframe size: 0"},
+		{"invalid keyframe size", []byte{0, 1, cmdAddDict, 0, 2, 1}, "invalid keyframe size: 1"},
+		{"changed data truncated", []byte{0, 1, cmdData, 0, 0}, "failed to decode changed data: record is truncated"},
+		{"changed data missed", []byte{0, 1, cmdData, 0, 0, 0, 0}, ErrMissedUpdate.Error()},
+		{"invalid previous size", []byte{0, 1, cmdPrev, 0}, "invalid previous data record size"},
+		{"previous data missed", []byte{0, 1, cmdPrev, 0, 0, 0}, ErrMissedUpdate.Error()},
+	} {
+		t.Run(item.name, func(t *testing.T) {
+			queue := &testPacketQueue{packets: [][]byte{item.record}}
+			r, err := NewReaderWithOptions(queue, &opts)
+			require.NoError(t, err)
+
+			n, err := r.Read(buf)
+			require.EqualError(t, err, item.err)
+			require.Zero(t, n)
+		})
+	}
+
+	t.Run("invalid dictionary index", func(t *testing.T) {
+		opts := Options{DictSize: 4, Datagram: true}
+		queue := &testPacketQueue{packets: [][]byte{
+			{0, 1, cmdAddDict, 4, 1, 1},
+			{0, 2, cmdPrev, 4, 0, 0},
+		}}
+		r, err := NewReaderWithOptions(queue, &opts)
+		require.NoError(t, err)
+
+		_, err = r.Read(buf)
+		require.EqualError(t, err, "invalid dictionary index: 4")
+		_, err = r.Read(buf)
+		require.EqualError(t, err, "invalid dictionary index: 4")
+	})
+
+	t.Run("invalid changed data", func(t *testing.T) {
+		queue := &testPacketQueue{packets: [][]byte{
+			{0, 1, cmdAddDict, 0, 2, 1, 2},
+			{0, 2, cmdData, 0, 0, 1, 3},
+			{0, 3, cmdData, 0, 0, 1, 1, 1, 3, 4, 5},
+			{0, 4, cmdData, 0, 0, 1, 1, 2, 9},
+			{0, 5, cmdData, 0, 0, 1, 1, 1, 3},
+		}}
+		r, err := NewReaderWithOptions(queue, &opts)
+		require.NoError(t, err)
+
+		n, err := r.Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, []byte{1, 2}, buf[:n])
+		_, err = r.Read(buf)
+		require.EqualError(t, err, "read invalid changed data size: 3")
+		_, err = r.Read(buf)
+		require.EqualError(t, err, "invalid changed data size: 4")
+		_, err = r.Read(buf)
+		require.EqualError(t, err, "invalid changed data index: 2")
+
+		// the Reader can continue after the invalid records
+		n, err = r.Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, []byte{1, 3}, buf[:n])
+	})
+
+	t.Run("datagram is too large", func(t *testing.T) {
+		record := make([]byte, maxDatagramSize+1)
+		queue := &testPacketQueue{packets: [][]byte{record}}
+		r, err := NewReaderWithOptions(queue, &opts)
+		require.NoError(t, err)
+
+		_, err = r.Read(buf)
+		require.EqualError(t, err, "datagram is too large")
+	})
+
+	t.Run("failed to read datagram", func(t *testing.T) {
+		r, err := NewReaderWithOptions(new(testPacketQueue), &opts)
+		require.NoError(t, err)
+
+		_, err = r.Read(buf)
+		require.EqualError(t, err, "failed to read datagram: EOF")
+		_, err = r.Read(buf)
+		require.EqualError(t, err, "failed to read datagram: EOF")
+	})
+}
+
+func TestDatagram_MarshalBinary(t *testing.T) {
+	headers := testGenerateFrameHeaders(t)
+	half := len(headers) / 2
+
+	opts := Options{
+		DictSize: 64,
+		Datagram: true,
+	}
+	w1, r1, queue := testNewDatagramPair(t, &opts)
+	for _, header := range headers[:half] {
+		_, err := w1.Write(header)
+		require.NoError(t, err)
+		buf := make([]byte, len(header))
+		_, err = r1.Read(buf)
+		require.NoError(t, err)
+	}
+
+	ws, err := w1.MarshalBinary()
+	require.NoError(t, err)
+	rs, err := r1.MarshalBinary()
+	require.NoError(t, err)
+
+	w2, err := NewWriterWithOptions(queue, &opts)
+	require.NoError(t, err)
+	err = w2.UnmarshalBinary(ws)
+	require.NoError(t, err)
+	r2, err := NewReaderWithOptions(queue, &opts)
+	require.NoError(t, err)
+	err = r2.UnmarshalBinary(rs)
+	require.NoError(t, err)
+
+	for _, header := range headers[half:] {
+		_, err = w2.Write(header)
+		require.NoError(t, err)
+		buf := make([]byte, len(header))
+		_, err = r2.Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, header, buf)
+	}
+	require.Zero(t, r2.LostRecords())
+}
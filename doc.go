@@ -49,3 +49,98 @@ package cfh
 // +---------+------------------+
 // |  byte   |      uint8       |
 // +---------+------------------+
+//
+// 5. stream parameters
+// It is the first record if the Writer is created with options,
+// the dictionaries hash only exists when the flags bit 0 is set.
+//
+// +---------+-----------------+--------+--------+-------+-------------------+
+// | command | dictionary size | policy | pinned | flags | dictionaries hash |
+// +---------+-----------------+--------+--------+-------+-------------------+
+// |  byte   |      uint8      | uint8  | uint8  | uint8 |      8 bytes      |
+// +---------+-----------------+--------+--------+-------+-------------------+
+//
+// dictionary size is the actual size minus 1.
+// flags bit 1 means the checksum is appended to each record that
+// contains frame header, it is the CRC-16/CCITT-FALSE about the
+// reconstructed frame header in big endian.
+//
+// 6. reset dictionaries
+//
+// +---------+
+// | command |
+// +---------+
+// |  byte   |
+// +---------+
+//
+// 7. checkpoint
+// It is written before a record, the hash is the FNV-64a about the
+// dictionary table and the last frame header before the record.
+//
+// +---------+-----------------+
+// | command | dictionary hash |
+// +---------+-----------------+
+// |  byte   |     8 bytes     |
+// +---------+-----------------+
+//
+// 8. write changed data with swapped direction
+// It is only written if the flags bit 2 is set in the stream parameters.
+// The frame header is rebuilt by swapping the Ethernet addresses, the IP
+// addresses, the ports, and the TCP sequence and acknowledgment numbers
+// of the dictionary, then the changed data is applied like command 2.
+//
+// +---------+------------------+-------------+-----------+
+// | command | dictionary index | data number |   data    |
+// +---------+------------------+-------------+-----------+
+// |  byte   |      uint8       |    uint8    | var bytes |
+// +---------+------------------+-------------+-----------+
+//
+// In datagram mode, each Write will output one datagram, and each
+// record has a sequence before the command. The dictionaries will
+// not be moved after added, the record that references a dictionary
+// contains the version of it, the version is the sequence of the
+// last record that updated the dictionary.
+//
+// +----------+---------+-----------+
+// | sequence | command |   body    |
+// +----------+---------+-----------+
+// |  uint16  |  byte   | var bytes |
+// +----------+---------+-----------+
+//
+// 1. keyframe, add or refresh a dictionary
+//
+// +------------------+-----------------+-----------------+
+// | dictionary index | dictionary size | dictionary data |
+// +------------------+-----------------+-----------------+
+// |      uint8       |      uint8      |    var bytes    |
+// +------------------+-----------------+-----------------+
+//
+// 2. changed data with existed dictionary
+//
+// +------------------+---------+-------------+-----------+
+// | dictionary index | version | data number |   data    |
+// +------------------+---------+-------------+-----------+
+// |      uint8       | uint16  |    uint8    | var bytes |
+// +------------------+---------+-------------+-----------+
+//
+// 4. repeat previous frame header data
+//
+// +------------------+---------+
+// | dictionary index | version |
+// +------------------+---------+
+// |      uint8       | uint16  |
+// +------------------+---------+
+//
+// 6. reset dictionaries, the body is empty.
+//
+// If the feedback is enabled in datagram mode, the Reader will produce
+// feedback messages that contain the versions of dictionaries it holds.
+//
+// +------+------------------+---------+
+// | type | dictionary index | version |
+// +------+------------------+---------+
+// | byte |      uint8       | uint16  |
+// +------+------------------+---------+
+//
+// type 1 is ACK about a dictionary update, type 2 is NACK about a
+// record that references a dictionary cannot be reconstructed.
@@ -0,0 +1,145 @@
+package cfh
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// FeedbackMode is the mode about how the Writer uses the feedback
+// messages from the Reader in datagram mode, it is like the modes
+// in ROHC, the Writer and the Reader must use the same mode.
+type FeedbackMode uint8
+
+// supported feedback modes.
+const (
+	// FeedbackNone is the default mode, the Reader will not produce
+	// feedback, the Writer only recovers by the periodic keyframes.
+	FeedbackNone FeedbackMode = iota
+
+	// FeedbackOptimistic is like the optimistic mode in ROHC, the Reader
+	// only sends NACK when it cannot reconstruct a dictionary, then the
+	// Writer will write a keyframe about it at the next reference.
+	FeedbackOptimistic
+
+	// FeedbackReliable is like the reliable mode in ROHC, the Reader sends
+	// ACK for each dictionary update, the Writer only references the
+	// dictionaries that the Reader is known to hold, and the changed data
+	// records will not update the dictionary. Periodic keyframes are not
+	// written in this mode.
+	FeedbackReliable
+)
+
+// String implements fmt.Stringer.
+func (m FeedbackMode) String() string {
+	switch m {
+	case FeedbackNone:
+		return "None"
+	case FeedbackOptimistic:
+		return "Optimistic"
+	case FeedbackReliable:
+		return "Reliable"
+	default:
+		return fmt.Sprintf("FeedbackMode(%d)", uint8(m))
+	}
+}
+
+// types of the feedback entry.
+const (
+	feedbackACK = 1 + iota
+	feedbackNACK
+)
+
+// feedbackEntrySize is the size of the feedback entry,
+// it contains type, dictionary index and version.
+const feedbackEntrySize = 1 + 1 + 2
+
+// maxFeedbackSize is the maximum size of the feedback message,
+// each dictionary has at most one entry in a message.
+const maxFeedbackSize = feedbackEntrySize * MaxDictionarySize
+
+// queueFeedback is used to add a feedback entry about the dictionary,
+// the older entry about the same dictionary will be replaced.
+func (d *datagram) queueFeedback(typ byte, idx int, version uint16) {
+	entry := [feedbackEntrySize]byte{typ, byte(idx)}
+	binary.BigEndian.PutUint16(entry[2:], version)
+	for i := 0; i < len(d.feedback); i += feedbackEntrySize {
+		if d.feedback[i+1] != byte(idx) {
+			continue
+		}
+		// NACK will not be replaced by ACK about the same version
+		if d.feedback[i] == feedbackNACK && typ == feedbackACK &&
+			binary.BigEndian.Uint16(d.feedback[i+2:]) == version {
+			return
+		}
+		copy(d.feedback[i:], entry[:])
+		return
+	}
+	d.feedback = append(d.feedback, entry[:]...)
+}
+
+// seed is used to mark the pre-shared dictionaries are acknowledged,
+// because the Reader always holds them.
+func (d *datagram) seed(dict [][]byte) {
+	if d.acked == nil {
+		return
+	}
+	for i := 0; i < len(dict); i++ {
+		d.acked[i] = dict[i] != nil
+	}
+}
+
+// Feedback is used to get the pending feedback message that must be sent
+// to the Writer, it will return nil if there is no pending feedback or
+// the feedback is not enabled. Each entry in the message is the version
+// of the dictionary that the Reader holds, the Writer can process these
+// messages in any order, and a lost message only slows down the recovery.
+func (r *Reader) Feedback() []byte {
+	if r.dgram == nil || len(r.dgram.feedback) == 0 {
+		return nil
+	}
+	msg := make([]byte, len(r.dgram.feedback))
+	copy(msg, r.dgram.feedback)
+	r.dgram.feedback = r.dgram.feedback[:0]
+	return msg
+}
+
+// ProcessFeedback is used to process the feedback message from the Reader,
+// it is not goroutine-safe with Write, the caller must synchronize them.
+func (w *Writer) ProcessFeedback(msg []byte) error {
+	if w.dgram == nil || w.dgram.mode == FeedbackNone {
+		return errors.New("feedback is not enabled")
+	}
+	l := len(msg)
+	if l == 0 || l > maxFeedbackSize || l%feedbackEntrySize != 0 {
+		return fmt.Errorf("invalid feedback size: %d", l)
+	}
+	// check the message before process it
+	for i := 0; i < l; i += feedbackEntrySize {
+		typ := msg[i]
+		if typ != feedbackACK && typ != feedbackNACK {
+			return fmt.Errorf("invalid feedback type: %d", typ)
+		}
+		idx := int(msg[i+1])
+		if idx >= len(w.dict) {
+			return fmt.Errorf("invalid feedback dictionary index: %d", idx)
+		}
+	}
+	d := w.dgram
+	for i := 0; i < l; i += feedbackEntrySize {
+		typ := msg[i]
+		idx := int(msg[i+1])
+		version := binary.BigEndian.Uint16(msg[i+2:])
+		// the Reader holds the latest version, it is not necessary to recover
+		current := w.dict[idx] != nil && version == d.version[idx]
+		switch d.mode {
+		case FeedbackOptimistic:
+			if typ == feedbackNACK && !current {
+				d.refs[idx] = d.interval
+			}
+		case FeedbackReliable:
+			d.acked[idx] = current
+		}
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package cfh
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeedbackMode_String(t *testing.T) {
+	require.Equal(t, "None", FeedbackNone.String())
+	require.Equal(t, "Optimistic", FeedbackOptimistic.String())
+	require.Equal(t, "Reliable", FeedbackReliable.String())
+	require.Equal(t, "FeedbackMode(123)", FeedbackMode(123).String())
+}
+
+func TestFeedback(t *testing.T) {
+	headers := testGenerateFrameHeaders(t)
+
+	for _, mode := range []FeedbackMode{
+		FeedbackOptimistic, FeedbackReliable,
+	} {
+		t.Run(mode.String(), func(t *testing.T) {
+			opts := Options{
+				DictSize: 64,
+				Datagram: true,
+				Feedback: mode,
+			}
+			w, r, _ := testNewDatagramPair(t, &opts)
+			for _, header := range headers {
+				_, err := w.Write(header)
+				require.NoError(t, err)
+
+				buf := make([]byte, len(header))
+				_, err = r.Read(buf)
+				require.NoError(t, err)
+				require.Equal(t, header, buf)
+
+				msg := r.Feedback()
+				if msg != nil {
+					err = w.ProcessFeedback(msg)
+					require.NoError(t, err)
+				}
+			}
+			require.Zero(t, r.LostRecords())
+		})
+	}
+
+	t.Run("optimistic", func(t *testing.T) {
+		opts := Options{
+			Datagram:         true,
+			KeyframeInterval: 1024,
+			Feedback:         FeedbackOptimistic,
+		}
+		w, r, queue := testNewDatagramPair(t, &opts)
+
+		_, err := w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+		buf := make([]byte, len(testIPv4TCPFrameHeader1))
+		_, err = r.Read(buf)
+		require.NoError(t, err)
+		// ACK is not sent in optimistic mode
+		require.Nil(t, r.Feedback())
+
+		// lose the changed data record
+		changed := bytes.Clone(testIPv4TCPFrameHeader1)
+		changed[len(changed)-1]++
+		_, err = w.Write(changed)
+		require.NoError(t, err)
+		queue.packets = nil
+
+		_, err = w.Write(testIPv4TCPFrameHeader3)
+		require.NoError(t, err)
+		_, err = r.Read(buf)
+		require.ErrorIs(t, err, ErrMissedUpdate)
+
+		msg := r.Feedback()
+		require.Equal(t, []byte{feedbackNACK, 0, 0, 1}, msg)
+		require.Nil(t, r.Feedback())
+		err = w.ProcessFeedback(msg)
+		require.NoError(t, err)
+
+		// the next record is a keyframe
+		_, err = w.Write(testIPv4TCPFrameHeader3)
+		require.NoError(t, err)
+		require.Equal(t, byte(cmdAddDict), queue.packets[0][2])
+		_, err = r.Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, testIPv4TCPFrameHeader3, buf)
+
+		// the NACK about the latest version is ignored
+		err = w.ProcessFeedback([]byte{feedbackNACK, 0, 0, 4})
+		require.NoError(t, err)
+		_, err = w.Write(testIPv4TCPFrameHeader3)
+		require.NoError(t, err)
+		require.Equal(t, byte(cmdPrev), queue.packets[0][2])
+	})
+
+	t.Run("reliable", func(t *testing.T) {
+		opts := Options{
+			Datagram: true,
+			Feedback: FeedbackReliable,
+		}
+		w, r, queue := testNewDatagramPair(t, &opts)
+
+		// keyframes are written before the dictionary is acknowledged
+		changed := bytes.Clone(testIPv4TCPFrameHeader1)
+		changed[len(changed)-1]++
+		for _, header := range [][]byte{
+			testIPv4TCPFrameHeader1,
+			changed,
+		} {
+			_, err := w.Write(header)
+			require.NoError(t, err)
+			require.Equal(t, byte(cmdAddDict), queue.packets[0][2])
+			buf := make([]byte, len(header))
+			_, err = r.Read(buf)
+			require.NoError(t, err)
+			require.Equal(t, header, buf)
+		}
+		msg := r.Feedback()
+		require.Equal(t, []byte{feedbackACK, 0, 0, 2}, msg)
+		err := w.ProcessFeedback(msg)
+		require.NoError(t, err)
+
+		// the lost changed data records will not affect the others
+		for _, header := range [][]byte{
+			testIPv4TCPFrameHeader1,
+			testIPv4TCPFrameHeader3,
+		} {
+			_, err = w.Write(header)
+			require.NoError(t, err)
+			require.Equal(t, byte(cmdData), queue.packets[0][2])
+			queue.packets = nil
+		}
+		_, err = w.Write(changed)
+		require.NoError(t, err)
+		require.Equal(t, byte(cmdPrev), queue.packets[0][2])
+		buf := make([]byte, len(changed))
+		_, err = r.Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, changed, buf)
+		require.Equal(t, uint64(2), r.LostRecords())
+		require.Nil(t, r.Feedback())
+	})
+
+	t.Run("reliable with pre-shared dictionaries", func(t *testing.T) {
+		opts := Options{
+			Dictionaries: [][]byte{testIPv4TCPFrameHeader1},
+			Datagram:     true,
+			Feedback:     FeedbackReliable,
+		}
+		w, _, queue := testNewDatagramPair(t, &opts)
+
+		_, err := w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+		require.Equal(t, byte(cmdPrev), queue.packets[0][2])
+
+		// the pre-shared dictionaries are acknowledged after resync
+		err = w.Resync()
+		require.NoError(t, err)
+		_, err = w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+		require.Equal(t, byte(cmdPrev), queue.packets[2][2])
+	})
+
+	t.Run("merge entries", func(t *testing.T) {
+		d := newDatagram(&Options{DictSize: 4, Feedback: FeedbackReliable})
+		d.queueFeedback(feedbackACK, 1, 1)
+		d.queueFeedback(feedbackNACK, 1, 1)
+		d.queueFeedback(feedbackACK, 1, 1)
+		d.queueFeedback(feedbackACK, 2, 3)
+		d.queueFeedback(feedbackACK, 2, 4)
+		expected := []byte{
+			feedbackNACK, 1, 0, 1,
+			feedbackACK, 2, 0, 4,
+		}
+		require.Equal(t, expected, d.feedback)
+	})
+
+	t.Run("not enabled", func(t *testing.T) {
+		opts := Options{Datagram: true}
+		w, r, _ := testNewDatagramPair(t, &opts)
+
+		err := w.ProcessFeedback([]byte{feedbackACK, 0, 0, 1})
+		require.EqualError(t, err, "feedback is not enabled")
+		require.Nil(t, r.Feedback())
+
+		w = NewWriter(bytes.NewBuffer(nil))
+		err = w.ProcessFeedback([]byte{feedbackACK, 0, 0, 1})
+		require.EqualError(t, err, "feedback is not enabled")
+	})
+}
+
+func TestWriter_ProcessFeedback(t *testing.T) {
+	opts := Options{
+		DictSize: 4,
+		Datagram: true,
+		Feedback: FeedbackReliable,
+	}
+	w, _, _ := testNewDatagramPair(t, &opts)
+
+	for _, item := range []*struct {
+		name string
+		msg  []byte
+		err  string
+	}{
+		{"empty", nil, "invalid feedback size: 0"},
+		{"truncated", []byte{feedbackACK, 0, 0}, "invalid feedback size: 3"},
+		{"too large", make([]byte, maxFeedbackSize+feedbackEntrySize), "invalid feedback size: 1028"},
+		{"invalid type", []byte{0, 0, 0, 1}, "invalid feedback type: 0"},
+		{"invalid index", []byte{feedbackACK, 4, 0, 1}, "invalid feedback dictionary index: 4"},
+	} {
+		t.Run(item.name, func(t *testing.T) {
+			err := w.ProcessFeedback(item.msg)
+			require.EqualError(t, err, item.err)
+		})
+	}
+}
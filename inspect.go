@@ -0,0 +1,322 @@
+package cfh
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Record is a record in the compressed stream that is parsed by Inspector.
+type Record struct {
+	Command Command
+
+	// Index is the dictionary index about the record, it is -1 if the
+	// record does not reference a dictionary, like the LastData.
+	Index int
+
+	// Evicted is the index of the dictionary that is evicted by the
+	// record before the new one is added, it is -1 if not evicted.
+	Evicted int
+
+	// Changes are the changed data about ChangedData and SwappedData.
+	Changes []*Change
+
+	// Header is the reconstructed frame header, it is nil about the
+	// stream parameters, reset and checkpoint records.
+	Header []byte
+}
+
+// Change is a changed byte in the frame header.
+type Change struct {
+	Offset int
+	Old    byte
+	New    byte
+
+	// Field is the name of the field that contains the changed byte,
+	// it is empty if the frame header is not a known profile.
+	Field string
+}
+
+// String is used to format the record in human-readable form, the changed
+// data and the frame header are written in the following lines.
+func (r *Record) String() string {
+	buf := new(strings.Builder)
+	buf.WriteString(r.Command.String())
+	if r.Index != -1 {
+		fmt.Fprintf(buf, " index=%d", r.Index)
+	}
+	if r.Evicted != -1 {
+		fmt.Fprintf(buf, " evicted=%d", r.Evicted)
+	}
+	switch r.Command {
+	case CommandChangedData, CommandSwappedData:
+		fmt.Fprintf(buf, " changes=%d", len(r.Changes))
+	}
+	if r.Header != nil {
+		fmt.Fprintf(buf, " size=%d", len(r.Header))
+	}
+	for _, c := range r.Changes {
+		fmt.Fprintf(buf, "\n  offset %d", c.Offset)
+		if c.Field != "" {
+			fmt.Fprintf(buf, " (%s)", c.Field)
+		}
+		fmt.Fprintf(buf, ": %02x -> %02x", c.Old, c.New)
+	}
+	if r.Header != nil {
+		buf.WriteString("\n  header ")
+		buf.WriteString(hex.EncodeToString(r.Header))
+	}
+	return buf.String()
+}
+
+// Inspector is used to parse the compressed stream record by record, it
+// is used to debug the captured streams. It decodes the stream with a
+// Reader, so the stream must be valid about the options.
+type Inspector struct {
+	r    *Reader
+	insp inspection
+	err  error // the error after the pending records
+}
+
+// inspection contains the status about the records that are decoded by
+// the Reader, it implements Tracer to receive the decoded records.
+type inspection struct {
+	r       *Reader
+	records []*Record
+	evicted int
+
+	// the dictionary and the changed data before they are applied
+	old []byte
+	chg []byte
+}
+
+// NewInspector is used to create a new inspector with options, if the
+// options are nil, the stream parameters in the stream are used.
+func NewInspector(r io.Reader, opts *Options) (*Inspector, error) {
+	var (
+		reader *Reader
+		err    error
+	)
+	if opts == nil {
+		reader = NewReader(r)
+	} else {
+		reader, err = NewReaderWithOptions(r, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	i := Inspector{r: reader}
+	i.insp.r = reader
+	i.insp.evicted = -1
+	reader.insp = &i.insp
+	reader.SetTracer(&i.insp)
+	return &i, nil
+}
+
+// Next is used to parse the next record, it will return io.EOF when the
+// stream is ended. If the checkpoint is mismatched, it will return the
+// ErrCheckpointMismatch, then the records until the reset are skipped.
+func (i *Inspector) Next() (*Record, error) {
+	for {
+		if len(i.insp.records) != 0 {
+			record := i.insp.records[0]
+			i.insp.records = i.insp.records[1:]
+			return record, nil
+		}
+		// return the error after the records before it
+		if i.err != nil {
+			err := i.err
+			i.err = nil
+			return nil, err
+		}
+		i.err = i.r.next()
+	}
+}
+
+// OnSearch implements Tracer.
+func (*inspection) OnSearch(int, int, SearchPath) {}
+
+// OnEncode implements Tracer.
+func (*inspection) OnEncode(Command, int, int) {}
+
+// OnEvict implements Tracer.
+func (insp *inspection) OnEvict(index int) {
+	insp.evicted = index
+}
+
+// OnDecode implements Tracer.
+func (insp *inspection) OnDecode(cmd Command, index int) {
+	record := Record{
+		Command: cmd,
+		Index:   index,
+		Evicted: insp.evicted,
+		Header:  bytes.Clone(insp.r.data),
+	}
+	insp.evicted = -1
+	switch cmd {
+	case CommandChangedData, CommandSwappedData:
+		for j := 0; j < len(insp.chg); j += 2 {
+			offset := int(insp.chg[j])
+			record.Changes = append(record.Changes, &Change{
+				Offset: offset,
+				Old:    insp.old[offset],
+				New:    insp.chg[j+1],
+				Field:  headerFieldName(record.Header, offset),
+			})
+		}
+	}
+	insp.records = append(insp.records, &record)
+}
+
+// inspectControl is used to report the record that is not about a frame
+// header, it is only used by Inspector.
+func (r *Reader) inspectControl(cmd byte) {
+	if r.insp == nil {
+		return
+	}
+	record := Record{
+		Command: Command(cmd),
+		Index:   -1,
+		Evicted: -1,
+	}
+	r.insp.records = append(r.insp.records, &record)
+}
+
+// inspectChanges is used to keep the dictionary and the changed data before
+// they are applied, it is only used by Inspector.
+func (r *Reader) inspectChanges(dict, changes []byte) {
+	if r.insp == nil {
+		return
+	}
+	r.insp.old = append(r.insp.old[:0], dict...)
+	r.insp.chg = append(r.insp.chg[:0], changes...)
+}
+
+// Disassemble is used to parse the compressed stream and write each record
+// to out in human-readable form, the stream parameters in the stream are
+// used, so the stream with pre-shared dictionaries is not supported.
+func Disassemble(r io.Reader, out io.Writer) error {
+	inspector, err := NewInspector(r, nil)
+	if err != nil {
+		return err
+	}
+	for n := 1; ; n++ {
+		record, err := inspector.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if errors.Is(err, ErrCheckpointMismatch) {
+			_, err = fmt.Fprintf(out, "#%d checkpoint is mismatched, skip records until reset\n", n)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to disassemble record #%d: %w", n, err)
+		}
+		_, err = fmt.Fprintf(out, "#%d %s\n", n, record)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// headerField is a field in the frame header of the known profile.
+type headerField struct {
+	offset int
+	size   int
+	name   string
+}
+
+var (
+	ethernetFields = []*headerField{
+		{0, 6, "Ethernet destination"},
+		{6, 6, "Ethernet source"},
+		{12, 2, "Ethernet type"},
+	}
+	ipv4Fields = []*headerField{
+		{0, 1, "IPv4 version/IHL"},
+		{1, 1, "IPv4 DSCP/ECN"},
+		{2, 2, "IPv4 total length"},
+		{4, 2, "IPv4 ID"},
+		{6, 2, "IPv4 flags/fragment offset"},
+		{8, 1, "IPv4 TTL"},
+		{9, 1, "IPv4 protocol"},
+		{10, 2, "IPv4 checksum"},
+		{12, 4, "IPv4 source"},
+		{16, 4, "IPv4 destination"},
+	}
+	ipv6Fields = []*headerField{
+		{0, 4, "IPv6 version/traffic class/flow label"},
+		{4, 2, "IPv6 payload length"},
+		{6, 1, "IPv6 next header"},
+		{7, 1, "IPv6 hop limit"},
+		{8, 16, "IPv6 source"},
+		{24, 16, "IPv6 destination"},
+	}
+	tcpFields = []*headerField{
+		{0, 2, "TCP source port"},
+		{2, 2, "TCP destination port"},
+		{4, 4, "TCP sequence"},
+		{8, 4, "TCP acknowledgment"},
+		{12, 1, "TCP data offset"},
+		{13, 1, "TCP flags"},
+		{14, 2, "TCP window"},
+		{16, 2, "TCP checksum"},
+		{18, 2, "TCP urgent pointer"},
+	}
+	udpFields = []*headerField{
+		{0, 2, "UDP source port"},
+		{2, 2, "UDP destination port"},
+		{4, 2, "UDP length"},
+		{6, 2, "UDP checksum"},
+	}
+)
+
+// headerFieldName is used to get the name of the field at the offset, it
+// supports the frame headers that can be compressed by fast mode.
+func headerFieldName(header []byte, offset int) string {
+	size, ok := IsFrameHeaderPreferBeCompressed(header)
+	if !ok || len(header) != size {
+		return ""
+	}
+	if offset < 14 {
+		return findFieldName(ethernetFields, offset)
+	}
+	offset -= 14
+	var (
+		ipFields []*headerField
+		ipSize   int
+	)
+	switch size {
+	case ethernetIPv4TCPSize, ethernetIPv4UDPSize:
+		ipFields = ipv4Fields
+		ipSize = 20
+	default:
+		ipFields = ipv6Fields
+		ipSize = 40
+	}
+	if offset < ipSize {
+		return findFieldName(ipFields, offset)
+	}
+	offset -= ipSize
+	switch size {
+	case ethernetIPv4TCPSize, ethernetIPv6TCPSize:
+		return findFieldName(tcpFields, offset)
+	default:
+		return findFieldName(udpFields, offset)
+	}
+}
+
+func findFieldName(fields []*headerField, offset int) string {
+	for _, field := range fields {
+		if offset >= field.offset && offset < field.offset+field.size {
+			return field.name
+		}
+	}
+	return ""
+}
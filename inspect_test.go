@@ -0,0 +1,245 @@
+package cfh
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDisassemble(t *testing.T) {
+	t.Run("common", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 512))
+		opts := Options{
+			DictSize: 2,
+			Checksum: true,
+		}
+		w, err := NewWriterWithOptions(output, &opts)
+		require.NoError(t, err)
+
+		changed := bytes.Clone(testIPv4TCPFrameHeader1)
+		changed[18]++ // IPv4 ID
+		changed[22]-- // IPv4 TTL
+		for _, header := range [][]byte{
+			testIPv4TCPFrameHeader1,
+			testIPv4TCPFrameHeader1,
+			changed,
+			bytes.Repeat([]byte{1}, 16),
+			bytes.Repeat([]byte{2}, 16),
+		} {
+			_, err = w.Write(header)
+			require.NoError(t, err)
+		}
+		err = w.Resync()
+		require.NoError(t, err)
+		_, err = w.Write(testIPv6UDPFrameHeader1)
+		require.NoError(t, err)
+
+		buf := new(strings.Builder)
+		err = Disassemble(output, buf)
+		require.NoError(t, err)
+
+		expected := `#1 Parameters
+#2 AddDictionary index=0 size=54
+  header d8ba1192c572d8af159ac5d10800450405c8574d40003706b63514983c5fc0a81f0a01bbebd71561ddfc151e1385501003d037390000
+#3 LastData size=54
+  header d8ba1192c572d8af159ac5d10800450405c8574d40003706b63514983c5fc0a81f0a01bbebd71561ddfc151e1385501003d037390000
+#4 ChangedData index=0 changes=2 size=54
+  offset 18 (IPv4 ID): 57 -> 58
+  offset 22 (IPv4 TTL): 37 -> 36
+  header d8ba1192c572d8af159ac5d10800450405c8584d40003606b63514983c5fc0a81f0a01bbebd71561ddfc151e1385501003d037390000
+#5 AddDictionary index=0 size=16
+  header 01010101010101010101010101010101
+#6 AddDictionary index=0 evicted=1 size=16
+  header 02020202020202020202020202020202
+#7 Reset
+#8 AddDictionary index=0 size=62
+  header d8ba1192c572d8af159ac5d386dd6043670305a0112b24108c016c2a103d000000afb00239ab24108a2aa084b4a02127e9cada1240f1fb7b003500385f66
+`
+		require.Equal(t, expected, buf.String())
+	})
+
+	t.Run("checkpoint mismatched", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 512))
+		opts := Options{
+			CheckpointInterval: 1,
+		}
+		w, err := NewWriterWithOptions(output, &opts)
+		require.NoError(t, err)
+		_, err = w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+
+		inspector, err := NewInspector(output, nil)
+		require.NoError(t, err)
+		for _, cmd := range []Command{CommandParameters, CommandAddDictionary} {
+			record, err := inspector.Next()
+			require.NoError(t, err)
+			require.Equal(t, cmd, record.Command)
+		}
+
+		// make the table of Reader diverged
+		inspector.r.dict[0][0]++
+
+		_, err = w.Write(testIPv4TCPFrameHeader2)
+		require.NoError(t, err)
+		_, err = inspector.Next()
+		require.Equal(t, ErrCheckpointMismatch, err)
+
+		err = w.Resync()
+		require.NoError(t, err)
+		_, err = w.Write(testIPv4TCPFrameHeader3)
+		require.NoError(t, err)
+		for _, cmd := range []Command{CommandReset, CommandCheckpoint, CommandAddDictionary} {
+			record, err := inspector.Next()
+			require.NoError(t, err)
+			require.Equal(t, cmd, record.Command)
+		}
+		_, err = inspector.Next()
+		require.Equal(t, io.EOF, err)
+	})
+
+	t.Run("invalid stream", func(t *testing.T) {
+		buf := new(strings.Builder)
+		err := Disassemble(bytes.NewReader([]byte{cmdAddDict, 1, 0xAA, 0}), buf)
+		require.EqualError(t, err, "failed to disassemble record #2: invalid decompress command: 0")
+		require.Equal(t, "#1 AddDictionary index=0 size=1\n  header aa\n", buf.String())
+	})
+
+	t.Run("pre-shared dictionaries", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 512))
+		w, err := NewWriterWithDictionaries(output, [][]byte{testIPv4TCPFrameHeader1})
+		require.NoError(t, err)
+		changed := bytes.Clone(testIPv4TCPFrameHeader1)
+		changed[19]++ // IPv4 ID
+		_, err = w.Write(changed)
+		require.NoError(t, err)
+		data := output.Bytes()
+
+		err = Disassemble(bytes.NewReader(data), io.Discard)
+		require.EqualError(t, err, "failed to disassemble record #1: pre-shared dictionaries are not provided")
+
+		opts := Options{
+			Dictionaries: [][]byte{testIPv4TCPFrameHeader1},
+		}
+		inspector, err := NewInspector(bytes.NewReader(data), &opts)
+		require.NoError(t, err)
+		_, err = inspector.Next()
+		require.NoError(t, err)
+		record, err := inspector.Next()
+		require.NoError(t, err)
+		require.Equal(t, CommandChangedData, record.Command)
+		require.Equal(t, changed, record.Header)
+	})
+}
+
+func TestInspector(t *testing.T) {
+	t.Run("swapped data", func(t *testing.T) {
+		request := testIPv4TCPFrameHeader1
+		reply := bytes.Clone(request)
+		swapDirection(reply)
+		reply[19]++ // IPv4 ID [byte 2]
+
+		output := bytes.NewBuffer(make([]byte, 0, 256))
+		opts := Options{
+			Bidirectional: true,
+		}
+		w, err := NewWriterWithOptions(output, &opts)
+		require.NoError(t, err)
+		_, err = w.Write(request)
+		require.NoError(t, err)
+		_, err = w.Write(reply)
+		require.NoError(t, err)
+
+		inspector, err := NewInspector(output, &opts)
+		require.NoError(t, err)
+		_, err = inspector.Next()
+		require.NoError(t, err)
+		_, err = inspector.Next()
+		require.NoError(t, err)
+		record, err := inspector.Next()
+		require.NoError(t, err)
+
+		expected := &Record{
+			Command: CommandSwappedData,
+			Index:   0,
+			Evicted: -1,
+			Changes: []*Change{{
+				Offset: 19,
+				Old:    request[19],
+				New:    reply[19],
+				Field:  "IPv4 ID",
+			}},
+			Header: reply,
+		}
+		require.Equal(t, expected, record)
+	})
+
+	t.Run("datagram", func(t *testing.T) {
+		changed := bytes.Clone(testIPv6TCPFrameHeader1)
+		changed[21]++ // IPv6 hop limit
+
+		queue := new(testPacketQueue)
+		opts := Options{
+			Datagram: true,
+		}
+		w, err := NewWriterWithOptions(queue, &opts)
+		require.NoError(t, err)
+		for _, header := range [][]byte{
+			testIPv6TCPFrameHeader1,
+			changed,
+		} {
+			_, err = w.Write(header)
+			require.NoError(t, err)
+		}
+		err = w.Resync()
+		require.NoError(t, err)
+
+		inspector, err := NewInspector(queue, &opts)
+		require.NoError(t, err)
+		record, err := inspector.Next()
+		require.NoError(t, err)
+		require.Equal(t, CommandAddDictionary, record.Command)
+
+		record, err = inspector.Next()
+		require.NoError(t, err)
+		require.Equal(t, CommandChangedData, record.Command)
+		require.Equal(t, changed, record.Header)
+		change := &Change{
+			Offset: 21,
+			Old:    testIPv6TCPFrameHeader1[21],
+			New:    changed[21],
+			Field:  "IPv6 hop limit",
+		}
+		require.Equal(t, []*Change{change}, record.Changes)
+
+		record, err = inspector.Next()
+		require.NoError(t, err)
+		require.Equal(t, CommandReset, record.Command)
+		require.Nil(t, record.Header)
+	})
+}
+
+func TestHeaderFieldName(t *testing.T) {
+	for _, item := range []*struct {
+		header []byte
+		offset int
+		name   string
+	}{
+		{testIPv4TCPFrameHeader1, 0, "Ethernet destination"},
+		{testIPv4TCPFrameHeader1, 13, "Ethernet type"},
+		{testIPv4TCPFrameHeader1, 22, "IPv4 TTL"},
+		{testIPv4TCPFrameHeader1, 33, "IPv4 destination"},
+		{testIPv4TCPFrameHeader1, 34, "TCP source port"},
+		{testIPv4TCPFrameHeader1, 47, "TCP flags"},
+		{testIPv4UDPFrameHeader1, 40, "UDP checksum"},
+		{testIPv6TCPFrameHeader1, 20, "IPv6 next header"},
+		{testIPv6TCPFrameHeader1, 53, "IPv6 destination"},
+		{testIPv6TCPFrameHeader1, 58, "TCP sequence"},
+		{testIPv6UDPFrameHeader1, 58, "UDP length"},
+		{bytes.Repeat([]byte{1}, 16), 0, ""},
+	} {
+		require.Equal(t, item.name, headerFieldName(item.header, item.offset))
+	}
+}
@@ -0,0 +1,349 @@
+package cfh
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metrics is used to aggregate the statistics about the named Writers and
+// Readers, it implements expvar.Var and http.Handler, so it can be published
+// by expvar.Publish and served as the Prometheus text exposition format.
+//
+//	metrics := cfh.NewMetrics()
+//	_ = metrics.AddWriter("uplink", w)
+//	expvar.Publish("cfh", metrics)
+//	http.Handle("/metrics", metrics)
+type Metrics struct {
+	mu      sync.Mutex
+	writers map[string]*Writer
+	readers map[string]*Reader
+}
+
+// metricsSource is the statistics about one Writer or Reader.
+type metricsSource struct {
+	role  string
+	name  string
+	stats Stats
+}
+
+// metricsStats is the statistics with the derived values in expvar.
+type metricsStats struct {
+	Stats
+	CompressionRatio float64
+	AverageChanges   float64
+	HitRate          float64
+}
+
+// NewMetrics is used to create a new Metrics without Writers and Readers.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		writers: make(map[string]*Writer),
+		readers: make(map[string]*Reader),
+	}
+}
+
+// AddWriter is used to register the Writer with the name.
+func (m *Metrics) AddWriter(name string, w *Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.writers[name]; ok {
+		return fmt.Errorf("writer %q is already registered", name)
+	}
+	m.writers[name] = w
+	return nil
+}
+
+// AddReader is used to register the Reader with the name.
+func (m *Metrics) AddReader(name string, r *Reader) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.readers[name]; ok {
+		return fmt.Errorf("reader %q is already registered", name)
+	}
+	m.readers[name] = r
+	return nil
+}
+
+// RemoveWriter is used to unregister the Writer with the name.
+func (m *Metrics) RemoveWriter(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.writers, name)
+}
+
+// RemoveReader is used to unregister the Reader with the name.
+func (m *Metrics) RemoveReader(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.readers, name)
+}
+
+// collect is used to load the statistics, they are sorted by the name.
+func (m *Metrics) collect() (writers, readers []*metricsSource) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name, w := range m.writers {
+		writers = append(writers, &metricsSource{
+			role:  "writer",
+			name:  name,
+			stats: w.Stats(),
+		})
+	}
+	for name, r := range m.readers {
+		readers = append(readers, &metricsSource{
+			role:  "reader",
+			name:  name,
+			stats: r.Stats(),
+		})
+	}
+	sortMetricsSources(writers)
+	sortMetricsSources(readers)
+	return writers, readers
+}
+
+func sortMetricsSources(sources []*metricsSource) {
+	sort.Slice(sources, func(i, j int) bool {
+		return sources[i].name < sources[j].name
+	})
+}
+
+// sizes is used to get the size of the frame headers and the size of the
+// compressed data, the direction of them is different in Writer and Reader.
+func (s *metricsSource) sizes() (uncompressed, compressed uint64) {
+	if s.role == "writer" {
+		return s.stats.BytesIn, s.stats.BytesOut
+	}
+	return s.stats.BytesOut, s.stats.BytesIn
+}
+
+func (s *metricsSource) compressionRatio() float64 {
+	uncompressed, compressed := s.sizes()
+	if compressed == 0 {
+		return 0
+	}
+	return float64(uncompressed) / float64(compressed)
+}
+
+func (s *metricsSource) derive() *metricsStats {
+	return &metricsStats{
+		Stats:            s.stats,
+		CompressionRatio: s.compressionRatio(),
+		AverageChanges:   s.stats.AverageChanges(),
+		HitRate:          s.stats.HitRate(),
+	}
+}
+
+// aggregate is used to sum the statistics of the sources.
+func aggregate(role string, sources []*metricsSource) *metricsSource {
+	total := metricsSource{role: role}
+	s := &total.stats
+	for _, src := range sources {
+		o := src.stats
+		s.AddDictionary += o.AddDictionary
+		s.ChangedData += o.ChangedData
+		s.LastData += o.LastData
+		s.PreviousData += o.PreviousData
+		s.SwappedData += o.SwappedData
+		s.BytesIn += o.BytesIn
+		s.BytesOut += o.BytesOut
+		s.Changes += o.Changes
+		s.Hits += o.Hits
+		s.Misses += o.Misses
+		s.Evictions += o.Evictions
+		s.FastSearches += o.FastSearches
+		s.CustomSearches += o.CustomSearches
+		s.SlowSearches += o.SlowSearches
+	}
+	return &total
+}
+
+// String implements expvar.Var, it returns the JSON object that contains
+// the aggregated statistics and the statistics about each Writer and Reader.
+func (m *Metrics) String() string {
+	writers, readers := m.collect()
+	v := struct {
+		Writer  *metricsStats
+		Reader  *metricsStats
+		Writers map[string]*metricsStats
+		Readers map[string]*metricsStats
+	}{
+		Writer:  aggregate("writer", writers).derive(),
+		Reader:  aggregate("reader", readers).derive(),
+		Writers: make(map[string]*metricsStats, len(writers)),
+		Readers: make(map[string]*metricsStats, len(readers)),
+	}
+	for _, src := range writers {
+		v.Writers[src.name] = src.derive()
+	}
+	for _, src := range readers {
+		v.Readers[src.name] = src.derive()
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return string(data)
+}
+
+// ServeHTTP implements http.Handler, it writes the statistics about each
+// Writer and Reader in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = m.writePrometheus(w)
+}
+
+// promFamily is the metric family in the Prometheus text format, if the
+// label is not empty, each value has its own label value in labels.
+type promFamily struct {
+	name   string
+	help   string
+	typ    string
+	label  string
+	labels []string
+	values func(s *metricsSource) []float64
+
+	// it is only counted by the Writer
+	writer bool
+}
+
+var promFamilies = []*promFamily{
+	{
+		name:   "cfh_records_total",
+		help:   "The number of records by command.",
+		typ:    "counter",
+		label:  "command",
+		labels: []string{"add_dictionary", "changed_data", "last_data", "previous_data", "swapped_data"},
+		values: func(s *metricsSource) []float64 {
+			return []float64{
+				float64(s.stats.AddDictionary),
+				float64(s.stats.ChangedData),
+				float64(s.stats.LastData),
+				float64(s.stats.PreviousData),
+				float64(s.stats.SwappedData),
+			}
+		},
+	},
+	{
+		name: "cfh_uncompressed_bytes_total",
+		help: "The size of the frame headers.",
+		typ:  "counter",
+		values: func(s *metricsSource) []float64 {
+			uncompressed, _ := s.sizes()
+			return []float64{float64(uncompressed)}
+		},
+	},
+	{
+		name: "cfh_compressed_bytes_total",
+		help: "The size of the compressed stream or datagrams.",
+		typ:  "counter",
+		values: func(s *metricsSource) []float64 {
+			_, compressed := s.sizes()
+			return []float64{float64(compressed)}
+		},
+	},
+	{
+		name: "cfh_compression_ratio",
+		help: "The size of the frame headers divided by the size of the compressed data.",
+		typ:  "gauge",
+		values: func(s *metricsSource) []float64 {
+			return []float64{s.compressionRatio()}
+		},
+	},
+	{
+		name: "cfh_changed_bytes_total",
+		help: "The number of changed bytes in the changed and swapped data records.",
+		typ:  "counter",
+		values: func(s *metricsSource) []float64 {
+			return []float64{float64(s.stats.Changes)}
+		},
+	},
+	{
+		name: "cfh_dictionary_hits_total",
+		help: "The number of records that reference a dictionary.",
+		typ:  "counter",
+		values: func(s *metricsSource) []float64 {
+			return []float64{float64(s.stats.Hits)}
+		},
+	},
+	{
+		name: "cfh_dictionary_misses_total",
+		help: "The number of records that add a dictionary.",
+		typ:  "counter",
+		values: func(s *metricsSource) []float64 {
+			return []float64{float64(s.stats.Misses)}
+		},
+	},
+	{
+		name: "cfh_dictionary_evictions_total",
+		help: "The number of dictionaries that are evicted.",
+		typ:  "counter",
+		values: func(s *metricsSource) []float64 {
+			return []float64{float64(s.stats.Evictions)}
+		},
+	},
+	{
+		name:   "cfh_searches_total",
+		help:   "The number of dictionary searches by path.",
+		typ:    "counter",
+		label:  "path",
+		labels: []string{"fast", "custom", "slow"},
+		writer: true,
+		values: func(s *metricsSource) []float64 {
+			return []float64{
+				float64(s.stats.FastSearches),
+				float64(s.stats.CustomSearches),
+				float64(s.stats.SlowSearches),
+			}
+		},
+	},
+}
+
+// writePrometheus is used to write the statistics in the Prometheus text
+// exposition format, each sample has the role and the name labels.
+func (m *Metrics) writePrometheus(w io.Writer) error {
+	writers, readers := m.collect()
+	sources := append(writers, readers...)
+	buf := bytes.NewBuffer(make([]byte, 0, 4096))
+	for _, family := range promFamilies {
+		fmt.Fprintf(buf, "# HELP %s %s\n", family.name, family.help)
+		fmt.Fprintf(buf, "# TYPE %s %s\n", family.name, family.typ)
+		for _, src := range sources {
+			if family.writer && src.role != "writer" {
+				continue
+			}
+			values := family.values(src)
+			for i, value := range values {
+				buf.WriteString(family.name)
+				buf.WriteString(`{role="`)
+				buf.WriteString(src.role)
+				buf.WriteString(`",name="`)
+				buf.WriteString(escapeLabelValue(src.name))
+				if family.label != "" {
+					buf.WriteString(`",`)
+					buf.WriteString(family.label)
+					buf.WriteString(`="`)
+					buf.WriteString(family.labels[i])
+				}
+				buf.WriteString(`"} `)
+				buf.WriteString(strconv.FormatFloat(value, 'f', -1, 64))
+				buf.WriteByte('\n')
+			}
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabelValue is used to escape the label value in Prometheus text format.
+func escapeLabelValue(s string) string {
+	return labelValueReplacer.Replace(s)
+}
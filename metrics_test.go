@@ -0,0 +1,156 @@
+package cfh
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testNewMetricsPair(t *testing.T, headers [][]byte) (*Writer, *Reader) {
+	output := bytes.NewBuffer(make([]byte, 0, 4096))
+	w := NewWriter(output)
+	for _, header := range headers {
+		_, err := w.Write(header)
+		require.NoError(t, err)
+	}
+	r := NewReader(output)
+	testReadFrameHeaders(t, r, headers)
+	return w, r
+}
+
+func TestMetrics(t *testing.T) {
+	headers := testGenerateFrameHeaders(t)[:4096]
+	w, r := testNewMetricsPair(t, headers)
+
+	t.Run("register", func(t *testing.T) {
+		metrics := NewMetrics()
+		err := metrics.AddWriter("a", w)
+		require.NoError(t, err)
+		err = metrics.AddWriter("a", w)
+		require.EqualError(t, err, `writer "a" is already registered`)
+		err = metrics.AddReader("a", r)
+		require.NoError(t, err)
+		err = metrics.AddReader("a", r)
+		require.EqualError(t, err, `reader "a" is already registered`)
+
+		metrics.RemoveWriter("a")
+		metrics.RemoveReader("a")
+		writers, readers := metrics.collect()
+		require.Empty(t, writers)
+		require.Empty(t, readers)
+
+		err = metrics.AddWriter("a", w)
+		require.NoError(t, err)
+	})
+
+	t.Run("expvar", func(t *testing.T) {
+		metrics := NewMetrics()
+		err := metrics.AddWriter("a", w)
+		require.NoError(t, err)
+		err = metrics.AddWriter("b", w)
+		require.NoError(t, err)
+		err = metrics.AddReader("a", r)
+		require.NoError(t, err)
+
+		expvar.Publish("cfh_test_metrics", metrics)
+		require.Equal(t, metrics, expvar.Get("cfh_test_metrics"))
+
+		var v struct {
+			Writer  *metricsStats
+			Reader  *metricsStats
+			Writers map[string]*metricsStats
+			Readers map[string]*metricsStats
+		}
+		err = json.Unmarshal([]byte(metrics.String()), &v)
+		require.NoError(t, err)
+
+		ws := w.Stats()
+		rs := r.Stats()
+		require.Equal(t, ws, v.Writers["a"].Stats)
+		require.Equal(t, ws, v.Writers["b"].Stats)
+		require.Equal(t, rs, v.Readers["a"].Stats)
+		require.Equal(t, 2*ws.BytesIn, v.Writer.BytesIn)
+		require.Equal(t, 2*ws.Hits, v.Writer.Hits)
+		require.Equal(t, rs.BytesIn, v.Reader.BytesIn)
+
+		ratio := float64(ws.BytesIn) / float64(ws.BytesOut)
+		require.Equal(t, ratio, v.Writers["a"].CompressionRatio)
+		require.Equal(t, ratio, v.Writer.CompressionRatio)
+		require.Equal(t, ws.HitRate(), v.Writer.HitRate)
+		require.Equal(t, ws.AverageChanges(), v.Writer.AverageChanges)
+	})
+
+	t.Run("prometheus", func(t *testing.T) {
+		metrics := NewMetrics()
+		err := metrics.AddWriter(`a"b\c`, w)
+		require.NoError(t, err)
+		err = metrics.AddReader("d", r)
+		require.NoError(t, err)
+
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		metrics.ServeHTTP(recorder, req)
+
+		resp := recorder.Result()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Contains(t, resp.Header.Get("Content-Type"), "version=0.0.4")
+
+		ws := w.Stats()
+		body := recorder.Body.String()
+		for _, line := range []string{
+			"# TYPE cfh_records_total counter",
+			"# TYPE cfh_compression_ratio gauge",
+			`cfh_uncompressed_bytes_total{role="reader",name="d"} ` + strconv.FormatUint(ws.BytesIn, 10),
+			`cfh_compressed_bytes_total{role="reader",name="d"} ` + strconv.FormatUint(ws.BytesOut, 10),
+			`cfh_dictionary_misses_total{role="writer",name="a\"b\\c"} ` + strconv.FormatUint(ws.Misses, 10),
+			`cfh_records_total{role="writer",name="a\"b\\c",command="last_data"} ` + strconv.FormatUint(ws.LastData, 10),
+			`cfh_searches_total{role="writer",name="a\"b\\c",path="fast"} ` + strconv.FormatUint(ws.FastSearches, 10),
+		} {
+			require.Contains(t, body, line+"\n")
+		}
+		require.NotContains(t, body, `cfh_searches_total{role="reader"`)
+
+		// check the format of each sample
+		for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+			if strings.HasPrefix(line, "#") {
+				continue
+			}
+			require.Regexp(t, `^cfh_[a-z_]+\{role="(writer|reader)",name=".*"(,[a-z]+="[a-z_]+")?\} [0-9.]+$`, line)
+		}
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		metrics := NewMetrics()
+		output := bytes.NewBuffer(make([]byte, 0, 64*1024))
+		cw := NewWriter(output)
+		err := metrics.AddWriter("c", cw)
+		require.NoError(t, err)
+
+		wg := sync.WaitGroup{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				for _, header := range headers {
+					_, err := cw.Write(header)
+					require.NoError(t, err)
+				}
+			}
+		}()
+		for i := 0; i < 100; i++ {
+			_ = metrics.String()
+			metrics.ServeHTTP(httptest.NewRecorder(), nil)
+		}
+		wg.Wait()
+
+		require.Equal(t, uint64(10*len(headers)), cw.Stats().Hits+cw.Stats().Misses+cw.Stats().LastData)
+	})
+}
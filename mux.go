@@ -0,0 +1,246 @@
+package cfh
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// maxMuxChannels is the maximum number of channels in Mux.
+const maxMuxChannels = 256
+
+// maxMuxPendingSize is the maximum size of the received records that are
+// not read in one channel, the larger record is accepted if it is empty.
+const maxMuxPendingSize = 256 * 1024
+
+// Mux is used to carry multiple independent compressed streams over one
+// transport, each channel has its own Writer and Reader, so the frame
+// headers from different sources will not evict the dictionaries of each
+// other. The channels that are not opened by Channel are also created
+// when receive records about them, the records are buffered until read.
+// If the buffer of a channel is full, the transport will not be read
+// until the channel is read, so all the channels should be read.
+//
+// Each record is written with the channel id and the size of it.
+//
+// +------------+-------------+-----------+
+// | channel id | record size |  record   |
+// +------------+-------------+-----------+
+// |   uint8    |   uint16    | var bytes |
+// +------------+-------------+-----------+
+type Mux struct {
+	rw   io.ReadWriter
+	opts *Options
+
+	mu       sync.Mutex
+	channels [maxMuxChannels]*muxChannel
+
+	// about write records
+	wmu  sync.Mutex
+	wbuf bytes.Buffer
+	werr error
+
+	// about read records, rmu protects the buffers of the channels,
+	// only one goroutine reads the transport and the others wait cond
+	rmu     sync.Mutex
+	cond    *sync.Cond
+	reading bool
+	rbuf    []byte
+	rerr    error
+}
+
+// muxChannel is a logical channel in Mux.
+type muxChannel struct {
+	mux *Mux
+	id  uint8
+	w   *Writer
+	rmu sync.Mutex
+	r   *Reader
+	in  muxSource
+}
+
+// muxSource is the under reader of the Reader in channel, it contains the
+// received records that not be read, the buffer is protected by Mux.rmu.
+type muxSource struct {
+	mux *Mux
+	buf bytes.Buffer
+}
+
+// NewMux is used to create a new multiplexer over the transport, the
+// options are used to create the Writer and the Reader of each channel,
+// so the both sides must use the same options. The datagram mode and
+// the buffered mode are not supported.
+func NewMux(rw io.ReadWriter, opts *Options) (*Mux, error) {
+	err := checkStreamOptions(opts, "Mux")
+	if err != nil {
+		return nil, err
+	}
+	opts, err = opts.apply()
+	if err != nil {
+		return nil, err
+	}
+	m := Mux{
+		rw:   rw,
+		opts: opts,
+		rbuf: make([]byte, maxConnFrameSize),
+	}
+	m.cond = sync.NewCond(&m.rmu)
+	return &m, nil
+}
+
+// Channel is used to get the logical channel with the id, Write on it
+// will compress one frame header and Read on it will decompress one.
+// Write calls on all channels are serialized, Read calls on different
+// channels can be concurrent, the buffered records are read without
+// waiting the transport.
+func (m *Mux) Channel(id uint8) io.ReadWriter {
+	return m.channel(id)
+}
+
+func (m *Mux) channel(id uint8) *muxChannel {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ch := m.channels[id]
+	if ch != nil {
+		return ch
+	}
+	ch = &muxChannel{
+		mux: m,
+		id:  id,
+	}
+	// the options are already checked
+	ch.w, _ = NewWriterWithOptions(&m.wbuf, m.opts)
+	ch.in.mux = m
+	ch.r, _ = NewReaderWithOptions(&ch.in, m.opts)
+	m.channels[id] = ch
+	return ch
+}
+
+// Write is used to compress the frame header and write the record to
+// the transport by one Write call.
+func (ch *muxChannel) Write(b []byte) (int, error) {
+	m := ch.mux
+	m.wmu.Lock()
+	defer m.wmu.Unlock()
+	if m.werr != nil {
+		return 0, m.werr
+	}
+	m.wbuf.Reset()
+	// reserve the channel id and the record size
+	m.wbuf.Write([]byte{ch.id, 0, 0})
+	n, err := ch.w.Write(b)
+	if err != nil {
+		return 0, err
+	}
+	record := m.wbuf.Bytes()
+	binary.BigEndian.PutUint16(record[1:], uint16(len(record)-3))
+	_, err = m.rw.Write(record)
+	if err != nil {
+		m.werr = err
+		return 0, err
+	}
+	return n, nil
+}
+
+// Read is used to decompress one frame header from the channel.
+func (ch *muxChannel) Read(b []byte) (int, error) {
+	ch.rmu.Lock()
+	defer ch.rmu.Unlock()
+	return ch.r.Read(b)
+}
+
+// Read implements io.Reader, it will read records from the transport
+// until receive the record about the channel.
+func (s *muxSource) Read(b []byte) (int, error) {
+	m := s.mux
+	m.rmu.Lock()
+	defer m.rmu.Unlock()
+	err := s.fill()
+	if err != nil {
+		return 0, err
+	}
+	n, _ := s.buf.Read(b)
+	// wake up the goroutine that waits the buffer is not full
+	m.cond.Broadcast()
+	return n, nil
+}
+
+// ReadByte implements io.ByteReader.
+func (s *muxSource) ReadByte() (byte, error) {
+	m := s.mux
+	m.rmu.Lock()
+	defer m.rmu.Unlock()
+	err := s.fill()
+	if err != nil {
+		return 0, err
+	}
+	b, _ := s.buf.ReadByte()
+	m.cond.Broadcast()
+	return b, nil
+}
+
+// fill is used to wait the records about the channel, it must be called
+// with rmu locked. If no goroutine reads the transport, it will read the
+// records and dispatch them to the channels, rmu is unlocked during read.
+func (s *muxSource) fill() error {
+	m := s.mux
+	for s.buf.Len() == 0 {
+		if m.rerr != nil {
+			return m.rerr
+		}
+		if m.reading {
+			m.cond.Wait()
+			continue
+		}
+		m.reading = true
+		m.rmu.Unlock()
+		id, record, err := m.readRecord()
+		m.rmu.Lock()
+		if err == nil {
+			m.dispatch(id, record)
+		} else {
+			m.rerr = err
+		}
+		m.reading = false
+		m.cond.Broadcast()
+	}
+	return nil
+}
+
+// dispatch is used to append the record to the buffer of the channel, if
+// the buffer is full, it will wait until the channel is read. It must be
+// called with rmu locked and the reading flag is set.
+func (m *Mux) dispatch(id uint8, record []byte) {
+	in := &m.channel(id).in
+	for in.buf.Len() != 0 && in.buf.Len()+len(record) > maxMuxPendingSize {
+		m.cond.Wait()
+	}
+	in.buf.Write(record)
+}
+
+// readRecord is used to read one record from the transport, the returned
+// record is only valid until the next call.
+func (m *Mux) readRecord() (uint8, []byte, error) {
+	head := m.rbuf[:3]
+	_, err := io.ReadFull(m.rw, head)
+	if err == io.EOF {
+		// the transport is ended at the record boundary
+		return 0, nil, io.EOF
+	}
+	if err == io.ErrUnexpectedEOF {
+		err = fmt.Errorf("%w: %w", ErrTruncated, err)
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read channel record header: %w", err)
+	}
+	id := head[0]
+	size := int(binary.BigEndian.Uint16(head[1:]))
+	record := m.rbuf[:size]
+	err = readFull(m.rw, record)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read channel record: %w", err)
+	}
+	return id, record, nil
+}
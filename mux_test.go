@@ -0,0 +1,261 @@
+package cfh
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMux(t *testing.T) {
+	// the channels that are not read will not reach the pending limit
+	headers := testGenerateFrameHeaders(t)[:4096]
+
+	t.Run("common", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 4*1024*1024))
+		mux, err := NewMux(output, nil)
+		require.NoError(t, err)
+		for i, header := range headers {
+			_, err = mux.Channel(uint8(i % 4)).Write(header)
+			require.NoError(t, err)
+		}
+
+		demux, err := NewMux(output, nil)
+		require.NoError(t, err)
+		// read the channels in reverse order
+		for c := 3; c >= 0; c-- {
+			ch := demux.Channel(uint8(c))
+			for i := c; i < len(headers); i += 4 {
+				buf := make([]byte, len(headers[i]))
+				_, err = ch.Read(buf)
+				require.NoError(t, err)
+				require.Equal(t, headers[i], buf)
+			}
+			_, err = ch.Read(make([]byte, 16))
+			require.Equal(t, io.EOF, err)
+		}
+	})
+
+	t.Run("separate dictionaries", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+		opts := Options{DictSize: 1}
+		mux, err := NewMux(output, &opts)
+		require.NoError(t, err)
+
+		ch0 := mux.Channel(0)
+		ch1 := mux.Channel(1)
+		for i := 0; i < 4; i++ {
+			_, err = ch0.Write(testIPv4TCPFrameHeader1)
+			require.NoError(t, err)
+			_, err = ch1.Write(testIPv6TCPFrameHeader1)
+			require.NoError(t, err)
+		}
+		// only the first record of each channel adds dictionary
+		ch := mux.channels[0]
+		require.Equal(t, testIPv4TCPFrameHeader1, ch.w.dict[0])
+		ch = mux.channels[1]
+		require.Equal(t, testIPv6TCPFrameHeader1, ch.w.dict[0])
+		// the following records of each channel are cmdLast
+		size := 3 + paramsSize + 2 + len(testIPv4TCPFrameHeader1)
+		size += 3 + paramsSize + 2 + len(testIPv6TCPFrameHeader1)
+		size += 2 * 3 * (3 + 1)
+		require.Equal(t, size, output.Len())
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		p1, p2 := net.Pipe()
+		mux, err := NewMux(p1, nil)
+		require.NoError(t, err)
+		demux, err := NewMux(p2, nil)
+		require.NoError(t, err)
+
+		const channels = 4
+		errCh := make(chan error, 2*channels)
+		wg := sync.WaitGroup{}
+		for c := 0; c < channels; c++ {
+			wg.Add(2)
+			go func(c int) {
+				defer wg.Done()
+				ch := mux.Channel(uint8(c))
+				for i := c; i < len(headers); i += channels {
+					_, err := ch.Write(headers[i])
+					if err != nil {
+						errCh <- err
+						return
+					}
+				}
+			}(c)
+			go func(c int) {
+				defer wg.Done()
+				ch := demux.Channel(uint8(c))
+				for i := c; i < len(headers); i += channels {
+					buf := make([]byte, len(headers[i]))
+					_, err := ch.Read(buf)
+					if err != nil {
+						errCh <- err
+						return
+					}
+					if !bytes.Equal(headers[i], buf) {
+						errCh <- fmt.Errorf("header %d in channel %d is mismatched", i, c)
+						return
+					}
+				}
+			}(c)
+		}
+		wg.Wait()
+		close(errCh)
+		for err := range errCh {
+			require.NoError(t, err)
+		}
+	})
+
+	t.Run("idle channel", func(t *testing.T) {
+		p1, p2 := net.Pipe()
+		mux, err := NewMux(p1, nil)
+		require.NoError(t, err)
+		demux, err := NewMux(p2, nil)
+		require.NoError(t, err)
+
+		// the Read on channel 0 is waiting the transport
+		errCh := make(chan error, 1)
+		go func() {
+			_, err := demux.Channel(0).Read(make([]byte, MaxFrameHeaderSize))
+			errCh <- err
+		}()
+
+		_, err = mux.Channel(1).Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+		buf := make([]byte, len(testIPv4TCPFrameHeader1))
+		_, err = demux.Channel(1).Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, testIPv4TCPFrameHeader1, buf)
+
+		_, err = mux.Channel(0).Write(testIPv6TCPFrameHeader1)
+		require.NoError(t, err)
+		require.NoError(t, <-errCh)
+	})
+
+	t.Run("pending limit", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 2*maxMuxPendingSize))
+		mux, err := NewMux(output, nil)
+		require.NoError(t, err)
+		var pending [][]byte
+		for i := 0; output.Len() < 2*maxMuxPendingSize; i++ {
+			header := headers[i%len(headers)]
+			_, err = mux.Channel(1).Write(header)
+			require.NoError(t, err)
+			pending = append(pending, header)
+		}
+		_, err = mux.Channel(0).Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+
+		demux, err := NewMux(output, nil)
+		require.NoError(t, err)
+		errCh := make(chan error, 1)
+		go func() {
+			_, err := demux.Channel(0).Read(make([]byte, MaxFrameHeaderSize))
+			errCh <- err
+		}()
+
+		// the transport is not read when the buffer of channel 1 is full
+		select {
+		case err = <-errCh:
+			t.Fatalf("read channel 0 before channel 1 is read: %v", err)
+		case <-time.After(100 * time.Millisecond):
+		}
+		demux.rmu.Lock()
+		size := demux.channels[1].in.buf.Len()
+		demux.rmu.Unlock()
+		require.LessOrEqual(t, size, maxMuxPendingSize)
+
+		ch := demux.Channel(1)
+		for _, header := range pending {
+			buf := make([]byte, len(header))
+			_, err = ch.Read(buf)
+			require.NoError(t, err)
+			require.Equal(t, header, buf)
+		}
+		require.NoError(t, <-errCh)
+	})
+
+	t.Run("truncated record", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+		mux, err := NewMux(output, nil)
+		require.NoError(t, err)
+		_, err = mux.Channel(1).Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+		data := output.Bytes()
+
+		for _, item := range []*struct {
+			data   []byte
+			errStr string
+		}{
+			{data[:2], "failed to read channel record header: record is truncated: unexpected EOF"},
+			{data[:5], "failed to read channel record: record is truncated: unexpected EOF"},
+		} {
+			demux, err := NewMux(bytes.NewBuffer(item.data), nil)
+			require.NoError(t, err)
+			ch := demux.Channel(1)
+			n, err := ch.Read(make([]byte, 16))
+			require.EqualError(t, err, "failed to read decompress command: "+item.errStr)
+			require.Zero(t, n)
+		}
+	})
+
+	t.Run("failed to write", func(t *testing.T) {
+		pr, pw := io.Pipe()
+		err := pr.Close()
+		require.NoError(t, err)
+		mux, err := NewMux(struct {
+			io.Reader
+			io.Writer
+		}{pr, pw}, nil)
+		require.NoError(t, err)
+
+		ch := mux.Channel(0)
+		n, err := ch.Write(testIPv4TCPFrameHeader1)
+		require.Equal(t, io.ErrClosedPipe, err)
+		require.Zero(t, n)
+		// the error is sticky for all channels
+		_, err = mux.Channel(1).Write(testIPv4TCPFrameHeader1)
+		require.Equal(t, io.ErrClosedPipe, err)
+
+		n, err = ch.Write(make([]byte, MaxFrameHeaderSize+1))
+		require.Equal(t, io.ErrClosedPipe, err)
+		require.Zero(t, n)
+	})
+
+	t.Run("too large frame header", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+		mux, err := NewMux(output, nil)
+		require.NoError(t, err)
+		n, err := mux.Channel(0).Write(make([]byte, MaxFrameHeaderSize+1))
+		require.ErrorIs(t, err, ErrHeaderTooLarge)
+		require.Zero(t, n)
+		require.Zero(t, output.Len())
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+
+		opts := Options{Datagram: true}
+		mux, err := NewMux(output, &opts)
+		require.EqualError(t, err, "datagram mode is not supported by Mux")
+		require.Nil(t, mux)
+
+		opts = Options{BufferRecords: 16}
+		mux, err = NewMux(output, &opts)
+		require.EqualError(t, err, "buffered mode is not supported by Mux")
+		require.Nil(t, mux)
+
+		opts = Options{DictSize: -1}
+		mux, err = NewMux(output, &opts)
+		require.EqualError(t, err, "dictionary size cannot less than 1")
+		require.Nil(t, mux)
+	})
+}
@@ -0,0 +1,424 @@
+package cfh
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultIdleTimeout is the default timeout about the context of idle peer.
+const defaultIdleTimeout = 5 * time.Minute
+
+// defaultMaxPeers is the default maximum number of the peer contexts.
+const defaultMaxPeers = 1024
+
+// maxPacketSize is the maximum size of the packet in PacketConn.
+const maxPacketSize = 1 + 4 + 2 + maxDatagramSize + maxConnFrameSize
+
+// types of the packet in PacketConn.
+const (
+	// the whole frame is written without compression
+	packetFrameRaw = iota
+
+	// the frame header is compressed, then the payload is written
+	packetFrameCompressed
+
+	// the feedback message from the Reader to the Writer
+	packetFeedback
+)
+
+// PacketConnOptions contains options about PacketConn.
+type PacketConnOptions struct {
+	// Options are used to create the Writer and the Reader for each
+	// peer, the datagram mode is always enabled, the both sides must
+	// use the same options.
+	Options *Options
+
+	// IdleTimeout is the timeout about the context of the peer that
+	// not send or receive any frame, default is 5 minutes.
+	IdleTimeout time.Duration
+
+	// MaxPeers is the maximum number of the peer contexts, when it is
+	// reached, the context of the least recently used peer is deleted
+	// before create a new one, default is 1024.
+	MaxPeers int
+}
+
+// PacketConn is used to wrap a packet connection like UDP, it keeps the
+// Writer and Reader context for each remote address, each frame will be
+// compressed to one packet. The packet that cannot be decompressed will
+// be discarded, and it is counted by the Dropped method.
+//
+// Each packet is started with a type byte and the session about the
+// Writer context, the session is changed when the context is created
+// again, then the Reader context of the peer will be reset.
+//
+// +------+---------+-------------+--------------+-----------+
+// | type | session | record size | frame header |  payload  |
+// +------+---------+-------------+--------------+-----------+
+// | byte | uint32  |   uint16    |    record    | var bytes |
+// +------+---------+-------------+--------------+-----------+
+//
+// The feedback message is sent to the peer with the session of it.
+//
+// +------+---------+-----------+
+// | type | session |  message  |
+// +------+---------+-----------+
+// | byte | uint32  | var bytes |
+// +------+---------+-----------+
+//
+// The feedback message from the address without context is discarded.
+//
+// The frame that the header is not preferred be compressed is written
+// without compression.
+//
+// +------+-----------+
+// | type |   frame   |
+// +------+-----------+
+// | byte | var bytes |
+// +------+-----------+
+type PacketConn struct {
+	net.PacketConn
+
+	opts      *Options
+	idle      time.Duration
+	peerLimit int
+
+	mu    sync.Mutex
+	peers map[string]*packetPeer
+	swept time.Time
+
+	// about read frame
+	rmu   sync.Mutex
+	rbuf  []byte
+	frame []byte
+
+	dropped atomic.Uint64
+}
+
+// packetPeer contains the Writer and Reader context about a peer.
+type packetPeer struct {
+	wmu      sync.Mutex
+	w        *Writer
+	wbuf     bytes.Buffer
+	wSession uint32
+
+	// only be used by ReadFrame
+	r        *Reader
+	rSession uint32
+	rInit    bool
+
+	last atomic.Int64
+}
+
+// NewPacketConn is used to create a new PacketConn with options.
+func NewPacketConn(conn net.PacketConn, opts *PacketConnOptions) (*PacketConn, error) {
+	if opts == nil {
+		opts = new(PacketConnOptions)
+	}
+	o := Options{}
+	if opts.Options != nil {
+		o = *opts.Options
+	}
+	o.Datagram = true
+	options, err := o.apply()
+	if err != nil {
+		return nil, err
+	}
+	idle := opts.IdleTimeout
+	if idle < 0 {
+		return nil, fmt.Errorf("invalid idle timeout: %s", idle)
+	}
+	if idle == 0 {
+		idle = defaultIdleTimeout
+	}
+	maxPeers := opts.MaxPeers
+	if maxPeers < 0 {
+		return nil, fmt.Errorf("invalid maximum number of peers: %d", maxPeers)
+	}
+	if maxPeers == 0 {
+		maxPeers = defaultMaxPeers
+	}
+	c := PacketConn{
+		PacketConn: conn,
+		opts:       options,
+		idle:       idle,
+		peerLimit:  maxPeers,
+		peers:      make(map[string]*packetPeer),
+		swept:      time.Now(),
+		rbuf:       make([]byte, maxPacketSize+1),
+		frame:      make([]byte, MaxFrameHeaderSize+maxConnFrameSize),
+	}
+	return &c, nil
+}
+
+// peer is used to get the context about the remote address, it will
+// create a new context if it is not exist, and clean the idle contexts.
+// If the number of contexts reaches the limit, the least recently used
+// one is deleted.
+func (c *PacketConn) peer(addr net.Addr) (*packetPeer, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if now.Sub(c.swept) >= c.idle {
+		c.expire(now)
+	}
+	key := peerKey(addr)
+	p := c.peers[key]
+	if p != nil {
+		p.last.Store(now.UnixNano())
+		return p, nil
+	}
+	if len(c.peers) >= c.peerLimit {
+		c.expire(now)
+		if len(c.peers) >= c.peerLimit {
+			c.evict()
+		}
+	}
+	p = new(packetPeer)
+	w, err := NewWriterWithOptions(&p.wbuf, c.opts)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewReaderWithOptions(nil, c.opts)
+	if err != nil {
+		return nil, err
+	}
+	var session [4]byte
+	_, err = io.ReadFull(rand.Reader, session[:])
+	if err != nil {
+		return nil, err
+	}
+	p.w = w
+	p.r = r
+	p.wSession = binary.BigEndian.Uint32(session[:])
+	p.last.Store(now.UnixNano())
+	c.peers[key] = p
+	return p, nil
+}
+
+// lookup is used to get the context about the remote address, it will
+// return nil if it is not exist, the new context is not created.
+func (c *PacketConn) lookup(addr net.Addr) *packetPeer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p := c.peers[peerKey(addr)]
+	if p != nil {
+		p.last.Store(time.Now().UnixNano())
+	}
+	return p
+}
+
+func peerKey(addr net.Addr) string {
+	return addr.Network() + "/" + addr.String()
+}
+
+// evict is used to delete the context of the least recently used peer.
+func (c *PacketConn) evict() {
+	var (
+		key  string
+		last int64
+	)
+	for k, p := range c.peers {
+		l := p.last.Load()
+		if key == "" || l < last {
+			key = k
+			last = l
+		}
+	}
+	delete(c.peers, key)
+}
+
+// expire is used to delete the contexts of idle peers.
+func (c *PacketConn) expire(now time.Time) {
+	for key, p := range c.peers {
+		if now.Sub(time.Unix(0, p.last.Load())) >= c.idle {
+			delete(c.peers, key)
+		}
+	}
+	c.swept = now
+}
+
+// WriteFrame is used to compress the frame header with the context of
+// the remote address and write the frame to it by one packet.
+func (c *PacketConn) WriteFrame(frame []byte, addr net.Addr) error {
+	size, prefer := IsFrameHeaderPreferBeCompressed(frame)
+	if !prefer {
+		if len(frame) > maxConnFrameSize {
+			return fmt.Errorf("frame is too large: %d", len(frame))
+		}
+		packet := make([]byte, 1+len(frame))
+		packet[0] = packetFrameRaw
+		copy(packet[1:], frame)
+		_, err := c.PacketConn.WriteTo(packet, addr)
+		return err
+	}
+	payload := frame[size:]
+	if len(payload) > maxConnFrameSize {
+		return fmt.Errorf("payload is too large: %d", len(payload))
+	}
+	p, err := c.peer(addr)
+	if err != nil {
+		return err
+	}
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	p.wbuf.Reset()
+	p.wbuf.WriteByte(packetFrameCompressed)
+	_ = binary.Write(&p.wbuf, binary.BigEndian, p.wSession)
+	// reserve the record size
+	p.wbuf.Write([]byte{0, 0})
+	_, err = p.w.Write(frame[:size])
+	if err != nil {
+		return err
+	}
+	packet := p.wbuf.Bytes()
+	binary.BigEndian.PutUint16(packet[1+4:], uint16(len(packet)-1-4-2))
+	p.wbuf.Write(payload)
+	_, err = c.PacketConn.WriteTo(p.wbuf.Bytes(), addr)
+	return err
+}
+
+// ReadFrame is used to read one frame from the under connection and
+// decompress the frame header with the context of the remote address.
+// The returned slice is only valid until the next call. The packet that
+// cannot be decompressed is discarded, then it will read the next one.
+func (c *PacketConn) ReadFrame() ([]byte, net.Addr, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(c.rbuf)
+		if err != nil {
+			return nil, nil, err
+		}
+		frame, err := c.decodePacket(c.rbuf[:n], addr)
+		if err != nil {
+			c.dropped.Add(1)
+			continue
+		}
+		if frame != nil {
+			return frame, addr, nil
+		}
+	}
+}
+
+// decodePacket is used to decode the packet, if the packet is not
+// contain frame, it will return nil.
+func (c *PacketConn) decodePacket(packet []byte, addr net.Addr) ([]byte, error) {
+	if len(packet) < 1 || len(packet) > maxPacketSize {
+		return nil, errors.New("invalid packet size")
+	}
+	typ := packet[0]
+	packet = packet[1:]
+	switch typ {
+	case packetFrameRaw:
+		n := copy(c.frame, packet)
+		return c.frame[:n], nil
+	case packetFrameCompressed:
+		return c.decodeFrame(packet, addr)
+	case packetFeedback:
+		return nil, c.processFeedback(packet, addr)
+	default:
+		return nil, fmt.Errorf("invalid packet type: %d", typ)
+	}
+}
+
+func (c *PacketConn) decodeFrame(packet []byte, addr net.Addr) ([]byte, error) {
+	if len(packet) < 4+2 {
+		return nil, ErrTruncated
+	}
+	session := binary.BigEndian.Uint32(packet)
+	size := int(binary.BigEndian.Uint16(packet[4:]))
+	packet = packet[4+2:]
+	if size > len(packet) || size > maxDatagramSize {
+		return nil, errors.New("invalid record size")
+	}
+	p, err := c.peer(addr)
+	if err != nil {
+		return nil, err
+	}
+	// the peer created a new Writer context
+	if !p.rInit || p.rSession != session {
+		p.r.Reset(nil)
+		p.rSession = session
+		p.rInit = true
+	}
+	ok, err := p.r.decodeDatagram(packet[:size])
+	c.sendFeedback(p, addr)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, nil
+	}
+	n := copy(c.frame, p.r.data)
+	n += copy(c.frame[n:], packet[size:])
+	return c.frame[:n], nil
+}
+
+// sendFeedback is used to send the pending feedback message to the peer.
+func (c *PacketConn) sendFeedback(p *packetPeer, addr net.Addr) {
+	msg := p.r.Feedback()
+	if msg == nil {
+		return
+	}
+	packet := make([]byte, 1+4+len(msg))
+	packet[0] = packetFeedback
+	binary.BigEndian.PutUint32(packet[1:], p.rSession)
+	copy(packet[1+4:], msg)
+	// a lost message only slows down the recovery
+	_, _ = c.PacketConn.WriteTo(packet, addr)
+}
+
+func (c *PacketConn) processFeedback(packet []byte, addr net.Addr) error {
+	if len(packet) < 4 {
+		return ErrTruncated
+	}
+	// the feedback is only about the existed Writer context
+	p := c.lookup(addr)
+	if p == nil {
+		return errors.New("feedback from unknown peer")
+	}
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	// the feedback is about the previous Writer context
+	if binary.BigEndian.Uint32(packet) != p.wSession {
+		return errors.New("invalid feedback session")
+	}
+	return p.w.ProcessFeedback(packet[4:])
+}
+
+// WriteTo is used to write one frame, it is the same as WriteFrame.
+func (c *PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	err := c.WriteFrame(b, addr)
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// ReadFrom is used to read one frame and copy to b, if b is smaller than
+// the frame, it will return io.ErrShortBuffer and the frame is discarded.
+func (c *PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	frame, addr, err := c.ReadFrame()
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(b) < len(frame) {
+		return 0, addr, io.ErrShortBuffer
+	}
+	return copy(b, frame), addr, nil
+}
+
+// Dropped is used to get the number of the packets that are discarded,
+// include the packets that depend on a missed dictionary update.
+func (c *PacketConn) Dropped() uint64 {
+	return c.dropped.Load()
+}
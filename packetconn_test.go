@@ -0,0 +1,229 @@
+package cfh
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testNewPacketConnPair(t *testing.T, opts *PacketConnOptions) (*PacketConn, *PacketConn) {
+	conn1, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	conn2, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	client, err := NewPacketConn(conn1, opts)
+	require.NoError(t, err)
+	server, err := NewPacketConn(conn2, opts)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+	return client, server
+}
+
+// testPacketConnEcho is used to send the frames to server and read the
+// echo frames from it, the client will process the feedback messages.
+func testPacketConnEcho(t *testing.T, client, server *PacketConn, frames [][]byte) {
+	for _, frame := range frames {
+		err := client.WriteFrame(frame, server.LocalAddr())
+		require.NoError(t, err)
+
+		buf, addr, err := server.ReadFrame()
+		require.NoError(t, err)
+		require.Equal(t, frame, buf)
+		require.Equal(t, client.LocalAddr().String(), addr.String())
+		err = server.WriteFrame(buf, addr)
+		require.NoError(t, err)
+
+		buf, addr, err = client.ReadFrame()
+		require.NoError(t, err)
+		require.Equal(t, frame, buf)
+		require.Equal(t, server.LocalAddr().String(), addr.String())
+	}
+}
+
+func TestPacketConn(t *testing.T) {
+	frames := testGenerateFrames(t, 1024)
+
+	t.Run("common", func(t *testing.T) {
+		client, server := testNewPacketConnPair(t, nil)
+		testPacketConnEcho(t, client, server, frames)
+		require.Zero(t, client.Dropped())
+		require.Zero(t, server.Dropped())
+		require.Len(t, server.peers, 1)
+	})
+
+	for _, mode := range []FeedbackMode{
+		FeedbackOptimistic, FeedbackReliable,
+	} {
+		t.Run("feedback "+mode.String(), func(t *testing.T) {
+			opts := PacketConnOptions{
+				Options: &Options{
+					DictSize: 16,
+					Feedback: mode,
+					Checksum: true,
+				},
+			}
+			client, server := testNewPacketConnPair(t, &opts)
+			testPacketConnEcho(t, client, server, frames)
+			require.Zero(t, client.Dropped())
+			require.Zero(t, server.Dropped())
+		})
+	}
+
+	t.Run("multi peers", func(t *testing.T) {
+		client1, server := testNewPacketConnPair(t, nil)
+		client2, _ := testNewPacketConnPair(t, nil)
+		for i := 0; i < 64; i++ {
+			testPacketConnEcho(t, client1, server, frames[i:i+1])
+			testPacketConnEcho(t, client2, server, frames[i:i+1])
+		}
+		require.Len(t, server.peers, 2)
+	})
+
+	t.Run("Read and Write", func(t *testing.T) {
+		client, server := testNewPacketConnPair(t, nil)
+
+		frame := frames[0]
+		n, err := client.WriteTo(frame, server.LocalAddr())
+		require.NoError(t, err)
+		require.Equal(t, len(frame), n)
+		buf := make([]byte, 4096)
+		n, addr, err := server.ReadFrom(buf)
+		require.NoError(t, err)
+		require.Equal(t, frame, buf[:n])
+		require.Equal(t, client.LocalAddr().String(), addr.String())
+
+		_, err = client.WriteTo(frame, server.LocalAddr())
+		require.NoError(t, err)
+		n, _, err = server.ReadFrom(buf[:len(frame)-1])
+		require.Equal(t, io.ErrShortBuffer, err)
+		require.Zero(t, n)
+	})
+
+	t.Run("raw frame", func(t *testing.T) {
+		client, server := testNewPacketConnPair(t, nil)
+
+		frame := []byte{1, 2, 3, 4}
+		err := client.WriteFrame(frame, server.LocalAddr())
+		require.NoError(t, err)
+		buf, _, err := server.ReadFrame()
+		require.NoError(t, err)
+		require.Equal(t, frame, buf)
+		require.Empty(t, client.peers)
+	})
+
+	t.Run("idle peer", func(t *testing.T) {
+		opts := PacketConnOptions{
+			IdleTimeout: 10 * time.Millisecond,
+		}
+		client, server := testNewPacketConnPair(t, &opts)
+		testPacketConnEcho(t, client, server, frames[:16])
+		p := client.peers["udp/"+server.LocalAddr().String()]
+		require.NotNil(t, p)
+
+		time.Sleep(20 * time.Millisecond)
+
+		// the client will create a new context with a new session,
+		// then the server will reset the context about the client
+		testPacketConnEcho(t, client, server, frames[16:32])
+		require.NotEqual(t, p, client.peers["udp/"+server.LocalAddr().String()])
+		require.Zero(t, server.Dropped())
+	})
+
+	t.Run("max peers", func(t *testing.T) {
+		opts := PacketConnOptions{
+			MaxPeers: 2,
+		}
+		client1, server := testNewPacketConnPair(t, &opts)
+		client2, _ := testNewPacketConnPair(t, &opts)
+		client3, _ := testNewPacketConnPair(t, &opts)
+		frame := [][]byte{append(bytes.Clone(testIPv4TCPFrameHeader1), "payload"...)}
+		testPacketConnEcho(t, client1, server, frame)
+		testPacketConnEcho(t, client2, server, frame)
+		testPacketConnEcho(t, client1, server, frame)
+
+		// the context of client2 is the least recently used
+		testPacketConnEcho(t, client3, server, frame)
+		require.Len(t, server.peers, 2)
+		require.NotNil(t, server.peers["udp/"+client1.LocalAddr().String()])
+		require.Nil(t, server.peers["udp/"+client2.LocalAddr().String()])
+		require.NotNil(t, server.peers["udp/"+client3.LocalAddr().String()])
+	})
+
+	t.Run("feedback from unknown peer", func(t *testing.T) {
+		client, server := testNewPacketConnPair(t, nil)
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer func() { _ = conn.Close() }()
+
+		packet := []byte{packetFeedback, 0, 0, 0, 0, feedbackACK, 0, 0, 0}
+		_, err = conn.WriteTo(packet, server.LocalAddr())
+		require.NoError(t, err)
+		frame := [][]byte{append(bytes.Clone(testIPv4TCPFrameHeader1), "payload"...)}
+		testPacketConnEcho(t, client, server, frame)
+		require.Equal(t, uint64(1), server.Dropped())
+		require.Len(t, server.peers, 1)
+	})
+
+	t.Run("drop invalid packets", func(t *testing.T) {
+		client, server := testNewPacketConnPair(t, nil)
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer func() { _ = conn.Close() }()
+
+		for _, packet := range [][]byte{
+			{},
+			{3},
+			{packetFrameCompressed, 0, 0},
+			{packetFrameCompressed, 0, 0, 0, 0, 0xFF, 0xFF},
+			{packetFrameCompressed, 0, 0, 0, 0, 0, 4, 0, 1, cmdPrev, 0},
+			{packetFeedback, 0},
+			{packetFeedback, 0, 0, 0, 0, feedbackNACK, 0, 0, 0},
+		} {
+			_, err = conn.WriteTo(packet, server.LocalAddr())
+			require.NoError(t, err)
+		}
+		testPacketConnEcho(t, client, server, frames[:1])
+		require.Equal(t, uint64(7), server.Dropped())
+	})
+
+	t.Run("too large frame", func(t *testing.T) {
+		client, server := testNewPacketConnPair(t, nil)
+
+		frame := make([]byte, maxConnFrameSize+1)
+		err := client.WriteFrame(frame, server.LocalAddr())
+		require.EqualError(t, err, "frame is too large: 65536")
+
+		frame = make([]byte, ethernetIPv4TCPSize+maxConnFrameSize+1)
+		copy(frame, testIPv4TCPFrameHeader1)
+		err = client.WriteFrame(frame, server.LocalAddr())
+		require.EqualError(t, err, "payload is too large: 65536")
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer func() { _ = conn.Close() }()
+
+		opts := PacketConnOptions{IdleTimeout: -time.Second}
+		c, err := NewPacketConn(conn, &opts)
+		require.EqualError(t, err, "invalid idle timeout: -1s")
+		require.Nil(t, c)
+
+		opts = PacketConnOptions{MaxPeers: -1}
+		c, err = NewPacketConn(conn, &opts)
+		require.EqualError(t, err, "invalid maximum number of peers: -1")
+		require.Nil(t, c)
+
+		opts = PacketConnOptions{Options: &Options{DictSize: -1}}
+		c, err = NewPacketConn(conn, &opts)
+		require.EqualError(t, err, "dictionary size cannot less than 1")
+		require.Nil(t, c)
+	})
+}
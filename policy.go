@@ -0,0 +1,447 @@
+package cfh
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// EvictionPolicy is the policy about how to select the dictionary
+// that will be evicted when add new dictionary to a full table.
+type EvictionPolicy uint8
+
+// supported eviction policies.
+const (
+	// EvictMRU is the default policy, the new or reused dictionary
+	// will be moved to the top and the bottom one will be evicted.
+	EvictMRU EvictionPolicy = iota
+
+	// EvictLFU will evict the least frequently used dictionary,
+	// dictionaries will not be moved after added.
+	EvictLFU
+
+	// EvictARC is an adaptive replacement policy like ARC, it keeps
+	// the recently and frequently used dictionaries in two lists and
+	// adapt the size of them with the history of evicted flows.
+	EvictARC
+
+	// EvictPinned will pin the first added dictionaries, these will
+	// never be evicted, the other dictionaries are used with MRU.
+	EvictPinned
+)
+
+// String implements fmt.Stringer.
+func (p EvictionPolicy) String() string {
+	switch p {
+	case EvictMRU:
+		return "MRU"
+	case EvictLFU:
+		return "LFU"
+	case EvictARC:
+		return "ARC"
+	case EvictPinned:
+		return "Pinned"
+	default:
+		return fmt.Sprintf("EvictionPolicy(%d)", uint8(p))
+	}
+}
+
+// evictor is used to maintain the dictionary table, Writer and Reader
+// must use the same evictor with same operations, so the dictionary
+// tables of them will always be identical.
+type evictor interface {
+	// add is used to add new dictionary to the table, it will
+	// return the index of the table about the new dictionary.
+	add(dict [][]byte, data []byte) int
+
+	// access is used to update status when a dictionary is reused.
+	access(dict [][]byte, idx int)
+
+	// reset is used to clean the inner status.
+	reset()
+}
+
+func newEvictor(policy EvictionPolicy, size, pinned int) (evictor, error) {
+	switch policy {
+	case EvictMRU:
+		return new(mruEvictor), nil
+	case EvictLFU:
+		return newLFUEvictor(size), nil
+	case EvictARC:
+		return newARCEvictor(size), nil
+	case EvictPinned:
+		if pinned < 1 || pinned >= size {
+			return nil, fmt.Errorf("invalid pinned dictionary size: %d", pinned)
+		}
+		return &pinnedEvictor{pinned: pinned}, nil
+	default:
+		return nil, fmt.Errorf("invalid eviction policy: %d", policy)
+	}
+}
+
+// newStableEvictor is used to create an evictor that will not move the
+// dictionaries after added, the MRU and Pinned policies are replaced by
+// the lruEvictor. It is used in datagram mode, because a lost record
+// will not change the index of the other dictionaries.
+func newStableEvictor(policy EvictionPolicy, size, pinned int) (evictor, error) {
+	switch policy {
+	case EvictMRU:
+		return newLRUEvictor(size, 0), nil
+	case EvictPinned:
+		if pinned < 1 || pinned >= size {
+			return nil, fmt.Errorf("invalid pinned dictionary size: %d", pinned)
+		}
+		return newLRUEvictor(size, pinned), nil
+	default:
+		return newEvictor(policy, size, pinned)
+	}
+}
+
+// mruEvictor always put the latest used dictionary at the top.
+type mruEvictor struct{}
+
+func (mruEvictor) add(dict [][]byte, data []byte) int {
+	// remove the oldest dictionary
+	for i := len(dict) - 1; i > 0; i-- {
+		dict[i] = dict[i-1]
+	}
+	dict[0] = data
+	return 0
+}
+
+func (mruEvictor) access(dict [][]byte, idx int) {
+	moveDictionary(dict, 0, idx)
+}
+
+func (mruEvictor) reset() {}
+
+// pinnedEvictor will fill the pinned area first, then it is same as
+// the mruEvictor, but the top is the first index after pinned area.
+type pinnedEvictor struct {
+	pinned int
+}
+
+func (e *pinnedEvictor) add(dict [][]byte, data []byte) int {
+	for i := 0; i < e.pinned; i++ {
+		if dict[i] == nil {
+			dict[i] = data
+			return i
+		}
+	}
+	// remove the oldest unpinned dictionary
+	for i := len(dict) - 1; i > e.pinned; i-- {
+		dict[i] = dict[i-1]
+	}
+	dict[e.pinned] = data
+	return e.pinned
+}
+
+func (e *pinnedEvictor) access(dict [][]byte, idx int) {
+	if idx < e.pinned {
+		return
+	}
+	moveDictionary(dict, e.pinned, idx)
+}
+
+func (e *pinnedEvictor) reset() {}
+
+// lruEvictor will evict the least recently used dictionary, but the
+// dictionaries will not be moved, the pinned dictionaries are the
+// first added dictionaries and they will never be evicted.
+type lruEvictor struct {
+	pinned int
+	stamp  []uint64
+	clock  uint64
+}
+
+func newLRUEvictor(size, pinned int) *lruEvictor {
+	return &lruEvictor{
+		pinned: pinned,
+		stamp:  make([]uint64, size),
+	}
+}
+
+func (e *lruEvictor) add(dict [][]byte, data []byte) int {
+	idx := -1
+	for i := 0; i < len(dict); i++ {
+		if dict[i] == nil {
+			idx = i
+			break
+		}
+		if i < e.pinned {
+			continue
+		}
+		if idx == -1 || e.stamp[i] < e.stamp[idx] {
+			idx = i
+		}
+	}
+	dict[idx] = data
+	e.access(dict, idx)
+	return idx
+}
+
+func (e *lruEvictor) access(_ [][]byte, idx int) {
+	e.clock++
+	e.stamp[idx] = e.clock
+}
+
+func (e *lruEvictor) reset() {
+	for i := 0; i < len(e.stamp); i++ {
+		e.stamp[i] = 0
+	}
+	e.clock = 0
+}
+
+// maxFrequency is used to prevent the old heavy hitters
+// always stay in the table, when the frequency of any
+// dictionary reach it, all frequencies will be halved.
+const maxFrequency = 1 << 15
+
+// lfuEvictor will evict the least frequently used dictionary,
+// if the frequencies are equal, evict the least recently used.
+type lfuEvictor struct {
+	freq  []uint16
+	stamp []uint64
+	clock uint64
+}
+
+func newLFUEvictor(size int) *lfuEvictor {
+	return &lfuEvictor{
+		freq:  make([]uint16, size),
+		stamp: make([]uint64, size),
+	}
+}
+
+func (e *lfuEvictor) add(dict [][]byte, data []byte) int {
+	idx := 0
+	for i := 0; i < len(dict); i++ {
+		if dict[i] == nil {
+			idx = i
+			break
+		}
+		if e.freq[i] < e.freq[idx] {
+			idx = i
+			continue
+		}
+		if e.freq[i] == e.freq[idx] && e.stamp[i] < e.stamp[idx] {
+			idx = i
+		}
+	}
+	dict[idx] = data
+	e.freq[idx] = 1
+	e.tick(idx)
+	return idx
+}
+
+func (e *lfuEvictor) access(_ [][]byte, idx int) {
+	e.freq[idx]++
+	if e.freq[idx] >= maxFrequency {
+		for i := 0; i < len(e.freq); i++ {
+			e.freq[i] /= 2
+		}
+	}
+	e.tick(idx)
+}
+
+func (e *lfuEvictor) tick(idx int) {
+	e.clock++
+	e.stamp[idx] = e.clock
+}
+
+func (e *lfuEvictor) reset() {
+	for i := 0; i < len(e.freq); i++ {
+		e.freq[i] = 0
+		e.stamp[i] = 0
+	}
+	e.clock = 0
+}
+
+// dictionary list about arcEvictor.
+const (
+	arcNone = iota
+	arcT1
+	arcT2
+)
+
+// arcEvictor is an adaptive replacement policy like ARC.
+// T1 contains dictionaries that only be used once recently,
+// T2 contains dictionaries that be reused at least once.
+// B1 and B2 are the ghost lists that contain the keys of
+// dictionaries which are evicted from T1 and T2. The key
+// is calculated by dictionaryKey, so a flow evicted from
+// the table will hit the ghost lists when it comes back.
+type arcEvictor struct {
+	size  int
+	p     int // target size of T1
+	list  []uint8
+	stamp []uint64
+	clock uint64
+	b1    []uint64
+	b2    []uint64
+}
+
+func newARCEvictor(size int) *arcEvictor {
+	return &arcEvictor{
+		size:  size,
+		list:  make([]uint8, size),
+		stamp: make([]uint64, size),
+	}
+}
+
+func (e *arcEvictor) add(dict [][]byte, data []byte) int {
+	key := dictionaryKey(data)
+	var inB2 bool
+	switch {
+	case removeGhost(&e.b1, key):
+		e.p = minInt(e.size, e.p+maxInt(len(e.b2)/maxInt(len(e.b1), 1), 1))
+	case removeGhost(&e.b2, key):
+		e.p = maxInt(0, e.p-maxInt(len(e.b1)/maxInt(len(e.b2), 1), 1))
+		inB2 = true
+	default:
+		// keep the total size of ghost lists
+		t1, _ := e.count()
+		if t1+len(e.b1) >= e.size && len(e.b1) > 0 {
+			e.b1 = e.b1[1:]
+		} else if len(e.b1)+len(e.b2) >= e.size && len(e.b2) > 0 {
+			e.b2 = e.b2[1:]
+		}
+		idx := e.replace(dict, false)
+		dict[idx] = data
+		e.list[idx] = arcT1
+		e.tick(idx)
+		return idx
+	}
+	// the flow is evicted recently, it is frequently used
+	idx := e.replace(dict, inB2)
+	dict[idx] = data
+	e.list[idx] = arcT2
+	e.tick(idx)
+	return idx
+}
+
+// replace is used to select a slot for new dictionary.
+func (e *arcEvictor) replace(dict [][]byte, inB2 bool) int {
+	for i := 0; i < len(dict); i++ {
+		if dict[i] == nil {
+			return i
+		}
+	}
+	t1, t2 := e.count()
+	list := arcT2
+	if t1 > 0 && (t1 > e.p || (inB2 && t1 == e.p) || t2 == 0) {
+		list = arcT1
+	}
+	idx := -1
+	for i := 0; i < len(dict); i++ {
+		if e.list[i] != uint8(list) {
+			continue
+		}
+		if idx == -1 || e.stamp[i] < e.stamp[idx] {
+			idx = i
+		}
+	}
+	// move the key of the evicted dictionary to the ghost list
+	key := dictionaryKey(dict[idx])
+	if list == arcT1 {
+		e.b1 = appendGhost(e.b1, key, e.size)
+	} else {
+		e.b2 = appendGhost(e.b2, key, e.size)
+	}
+	return idx
+}
+
+func (e *arcEvictor) access(_ [][]byte, idx int) {
+	e.list[idx] = arcT2
+	e.tick(idx)
+}
+
+func (e *arcEvictor) count() (t1, t2 int) {
+	for i := 0; i < len(e.list); i++ {
+		switch e.list[i] {
+		case arcT1:
+			t1++
+		case arcT2:
+			t2++
+		}
+	}
+	return
+}
+
+func (e *arcEvictor) tick(idx int) {
+	e.clock++
+	e.stamp[idx] = e.clock
+}
+
+func (e *arcEvictor) reset() {
+	for i := 0; i < len(e.list); i++ {
+		e.list[i] = arcNone
+		e.stamp[i] = 0
+	}
+	e.clock = 0
+	e.p = 0
+	e.b1 = nil
+	e.b2 = nil
+}
+
+func removeGhost(list *[]uint64, key uint64) bool {
+	l := *list
+	for i := 0; i < len(l); i++ {
+		if l[i] != key {
+			continue
+		}
+		*list = append(l[:i], l[i+1:]...)
+		return true
+	}
+	return false
+}
+
+func appendGhost(list []uint64, key uint64, size int) []uint64 {
+	if len(list) >= size {
+		list = list[1:]
+	}
+	return append(list, key)
+}
+
+// moveDictionary is used to move the dictionary to the top.
+func moveDictionary(dict [][]byte, top, idx int) {
+	if idx == top {
+		return
+	}
+	d := dict[idx]
+	for i := idx; i > top; i-- {
+		dict[i] = dict[i-1]
+	}
+	dict[top] = d
+}
+
+// dictionaryKey is used to calculate the key about the flow of
+// the frame header, it uses the same fields with fast search.
+func dictionaryKey(data []byte) uint64 {
+	h := fnv.New64a()
+	switch len(data) {
+	case ethernetIPv4TCPSize, ethernetIPv4UDPSize:
+		const offset = 14 + (20 - 4*2)
+		_, _ = h.Write(data[:6+6])
+		_, _ = h.Write(data[offset : offset+4+4+2+2])
+	case ethernetIPv6TCPSize, ethernetIPv6UDPSize:
+		const offset = 14 + (40 - 16*2)
+		_, _ = h.Write(data[:6+6])
+		_, _ = h.Write(data[offset : offset+16+16+2+2])
+	default:
+		_, _ = h.Write(data)
+	}
+	return h.Sum64()
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
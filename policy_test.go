@@ -0,0 +1,203 @@
+package cfh
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testEvictionPolicies = []EvictionPolicy{
+	EvictMRU, EvictLFU, EvictARC, EvictPinned,
+}
+
+func TestEvictionPolicy(t *testing.T) {
+	headers := testGenerateFrameHeaders(t)
+
+	for _, policy := range testEvictionPolicies {
+		t.Run(policy.String(), func(t *testing.T) {
+			for _, size := range []int{2, 16, MaxDictionarySize} {
+				output := bytes.NewBuffer(make([]byte, 0, 4*1024*1024))
+
+				opts := Options{
+					DictSize:   size,
+					Policy:     policy,
+					PinnedSize: 1,
+				}
+				w, err := NewWriterWithOptions(output, &opts)
+				require.NoError(t, err)
+				for _, header := range headers {
+					n, err := w.Write(header)
+					require.NoError(t, err)
+					require.Equal(t, len(header), n)
+				}
+
+				r, err := NewReaderWithOptions(output, &opts)
+				require.NoError(t, err)
+				for _, header := range headers {
+					buf := make([]byte, len(header))
+					n, err := r.Read(buf)
+					require.NoError(t, err)
+					require.Equal(t, len(header), n)
+					require.Equal(t, header, buf)
+				}
+				require.Equal(t, w.dict, r.dict)
+			}
+		})
+	}
+
+	t.Run("invalid policy", func(t *testing.T) {
+		evict, err := newEvictor(EvictPinned+1, 16, 0)
+		require.EqualError(t, err, "invalid eviction policy: 4")
+		require.Nil(t, evict)
+	})
+
+	t.Run("invalid pinned size", func(t *testing.T) {
+		evict, err := newEvictor(EvictPinned, 16, 0)
+		require.EqualError(t, err, "invalid pinned dictionary size: 0")
+		require.Nil(t, evict)
+
+		evict, err = newEvictor(EvictPinned, 16, 16)
+		require.EqualError(t, err, "invalid pinned dictionary size: 16")
+		require.Nil(t, evict)
+	})
+}
+
+func TestEvictionPolicy_String(t *testing.T) {
+	for policy, str := range map[EvictionPolicy]string{
+		EvictMRU:        "MRU",
+		EvictLFU:        "LFU",
+		EvictARC:        "ARC",
+		EvictPinned:     "Pinned",
+		EvictPinned + 1: "EvictionPolicy(4)",
+	} {
+		require.Equal(t, str, policy.String())
+	}
+}
+
+func TestMRUEvictor(t *testing.T) {
+	dict := make([][]byte, 3)
+	e := new(mruEvictor)
+
+	require.Equal(t, 0, e.add(dict, []byte{1}))
+	require.Equal(t, 0, e.add(dict, []byte{2}))
+	require.Equal(t, 0, e.add(dict, []byte{3}))
+	require.Equal(t, [][]byte{{3}, {2}, {1}}, dict)
+
+	e.access(dict, 2)
+	require.Equal(t, [][]byte{{1}, {3}, {2}}, dict)
+
+	require.Equal(t, 0, e.add(dict, []byte{4}))
+	require.Equal(t, [][]byte{{4}, {1}, {3}}, dict)
+}
+
+func TestPinnedEvictor(t *testing.T) {
+	dict := make([][]byte, 4)
+	e := &pinnedEvictor{pinned: 2}
+
+	require.Equal(t, 0, e.add(dict, []byte{1}))
+	require.Equal(t, 1, e.add(dict, []byte{2}))
+	require.Equal(t, 2, e.add(dict, []byte{3}))
+	require.Equal(t, 2, e.add(dict, []byte{4}))
+	require.Equal(t, [][]byte{{1}, {2}, {4}, {3}}, dict)
+
+	// pinned dictionaries will not be moved
+	e.access(dict, 1)
+	require.Equal(t, [][]byte{{1}, {2}, {4}, {3}}, dict)
+
+	e.access(dict, 3)
+	require.Equal(t, [][]byte{{1}, {2}, {3}, {4}}, dict)
+
+	// a burst of new dictionaries will not evict pinned
+	for i := 0; i < 16; i++ {
+		require.Equal(t, 2, e.add(dict, []byte{byte(i + 10)}))
+	}
+	require.Equal(t, [][]byte{{1}, {2}, {25}, {24}}, dict)
+}
+
+func TestLFUEvictor(t *testing.T) {
+	dict := make([][]byte, 3)
+	e := newLFUEvictor(len(dict))
+
+	require.Equal(t, 0, e.add(dict, []byte{1}))
+	require.Equal(t, 1, e.add(dict, []byte{2}))
+	require.Equal(t, 2, e.add(dict, []byte{3}))
+
+	e.access(dict, 0)
+	e.access(dict, 0)
+	e.access(dict, 2)
+
+	// evict the least frequently used
+	require.Equal(t, 1, e.add(dict, []byte{4}))
+	require.Equal(t, [][]byte{{1}, {4}, {3}}, dict)
+
+	// evict the least recently used with same frequency
+	require.Equal(t, 1, e.add(dict, []byte{5}))
+	e.access(dict, 1)
+	require.Equal(t, 2, e.add(dict, []byte{6}))
+	require.Equal(t, [][]byte{{1}, {5}, {6}}, dict)
+
+	t.Run("halve frequency", func(t *testing.T) {
+		e.freq[2] = maxFrequency - 1
+		e.access(dict, 2)
+		require.Equal(t, uint16(maxFrequency/2), e.freq[2])
+		require.Equal(t, uint16(1), e.freq[0])
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		e.reset()
+		require.Equal(t, []uint16{0, 0, 0}, e.freq)
+		require.Zero(t, e.clock)
+	})
+}
+
+func TestARCEvictor(t *testing.T) {
+	dict := make([][]byte, 4)
+	e := newARCEvictor(len(dict))
+
+	require.Equal(t, 0, e.add(dict, []byte{1}))
+	require.Equal(t, 1, e.add(dict, []byte{2}))
+	require.Equal(t, 2, e.add(dict, []byte{3}))
+	require.Equal(t, 3, e.add(dict, []byte{4}))
+	e.access(dict, 0)
+	e.access(dict, 1)
+
+	// evict the dictionary from T1 to B1
+	require.Equal(t, 2, e.add(dict, []byte{5}))
+	require.Equal(t, [][]byte{{1}, {2}, {5}, {4}}, dict)
+	require.Equal(t, []uint64{dictionaryKey([]byte{3})}, e.b1)
+
+	// a burst of one-off flows will not evict frequently used
+	for i := 0; i < 16; i++ {
+		e.add(dict, []byte{byte(i + 10)})
+	}
+	require.Equal(t, []byte{1}, dict[0])
+	require.Equal(t, []byte{2}, dict[1])
+	require.Len(t, e.b1, 2)
+
+	// the evicted flow comes back, it will be added to T2
+	idx := e.add(dict, []byte{23})
+	require.Equal(t, 1, e.p)
+	require.Equal(t, uint8(arcT2), e.list[idx])
+
+	t.Run("reset", func(t *testing.T) {
+		e.reset()
+		require.Equal(t, []uint8{arcNone, arcNone, arcNone, arcNone}, e.list)
+		require.Zero(t, e.p)
+		require.Nil(t, e.b1)
+		require.Nil(t, e.b2)
+	})
+}
+
+func TestDictionaryKey(t *testing.T) {
+	for _, headers := range [][][]byte{
+		{testIPv4TCPFrameHeader1, testIPv4TCPFrameHeader3},
+		{testIPv4UDPFrameHeader1, testIPv4UDPFrameHeader3},
+		{testIPv6TCPFrameHeader1, testIPv6TCPFrameHeader3},
+		{testIPv6UDPFrameHeader1, testIPv6UDPFrameHeader3},
+	} {
+		require.Equal(t, dictionaryKey(headers[0]), dictionaryKey(headers[1]))
+	}
+	require.NotEqual(t, dictionaryKey(testIPv4TCPFrameHeader1), dictionaryKey(testIPv4TCPFrameHeader5))
+	require.NotEqual(t, dictionaryKey([]byte{1, 2}), dictionaryKey([]byte{1, 3}))
+}
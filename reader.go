@@ -1,22 +1,57 @@
 package cfh
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 )
 
-// Reader is used to decompress frame header data.
+// byteReader is the interface that the Reader reads the stream with.
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// Reader is used to decompress frame header data. In stream mode, if the
+// under reader does not implement io.ByteReader, the Reader will wrap it
+// with an internal buffer, so it may read more data than necessary from it.
 type Reader struct {
-	r    io.Reader
-	dict [][]byte
-	buf  []byte
-	chg  []byte
-	data []byte
-	last bytes.Buffer
-	rem  bytes.Buffer
-	err  error
+	r      io.Reader
+	br     byteReader    // the under reader in stream mode
+	bufr   *bufio.Reader // the internal buffer if r is not io.ByteReader
+	size   int
+	opts   *Options
+	init   bool
+	dict   [][]byte
+	evict  evictor
+	dgram  *datagram
+	crc    bool
+	buf    []byte
+	chg    []byte
+	data   []byte
+	last   bytes.Buffer
+	rem    bytes.Buffer
+	hdr    []byte
+	stats  statsCounter
+	tracer Tracer
+	err    error
+
+	// the dictionary index and the number of
+	// changed data about the current record
+	index   int
+	changes int
+
+	// the number of bytes read from the stream about the current record
+	consumed uint64
+
+	// only be used by Inspector
+	insp *inspection
+
+	// wait the reset record after checkpoint is mismatched
+	desync bool
 }
 
 // NewReader is used to create a new compressor with 256 dictionaries.
@@ -29,22 +64,64 @@ func NewReader(r io.Reader) *Reader {
 }
 
 // NewReaderWithSize is used to create a new decompressor with custom number of dictionaries.
+// If the stream contains parameters, the Reader will use them.
 func NewReaderWithSize(r io.Reader, size int) (*Reader, error) {
-	if size < 1 {
-		return nil, errors.New("dictionary size cannot less than 1")
-	}
-	if size > MaxDictionarySize {
-		return nil, errors.New("dictionary size cannot greater than 256")
+	err := checkDictSize(size)
+	if err != nil {
+		return nil, err
 	}
 	return &Reader{
-		r:    r,
-		dict: make([][]byte, size),
-		buf:  make([]byte, 1),
-		chg:  make([]byte, 256),
+		r:     r,
+		size:  size,
+		dict:  make([][]byte, size),
+		evict: new(mruEvictor),
+		buf:   make([]byte, paramsSize+dictHashSize),
+		chg:   make([]byte, 2*MaxFrameHeaderSize),
 	}, nil
 }
 
+// NewReaderWithOptions is used to create a new decompressor with options,
+// the stream must start with parameters that are the same as the options.
+func NewReaderWithOptions(r io.Reader, opts *Options) (*Reader, error) {
+	opts, err := opts.apply()
+	if err != nil {
+		return nil, err
+	}
+	evict, err := opts.newEvictor()
+	if err != nil {
+		return nil, err
+	}
+	dict := make([][]byte, opts.DictSize)
+	seedDictionaries(dict, evict, opts.Dictionaries)
+	reader := Reader{
+		r:     r,
+		size:  opts.DictSize,
+		opts:  opts,
+		dict:  dict,
+		evict: evict,
+		crc:   opts.Checksum,
+		buf:   make([]byte, paramsSize+dictHashSize),
+		chg:   make([]byte, 2*MaxFrameHeaderSize),
+	}
+	if opts.Datagram {
+		reader.dgram = newDatagram(opts)
+		reader.buf = make([]byte, maxDatagramSize+1)
+	}
+	return &reader, nil
+}
+
+// NewReaderWithDictionaries is used to create a new decompressor with
+// pre-shared dictionaries, they must be the same as the Writer.
+func NewReaderWithDictionaries(r io.Reader, dicts [][]byte) (*Reader, error) {
+	opts := Options{
+		Dictionaries: dicts,
+	}
+	return NewReaderWithOptions(r, &opts)
+}
+
 // Read is used to decompress frame header data from the under r and copy to b.
+// If b is smaller than the frame header, the remaining data will be returned
+// by the next Read call, use ReadHeader to read one frame header per call.
 func (r *Reader) Read(b []byte) (int, error) {
 	l := len(b)
 	if l < 1 {
@@ -53,113 +130,345 @@ func (r *Reader) Read(b []byte) (int, error) {
 	if l > MaxFrameHeaderSize {
 		return 0, errors.New("read with too large buffer")
 	}
-	if r.err != nil {
-		return 0, r.err
+	// read remaining data
+	if r.rem.Len() != 0 {
+		return r.rem.Read(b)
 	}
-	n, err := r.read(b)
+	err := r.next()
 	if err != nil {
-		r.err = err
+		return 0, err
 	}
-	return n, err
+	n := copy(b, r.data)
+	if n < len(r.data) {
+		r.rem.Write(r.data[n:])
+	}
+	return n, nil
 }
 
-func (r *Reader) read(b []byte) (int, error) {
-	// read remaining data
+// ReadHeader is used to decompress one frame header from the under r.
+// The returned slice is only valid until the next call to the Reader,
+// the caller must copy it if it is retained. If the previous Read call
+// is not consumed the whole frame header, the remaining data of it will
+// be returned.
+func (r *Reader) ReadHeader() ([]byte, error) {
+	if r.hdr == nil {
+		r.hdr = make([]byte, MaxFrameHeaderSize)
+	}
 	if r.rem.Len() != 0 {
-		return r.rem.Read(b)
+		n, _ := r.rem.Read(r.hdr)
+		return r.hdr[:n], nil
+	}
+	err := r.next()
+	if err != nil {
+		return nil, err
+	}
+	n := copy(r.hdr, r.data)
+	return r.hdr[:n], nil
+}
+
+// NextHeaderSize is used to get the size of the data that will be returned
+// by the next ReadHeader call, it will decompress the next frame header in
+// advance if it is not read, so it can be used to allocate the buffer.
+func (r *Reader) NextHeaderSize() (int, error) {
+	if r.rem.Len() != 0 {
+		return r.rem.Len(), nil
+	}
+	err := r.next()
+	if err != nil {
+		return 0, err
+	}
+	r.rem.Write(r.data)
+	return r.rem.Len(), nil
+}
+
+// next is used to decompress the next frame header to r.data.
+func (r *Reader) next() error {
+	if r.err != nil {
+		return r.err
+	}
+	if r.dgram != nil {
+		return r.readDatagram()
+	}
+	if r.br == nil {
+		r.initByteReader()
+	}
+	err := r.read()
+	r.stats.bytesIn.Add(r.consumed)
+	r.consumed = 0
+	var ce *CorruptionError
+	switch {
+	case err == nil, err == ErrCheckpointMismatch:
+	case errors.As(err, &ce):
+		// the dictionary table is corrupted, skip records until reset
+		r.desync = true
+	default:
+		r.err = err
+	}
+	return err
+}
+
+// initByteReader is used to select the reader in stream mode, if the under
+// reader does not implement io.ByteReader, it will be wrapped by bufio.Reader,
+// so the Reader can perform bulk reads instead of one byte per read.
+func (r *Reader) initByteReader() {
+	br, ok := r.r.(byteReader)
+	if ok {
+		r.br = br
+		return
+	}
+	if r.bufr == nil {
+		r.bufr = bufio.NewReader(r.r)
+	} else {
+		r.bufr.Reset(r.r)
+	}
+	r.br = r.bufr
+}
+
+// readFull is used to read the rest of record in stream mode, the read
+// bytes are counted in the statistics after the record is processed.
+func (r *Reader) readFull(b []byte) error {
+	err := readFull(r.br, b)
+	if err != nil {
+		return err
+	}
+	r.consumed += uint64(len(b))
+	return nil
+}
+
+// readByte is like readFull, but it only reads one byte.
+func (r *Reader) readByte() (byte, error) {
+	b, err := r.br.ReadByte()
+	if err == io.EOF {
+		return 0, fmt.Errorf("%w: %w", ErrTruncated, io.ErrUnexpectedEOF)
+	}
+	if err != nil {
+		return 0, err
 	}
-	// read command
-	_, err := io.ReadFull(r.r, r.buf)
+	r.consumed++
+	return b, nil
+}
+
+func (r *Reader) read() error {
+	cmd, err := r.readCommand()
 	if err != nil {
-		return 0, fmt.Errorf("failed to read decompress command: %s", err)
+		return err
 	}
-	switch cmd := r.buf[0]; cmd {
+	r.index = -1
+	r.changes = 0
+	switch cmd {
 	case cmdAddDict:
 		err = r.addDictionary()
 	case cmdData:
-		err = r.readChangedData()
+		err = r.readChangedData(false)
 	case cmdLast:
 		r.reuseLastData()
 	case cmdPrev:
 		err = r.reusePreviousData()
+	case cmdSwap:
+		err = r.readChangedData(true)
 	default:
-		return 0, fmt.Errorf("invalid decompress command: %d", cmd)
+		return fmt.Errorf("%w: %d", ErrInvalidCommand, cmd)
 	}
 	if err != nil {
-		return 0, err
+		return err
 	}
-	n := copy(b, r.data)
-	if n < len(r.data) {
-		r.rem.Write(r.data[n:])
+	err = r.readChecksum()
+	if err != nil {
+		return err
 	}
-	return n, nil
+	r.account(cmd, r.index, r.changes)
+	return nil
+}
+
+// account is used to update the statistics after the record is read.
+func (r *Reader) account(cmd byte, idx, changes int) {
+	r.stats.count(cmd, changes)
+	r.stats.bytesOut.Add(uint64(len(r.data)))
+	if r.tracer != nil {
+		r.tracer.OnDecode(Command(cmd), idx)
+	}
+}
+
+// readChecksum is used to read the checksum of record and verify it.
+func (r *Reader) readChecksum() error {
+	if !r.crc {
+		return nil
+	}
+	err := r.readFull(r.buf[:checksumSize])
+	if err != nil {
+		return fmt.Errorf("failed to read checksum: %w", err)
+	}
+	return verifyChecksum(binary.BigEndian.Uint16(r.buf), r.data)
+}
+
+// readCommand is used to read the command of the next record,
+// the stream parameters and reset records are processed here.
+func (r *Reader) readCommand() (byte, error) {
+	for {
+		cmd, err := r.br.ReadByte()
+		if err == io.EOF {
+			// the stream is ended at the record boundary
+			return 0, io.EOF
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read decompress command: %w", err)
+		}
+		r.consumed++
+		if r.opts != nil && !r.init && cmd != cmdParams {
+			return 0, errors.New("stream parameters are not found")
+		}
+		switch cmd {
+		case cmdParams:
+			err = r.readParams()
+			if err != nil {
+				return 0, err
+			}
+			r.inspectControl(cmd)
+		case cmdReset:
+			r.resetTable()
+			r.desync = false
+			r.inspectControl(cmd)
+		case cmdCheckpoint:
+			if r.desync {
+				err = r.skipRecord(cmd)
+			} else {
+				err = r.readCheckpoint()
+			}
+			if err != nil {
+				return 0, err
+			}
+			r.inspectControl(cmd)
+		default:
+			if !r.desync {
+				return cmd, nil
+			}
+			err = r.skipRecord(cmd)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+}
+
+func (r *Reader) readParams() error {
+	err := r.readFull(r.buf[:paramsSize-1])
+	if err != nil {
+		return fmt.Errorf("failed to read stream parameters: %w", err)
+	}
+	params, err := decodeParams(r.buf[:paramsSize-1])
+	if err != nil {
+		return err
+	}
+	if params.flags&flagDictionaries != 0 {
+		err = r.readFull(params.dictHash[:])
+		if err != nil {
+			return fmt.Errorf("failed to read pre-shared dictionaries hash: %w", err)
+		}
+	}
+	if r.opts != nil {
+		expected := r.opts.params()
+		if params.dictHash != expected.dictHash {
+			return errors.New("pre-shared dictionaries are mismatched")
+		}
+		if *params != *expected {
+			return errors.New("stream parameters are mismatched with options")
+		}
+		r.init = true
+		return nil
+	}
+	if params.flags&flagDictionaries != 0 {
+		return errors.New("pre-shared dictionaries are not provided")
+	}
+	evict, err := newEvictor(params.policy, params.dictSize, params.pinned)
+	if err != nil {
+		return err
+	}
+	r.dict = make([][]byte, params.dictSize)
+	r.evict = evict
+	r.crc = params.flags&flagChecksum != 0
+	r.last.Reset()
+	return nil
+}
+
+// resetTable is used to clean all dictionaries and the last frame
+// header, then add the pre-shared dictionaries to the table again.
+func (r *Reader) resetTable() {
+	for i := 0; i < len(r.dict); i++ {
+		r.dict[i] = nil
+	}
+	r.evict.reset()
+	if r.opts != nil {
+		seedDictionaries(r.dict, r.evict, r.opts.Dictionaries)
+	}
+	r.last.Reset()
 }
 
 func (r *Reader) addDictionary() error {
 	// read dictionary size
-	_, err := io.ReadFull(r.r, r.buf)
+	b, err := r.readByte()
 	if err != nil {
-		return fmt.Errorf("failed to read dictionary size: %s", err)
-	}
-	size := int(r.buf[0])
-	if size < 1 {
-		return errors.New("read empty dictionary")
+		return fmt.Errorf("failed to read dictionary size: %w", err)
 	}
+	size := decodeDictSize(b)
 	// read dictionary data
 	dict := make([]byte, size)
-	_, err = io.ReadFull(r.r, dict)
+	err = r.readFull(dict)
 	if err != nil {
-		return fmt.Errorf("failed to read dictionary data: %s", err)
-	}
-	// remove the oldest dictionary
-	for i := len(r.dict) - 1; i > 0; i-- {
-		r.dict[i] = r.dict[i-1]
+		return fmt.Errorf("failed to read dictionary data: %w", err)
 	}
-	r.dict[0] = dict
+	idx := addToTable(r.dict, r.evict, dict, &r.stats, r.tracer)
 	// update status
+	r.index = idx
 	r.data = dict
 	r.updateLast(dict)
 	return nil
 }
 
-func (r *Reader) readChangedData() error {
+// readChangedData is used to read the changed data and update the
+// dictionary, if swap is true, the dictionary is swapped before update.
+func (r *Reader) readChangedData(swap bool) error {
 	// read dictionary index
-	_, err := io.ReadFull(r.r, r.buf)
+	b, err := r.readByte()
 	if err != nil {
-		return fmt.Errorf("failed to read dictionary index: %s", err)
+		return fmt.Errorf("failed to read dictionary index: %w", err)
 	}
-	idx := int(r.buf[0])
-	dict := r.dict[idx]
-	if len(dict) < 1 {
-		return fmt.Errorf("read invalid dictionary index: %d", idx)
+	idx := int(b)
+	if idx >= len(r.dict) || len(r.dict[idx]) < 1 {
+		return fmt.Errorf("%w: %d", ErrInvalidDictIndex, idx)
 	}
+	dict := r.dict[idx]
 	// read the number of changed data
-	_, err = io.ReadFull(r.r, r.buf)
+	b, err = r.readByte()
 	if err != nil {
-		return fmt.Errorf("failed to read the number of changed data: %s", err)
+		return fmt.Errorf("failed to read the number of changed data: %w", err)
 	}
 	// read changed data
-	size := int(r.buf[0] * 2)
+	size := int(b) * 2
 	if size > len(dict)*2 {
 		return fmt.Errorf("read invalid changed data size: %d", size/2)
 	}
-	_, err = io.ReadFull(r.r, r.chg[:size])
+	err = r.readFull(r.chg[:size])
 	if err != nil {
-		return fmt.Errorf("failed to read changed data: %s", err)
+		return fmt.Errorf("failed to read changed data: %w", err)
 	}
-	// extract data and update dictionary
-	var dataIdx byte
-	maxIdx := byte(len(dict) - 1)
+	// check all indexes before update dictionary
 	for i := 0; i < size; i += 2 {
-		dataIdx = r.chg[i]
-		if dataIdx > maxIdx {
-			return fmt.Errorf("invalid changed data index: %d", dataIdx)
+		if int(r.chg[i]) >= len(dict) {
+			return fmt.Errorf("invalid changed data index: %d", r.chg[i])
 		}
-		dict[dataIdx] = r.chg[i+1]
+	}
+	if swap && !swapDirection(dict) {
+		return fmt.Errorf("invalid swapped dictionary size: %d", len(dict))
+	}
+	r.inspectChanges(dict, r.chg[:size])
+	for i := 0; i < size; i += 2 {
+		dict[r.chg[i]] = r.chg[i+1]
 	}
 	// update status
+	r.index = idx
+	r.changes = size / 2
 	r.data = dict
-	r.moveDictionary(idx)
+	r.evict.access(r.dict, idx)
 	r.updateLast(dict)
 	return nil
 }
@@ -170,34 +479,49 @@ func (r *Reader) reuseLastData() {
 
 func (r *Reader) reusePreviousData() error {
 	// read dictionary index
-	_, err := io.ReadFull(r.r, r.buf)
+	b, err := r.readByte()
 	if err != nil {
-		return fmt.Errorf("failed to read dictionary index: %s", err)
+		return fmt.Errorf("failed to read dictionary index: %w", err)
 	}
-	idx := int(r.buf[0])
-	dict := r.dict[idx]
-	if len(dict) < 1 {
-		return fmt.Errorf("read invalid dictionary index: %d", idx)
+	idx := int(b)
+	if idx >= len(r.dict) || len(r.dict[idx]) < 1 {
+		return fmt.Errorf("%w: %d", ErrInvalidDictIndex, idx)
 	}
+	dict := r.dict[idx]
 	// update status
+	r.index = idx
 	r.data = dict
-	r.moveDictionary(idx)
+	r.evict.access(r.dict, idx)
 	r.updateLast(dict)
 	return nil
 }
 
-func (r *Reader) moveDictionary(idx int) {
-	if idx == 0 {
-		return
-	}
-	dict := r.dict[idx]
-	for i := idx; i > 0; i-- {
-		r.dict[i] = r.dict[i-1]
-	}
-	r.dict[0] = dict
-}
-
 func (r *Reader) updateLast(data []byte) {
 	r.last.Reset()
 	r.last.Write(data)
 }
+
+// Reset is used to discard the status and sticky error of Reader and
+// switch to read from the new r, the dictionary table is reset to the
+// initial status, it is used to reuse the Reader on a new transport.
+func (r *Reader) Reset(rd io.Reader) {
+	r.r = rd
+	r.br = nil
+	// discard the parameters in the previous stream
+	if r.opts == nil {
+		if len(r.dict) != r.size {
+			r.dict = make([][]byte, r.size)
+		}
+		r.evict = new(mruEvictor)
+		r.crc = false
+	}
+	r.resetTable()
+	if r.dgram != nil {
+		r.dgram.reset()
+	}
+	r.init = false
+	r.desync = false
+	r.data = nil
+	r.rem.Reset()
+	r.err = nil
+}
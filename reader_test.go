@@ -1,6 +1,7 @@
 package cfh
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/rand"
 	"errors"
@@ -50,6 +51,108 @@ func TestNewReader(t *testing.T) {
 	})
 }
 
+func TestNewReaderWithOptions(t *testing.T) {
+	t.Run("common", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+
+		r, err := NewReaderWithOptions(output, nil)
+		require.NoError(t, err)
+		require.NotNil(t, r)
+	})
+
+	t.Run("invalid pinned size", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+
+		opts := Options{
+			DictSize:   16,
+			Policy:     EvictPinned,
+			PinnedSize: 16,
+		}
+		r, err := NewReaderWithOptions(output, &opts)
+		require.EqualError(t, err, "invalid pinned dictionary size: 16")
+		require.Nil(t, r)
+	})
+}
+
+func TestNewReaderWithDictionaries(t *testing.T) {
+	dicts := [][]byte{testIPv4TCPFrameHeader1, testIPv6UDPFrameHeader1}
+
+	t.Run("common", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 4096))
+
+		w, err := NewWriterWithDictionaries(output, dicts)
+		require.NoError(t, err)
+		for _, header := range testFrameHeaders {
+			_, err = w.Write(header)
+			require.NoError(t, err)
+		}
+
+		r, err := NewReaderWithDictionaries(output, dicts)
+		require.NoError(t, err)
+		for _, header := range testFrameHeaders {
+			buf := make([]byte, len(header))
+			n, err := r.Read(buf)
+			require.NoError(t, err)
+			require.Equal(t, len(header), n)
+			require.Equal(t, header, buf)
+		}
+	})
+
+	t.Run("invalid pre-shared dictionary size", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+
+		r, err := NewReaderWithDictionaries(output, [][]byte{nil})
+		require.EqualError(t, err, "invalid pre-shared dictionary size: 0")
+		require.Nil(t, r)
+	})
+
+	t.Run("pre-shared dictionaries are mismatched", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+
+		w, err := NewWriterWithDictionaries(output, dicts)
+		require.NoError(t, err)
+		_, err = w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+
+		r, err := NewReaderWithDictionaries(output, dicts[:1])
+		require.NoError(t, err)
+
+		buf := make([]byte, len(testIPv4TCPFrameHeader1))
+		n, err := r.Read(buf)
+		require.EqualError(t, err, "pre-shared dictionaries are mismatched")
+		require.Zero(t, n)
+	})
+
+	t.Run("pre-shared dictionaries are not provided", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+
+		w, err := NewWriterWithDictionaries(output, dicts)
+		require.NoError(t, err)
+		_, err = w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+
+		r := NewReader(output)
+
+		buf := make([]byte, len(testIPv4TCPFrameHeader1))
+		n, err := r.Read(buf)
+		require.EqualError(t, err, "pre-shared dictionaries are not provided")
+		require.Zero(t, n)
+	})
+
+	t.Run("failed to read pre-shared dictionaries hash", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+		output.Write([]byte{cmdParams, 15, byte(EvictMRU), 0, flagDictionaries})
+
+		r := NewReader(output)
+
+		buf := make([]byte, MaxFrameHeaderSize)
+		n, err := r.Read(buf)
+		errStr := "failed to read pre-shared dictionaries hash: record is truncated: unexpected EOF"
+		require.EqualError(t, err, errStr)
+		require.Zero(t, n)
+	})
+}
+
 func TestReader_Read(t *testing.T) {
 	t.Run("read remaining data", func(t *testing.T) {
 		output := bytes.NewBuffer(make([]byte, 0, 128))
@@ -102,11 +205,11 @@ func TestReader_Read(t *testing.T) {
 
 		buf := make([]byte, MaxFrameHeaderSize)
 		n, err := r.Read(buf)
-		require.EqualError(t, err, "failed to read decompress command: EOF")
+		require.EqualError(t, err, "EOF")
 		require.Zero(t, n)
 
 		n, err = r.Read(buf)
-		require.EqualError(t, err, "failed to read decompress command: EOF")
+		require.EqualError(t, err, "EOF")
 		require.Zero(t, n)
 	})
 
@@ -117,7 +220,7 @@ func TestReader_Read(t *testing.T) {
 
 		buf := make([]byte, MaxFrameHeaderSize)
 		n, err := r.Read(buf)
-		require.EqualError(t, err, "failed to read decompress command: EOF")
+		require.EqualError(t, err, "EOF")
 		require.Zero(t, n)
 	})
 
@@ -133,6 +236,123 @@ func TestReader_Read(t *testing.T) {
 		require.Zero(t, n)
 	})
 
+	t.Run("stream parameters", func(t *testing.T) {
+		opts := Options{
+			DictSize: 16,
+			Policy:   EvictLFU,
+		}
+
+		t.Run("use parameters in stream", func(t *testing.T) {
+			output := bytes.NewBuffer(make([]byte, 0, 64))
+
+			w, err := NewWriterWithOptions(output, &opts)
+			require.NoError(t, err)
+			_, err = w.Write(testIPv4TCPFrameHeader1)
+			require.NoError(t, err)
+
+			r := NewReader(output)
+
+			buf := make([]byte, len(testIPv4TCPFrameHeader1))
+			n, err := r.Read(buf)
+			require.NoError(t, err)
+			require.Equal(t, len(buf), n)
+			require.Equal(t, testIPv4TCPFrameHeader1, buf)
+			require.Len(t, r.dict, 16)
+			require.IsType(t, &lfuEvictor{}, r.evict)
+		})
+
+		t.Run("stream parameters are not found", func(t *testing.T) {
+			output := bytes.NewBuffer(make([]byte, 0, 64))
+			output.WriteByte(cmdLast)
+
+			r, err := NewReaderWithOptions(output, &opts)
+			require.NoError(t, err)
+
+			buf := make([]byte, MaxFrameHeaderSize)
+			n, err := r.Read(buf)
+			require.EqualError(t, err, "stream parameters are not found")
+			require.Zero(t, n)
+		})
+
+		t.Run("failed to read stream parameters", func(t *testing.T) {
+			output := bytes.NewBuffer(make([]byte, 0, 64))
+			output.WriteByte(cmdParams)
+
+			r := NewReader(output)
+
+			buf := make([]byte, MaxFrameHeaderSize)
+			n, err := r.Read(buf)
+			errStr := "failed to read stream parameters: record is truncated: unexpected EOF"
+			require.EqualError(t, err, errStr)
+			require.Zero(t, n)
+		})
+
+		t.Run("invalid stream parameters flags", func(t *testing.T) {
+			output := bytes.NewBuffer(make([]byte, 0, 64))
+			output.Write([]byte{cmdParams, 15, byte(EvictLFU), 0, 0xFF})
+
+			r := NewReader(output)
+
+			buf := make([]byte, MaxFrameHeaderSize)
+			n, err := r.Read(buf)
+			require.EqualError(t, err, "invalid stream parameters flags: 255")
+			require.Zero(t, n)
+		})
+
+		t.Run("invalid eviction policy", func(t *testing.T) {
+			output := bytes.NewBuffer(make([]byte, 0, 64))
+			output.Write([]byte{cmdParams, 15, 123, 0, 0})
+
+			r := NewReader(output)
+
+			buf := make([]byte, MaxFrameHeaderSize)
+			n, err := r.Read(buf)
+			require.EqualError(t, err, "invalid eviction policy: 123")
+			require.Zero(t, n)
+		})
+
+		t.Run("mismatched with options", func(t *testing.T) {
+			output := bytes.NewBuffer(make([]byte, 0, 64))
+			output.Write([]byte{cmdParams, 15, byte(EvictARC), 0, 0})
+
+			r, err := NewReaderWithOptions(output, &opts)
+			require.NoError(t, err)
+
+			buf := make([]byte, MaxFrameHeaderSize)
+			n, err := r.Read(buf)
+			require.EqualError(t, err, "stream parameters are mismatched with options")
+			require.Zero(t, n)
+		})
+
+		t.Run("failed to read the first command", func(t *testing.T) {
+			output := bytes.NewBuffer(make([]byte, 0, 64))
+			output.Write([]byte{cmdParams, 15, byte(EvictLFU), 0, 0})
+
+			r, err := NewReaderWithOptions(output, &opts)
+			require.NoError(t, err)
+
+			buf := make([]byte, MaxFrameHeaderSize)
+			n, err := r.Read(buf)
+			require.EqualError(t, err, "EOF")
+			require.Zero(t, n)
+		})
+	})
+
+	t.Run("reset dictionaries", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+		output.WriteByte(cmdReset)
+		output.WriteByte(cmdPrev)
+		output.WriteByte(0) // dictionary index
+
+		r := NewReader(output)
+		r.dict[0] = []byte{1, 2, 3, 4}
+
+		buf := make([]byte, MaxFrameHeaderSize)
+		n, err := r.Read(buf)
+		require.EqualError(t, err, "invalid dictionary index: 0")
+		require.Zero(t, n)
+	})
+
 	t.Run("add dictionary", func(t *testing.T) {
 		t.Run("failed to read dictionary size", func(t *testing.T) {
 			output := bytes.NewBuffer(make([]byte, 0, 64))
@@ -142,21 +362,23 @@ func TestReader_Read(t *testing.T) {
 
 			buf := make([]byte, MaxFrameHeaderSize)
 			n, err := r.Read(buf)
-			require.EqualError(t, err, "failed to read dictionary size: EOF")
+			errStr := "failed to read dictionary size: record is truncated: unexpected EOF"
+			require.EqualError(t, err, errStr)
 			require.Zero(t, n)
 		})
 
-		t.Run("read empty dictionary", func(t *testing.T) {
-			output := bytes.NewBuffer(make([]byte, 0, 64))
+		t.Run("read dictionary with maximum size", func(t *testing.T) {
+			output := bytes.NewBuffer(make([]byte, 0, 512))
 			output.WriteByte(cmdAddDict)
 			output.WriteByte(0) // dictionary size
+			output.Write(bytes.Repeat([]byte{1}, MaxFrameHeaderSize))
 
 			r := NewReader(output)
 
 			buf := make([]byte, MaxFrameHeaderSize)
 			n, err := r.Read(buf)
-			require.EqualError(t, err, "read empty dictionary")
-			require.Zero(t, n)
+			require.NoError(t, err)
+			require.Equal(t, MaxFrameHeaderSize, n)
 		})
 
 		t.Run("failed to read dictionary data", func(t *testing.T) {
@@ -168,7 +390,8 @@ func TestReader_Read(t *testing.T) {
 
 			buf := make([]byte, MaxFrameHeaderSize)
 			n, err := r.Read(buf)
-			require.EqualError(t, err, "failed to read dictionary data: EOF")
+			errStr := "failed to read dictionary data: record is truncated: unexpected EOF"
+			require.EqualError(t, err, errStr)
 			require.Zero(t, n)
 		})
 	})
@@ -182,7 +405,8 @@ func TestReader_Read(t *testing.T) {
 
 			buf := make([]byte, MaxFrameHeaderSize)
 			n, err := r.Read(buf)
-			require.EqualError(t, err, "failed to read dictionary index: EOF")
+			errStr := "failed to read dictionary index: record is truncated: unexpected EOF"
+			require.EqualError(t, err, errStr)
 			require.Zero(t, n)
 		})
 
@@ -195,7 +419,7 @@ func TestReader_Read(t *testing.T) {
 
 			buf := make([]byte, MaxFrameHeaderSize)
 			n, err := r.Read(buf)
-			require.EqualError(t, err, "read invalid dictionary index: 0")
+			require.EqualError(t, err, "invalid dictionary index: 0")
 			require.Zero(t, n)
 		})
 
@@ -209,7 +433,8 @@ func TestReader_Read(t *testing.T) {
 
 			buf := make([]byte, MaxFrameHeaderSize)
 			n, err := r.Read(buf)
-			require.EqualError(t, err, "failed to read the number of changed data: EOF")
+			errStr := "failed to read the number of changed data: record is truncated: unexpected EOF"
+			require.EqualError(t, err, errStr)
 			require.Zero(t, n)
 		})
 
@@ -239,7 +464,8 @@ func TestReader_Read(t *testing.T) {
 
 			buf := make([]byte, MaxFrameHeaderSize)
 			n, err := r.Read(buf)
-			require.EqualError(t, err, "failed to read changed data: EOF")
+			errStr := "failed to read changed data: record is truncated: unexpected EOF"
+			require.EqualError(t, err, errStr)
 			require.Zero(t, n)
 		})
 
@@ -270,7 +496,8 @@ func TestReader_Read(t *testing.T) {
 
 			buf := make([]byte, MaxFrameHeaderSize)
 			n, err := r.Read(buf)
-			require.EqualError(t, err, "failed to read dictionary index: EOF")
+			errStr := "failed to read dictionary index: record is truncated: unexpected EOF"
+			require.EqualError(t, err, errStr)
 			require.Zero(t, n)
 		})
 
@@ -283,9 +510,330 @@ func TestReader_Read(t *testing.T) {
 
 			buf := make([]byte, MaxFrameHeaderSize)
 			n, err := r.Read(buf)
-			require.EqualError(t, err, "read invalid dictionary index: 0")
+			require.EqualError(t, err, "invalid dictionary index: 0")
+			require.Zero(t, n)
+		})
+	})
+}
+
+func TestReader_ReadHeader(t *testing.T) {
+	headers := testGenerateFrameHeaders(t)
+
+	t.Run("common", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 4*1024*1024))
+		w := NewWriter(output)
+		for _, header := range headers {
+			_, err := w.Write(header)
+			require.NoError(t, err)
+		}
+
+		r := NewReader(output)
+		for _, header := range headers {
+			size, err := r.NextHeaderSize()
+			require.NoError(t, err)
+			require.Equal(t, len(header), size)
+
+			buf, err := r.ReadHeader()
+			require.NoError(t, err)
+			require.Equal(t, header, buf)
+		}
+
+		buf, err := r.ReadHeader()
+		require.Equal(t, io.EOF, err)
+		require.Nil(t, buf)
+		size, err := r.NextHeaderSize()
+		require.Equal(t, io.EOF, err)
+		require.Zero(t, size)
+	})
+
+	t.Run("datagram", func(t *testing.T) {
+		opts := Options{Datagram: true}
+		w, r, _ := testNewDatagramPair(t, &opts)
+		for _, header := range headers[:1024] {
+			_, err := w.Write(header)
+			require.NoError(t, err)
+
+			buf, err := r.ReadHeader()
+			require.NoError(t, err)
+			require.Equal(t, header, buf)
+		}
+	})
+
+	t.Run("with Read", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+		w := NewWriter(output)
+		_, err := w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+		_, err = w.Write(testIPv4TCPFrameHeader2)
+		require.NoError(t, err)
+
+		r := NewReader(output)
+		buf := make([]byte, 16)
+		_, err = r.Read(buf)
+		require.NoError(t, err)
+
+		// read the remaining data of the first frame header
+		size, err := r.NextHeaderSize()
+		require.NoError(t, err)
+		require.Equal(t, len(testIPv4TCPFrameHeader1)-16, size)
+		header, err := r.ReadHeader()
+		require.NoError(t, err)
+		require.Equal(t, testIPv4TCPFrameHeader1[16:], header)
+
+		// the frame header is decompressed in advance
+		size, err = r.NextHeaderSize()
+		require.NoError(t, err)
+		require.Equal(t, len(testIPv4TCPFrameHeader2), size)
+		buf = make([]byte, len(testIPv4TCPFrameHeader2))
+		n, err := r.Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, size, n)
+		require.Equal(t, testIPv4TCPFrameHeader2, buf)
+	})
+
+	t.Run("invalid record", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+		output.WriteByte(0)
+
+		r := NewReader(output)
+		size, err := r.NextHeaderSize()
+		require.EqualError(t, err, "invalid decompress command: 0")
+		require.Zero(t, size)
+
+		// the error is sticky
+		buf, err := r.ReadHeader()
+		require.EqualError(t, err, "invalid decompress command: 0")
+		require.Nil(t, buf)
+	})
+}
+
+// testUnbufferedReader is used to simulate the net.Conn or file that not
+// implement io.ByteReader, it will count the Read calls and read the data
+// repeatedly if loop is true.
+type testUnbufferedReader struct {
+	data  []byte
+	off   int
+	loop  bool
+	reads int
+}
+
+func (r *testUnbufferedReader) Read(b []byte) (int, error) {
+	r.reads++
+	if r.off == len(r.data) {
+		if !r.loop {
+			return 0, io.EOF
+		}
+		r.off = 0
+	}
+	n := copy(b, r.data[r.off:])
+	r.off += n
+	return n, nil
+}
+
+func TestReader_Buffer(t *testing.T) {
+	headers := testGenerateFrameHeaders(t)
+	output := bytes.NewBuffer(make([]byte, 0, 4*1024*1024))
+	w := NewWriter(output)
+	for _, header := range headers {
+		_, err := w.Write(header)
+		require.NoError(t, err)
+	}
+	stream := output.Bytes()
+
+	t.Run("unbuffered", func(t *testing.T) {
+		ur := &testUnbufferedReader{data: stream}
+		r := NewReader(ur)
+		for _, header := range headers {
+			buf, err := r.ReadHeader()
+			require.NoError(t, err)
+			require.Equal(t, header, buf)
+		}
+		_, err := r.ReadHeader()
+		require.Equal(t, io.EOF, err)
+		require.Less(t, ur.reads, len(headers)/16)
+		require.NotNil(t, r.bufr)
+
+		// the internal buffer is reused
+		bufr := r.bufr
+		ur = &testUnbufferedReader{data: stream}
+		r.Reset(ur)
+		buf, err := r.ReadHeader()
+		require.NoError(t, err)
+		require.Equal(t, headers[0], buf)
+		require.Equal(t, bufr, r.bufr)
+	})
+
+	t.Run("io.ByteReader", func(t *testing.T) {
+		br := bufio.NewReader(bytes.NewReader(stream))
+		r := NewReader(br)
+		for _, header := range headers {
+			buf, err := r.ReadHeader()
+			require.NoError(t, err)
+			require.Equal(t, header, buf)
+		}
+		require.Equal(t, br, r.br)
+		require.Nil(t, r.bufr)
+	})
+}
+
+func TestReader_Reset(t *testing.T) {
+	t.Run("common", func(t *testing.T) {
+		dicts := [][]byte{testIPv4TCPFrameHeader1}
+		output := bytes.NewBuffer(make([]byte, 0, 4096))
+
+		w, err := NewWriterWithDictionaries(output, dicts)
+		require.NoError(t, err)
+		for _, header := range testFrameHeaders {
+			_, err = w.Write(header)
+			require.NoError(t, err)
+		}
+		stream := output.Bytes()
+
+		r, err := NewReaderWithDictionaries(bytes.NewReader(nil), dicts)
+		require.NoError(t, err)
+		buf := make([]byte, 16)
+		_, err = r.Read(buf)
+		require.Error(t, err)
+
+		for i := 0; i < 2; i++ {
+			r.Reset(bytes.NewReader(stream))
+			require.Equal(t, testIPv4TCPFrameHeader1, r.dict[0])
+			require.Nil(t, r.dict[1])
+			for _, header := range testFrameHeaders {
+				buf := make([]byte, len(header))
+				_, err = r.Read(buf)
+				require.NoError(t, err)
+				require.Equal(t, header, buf)
+			}
+		}
+	})
+
+	t.Run("discard stream parameters", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+		opts := Options{
+			DictSize: 16,
+			Policy:   EvictLFU,
+		}
+		w, err := NewWriterWithOptions(output, &opts)
+		require.NoError(t, err)
+		_, err = w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+
+		r, err := NewReaderWithSize(output, 32)
+		require.NoError(t, err)
+		buf := make([]byte, len(testIPv4TCPFrameHeader1))
+		_, err = r.Read(buf)
+		require.NoError(t, err)
+		require.Len(t, r.dict, 16)
+
+		r.Reset(output)
+		require.Len(t, r.dict, 32)
+		require.IsType(t, new(mruEvictor), r.evict)
+	})
+
+	t.Run("remaining data", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+		w := NewWriter(output)
+		_, err := w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+		_, err = w.Write(testIPv4TCPFrameHeader3)
+		require.NoError(t, err)
+
+		r := NewReader(output)
+		buf := make([]byte, 16)
+		_, err = r.Read(buf)
+		require.NoError(t, err)
+
+		r.Reset(output)
+		buf = make([]byte, len(testIPv4TCPFrameHeader3))
+		_, err = r.Read(buf)
+		require.EqualError(t, err, "invalid dictionary index: 0")
+	})
+}
+
+func TestReader_Errors(t *testing.T) {
+	buf := make([]byte, MaxFrameHeaderSize)
+
+	t.Run("clean EOF", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+		w := NewWriter(output)
+		_, err := w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+
+		r := NewReader(output)
+		_, err = r.Read(buf)
+		require.NoError(t, err)
+		n, err := r.Read(buf)
+		require.Equal(t, io.EOF, err)
+		require.Zero(t, n)
+	})
+
+	for _, item := range []*struct {
+		name   string
+		stream []byte
+	}{
+		{"dictionary size", []byte{cmdAddDict}},
+		{"dictionary data", []byte{cmdAddDict, 4, 1}},
+		{"stream parameters", []byte{cmdParams, 0}},
+		{"dictionary index", []byte{cmdPrev}},
+	} {
+		t.Run("truncated "+item.name, func(t *testing.T) {
+			r := NewReader(bytes.NewReader(item.stream))
+			n, err := r.Read(buf)
+			require.ErrorIs(t, err, ErrTruncated)
+			require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+			require.False(t, errors.Is(err, io.EOF))
 			require.Zero(t, n)
 		})
+	}
+
+	t.Run("invalid command", func(t *testing.T) {
+		r := NewReader(bytes.NewReader([]byte{0}))
+		_, err := r.Read(buf)
+		require.ErrorIs(t, err, ErrInvalidCommand)
+
+		queue := &testPacketQueue{packets: [][]byte{{0, 1, 0}}}
+		r, err = NewReaderWithOptions(queue, &Options{Datagram: true})
+		require.NoError(t, err)
+		_, err = r.Read(buf)
+		require.ErrorIs(t, err, ErrInvalidCommand)
+	})
+
+	t.Run("invalid dictionary index", func(t *testing.T) {
+		r := NewReader(bytes.NewReader([]byte{cmdPrev, 0}))
+		_, err := r.Read(buf)
+		require.ErrorIs(t, err, ErrInvalidDictIndex)
+
+		queue := &testPacketQueue{packets: [][]byte{{0, 1, cmdAddDict, 4, 1, 1}}}
+		opts := Options{DictSize: 4, Datagram: true}
+		r, err = NewReaderWithOptions(queue, &opts)
+		require.NoError(t, err)
+		_, err = r.Read(buf)
+		require.ErrorIs(t, err, ErrInvalidDictIndex)
+	})
+
+	t.Run("truncated datagram", func(t *testing.T) {
+		queue := &testPacketQueue{packets: [][]byte{
+			{0, 1},
+			{0, 1, cmdAddDict, 0},
+			{0, 1, cmdData, 0},
+		}}
+		r, err := NewReaderWithOptions(queue, &Options{Datagram: true})
+		require.NoError(t, err)
+		for i := 0; i < 3; i++ {
+			_, err = r.Read(buf)
+			require.ErrorIs(t, err, ErrTruncated)
+		}
+	})
+
+	t.Run("transport error", func(t *testing.T) {
+		pr, pw := io.Pipe()
+		err := pw.CloseWithError(io.ErrClosedPipe)
+		require.NoError(t, err)
+
+		r := NewReader(pr)
+		_, err = r.Read(buf)
+		require.ErrorIs(t, err, io.ErrClosedPipe)
 	})
 }
 
@@ -304,6 +852,61 @@ func TestReader_Fuzz(t *testing.T) {
 	}
 }
 
+func FuzzReader(f *testing.F) {
+	output := bytes.NewBuffer(make([]byte, 0, 1024))
+	opts := Options{
+		Policy:             EvictLFU,
+		Checksum:           true,
+		CheckpointInterval: 2,
+	}
+	w, err := NewWriterWithOptions(output, &opts)
+	require.NoError(f, err)
+	for _, header := range testFrameHeaders {
+		_, err = w.Write(header)
+		require.NoError(f, err)
+	}
+	f.Add(output.Bytes())
+	f.Add([]byte{cmdAddDict, 4, 1, 2, 3, 4, cmdData, 0, 1, 0, 5, cmdPrev, 0, cmdLast})
+	f.Add([]byte{0, 1, cmdAddDict, 0, 2, 1, 2})
+	f.Add([]byte{cmdData, 255, 255})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		buf := make([]byte, MaxFrameHeaderSize)
+		readAll := func(r *Reader) {
+			// each successful Read will consume at least one byte
+			for i := 0; i <= len(data); i++ {
+				_, err := r.Read(buf)
+				if err != nil {
+					return
+				}
+			}
+		}
+		readAll(NewReader(bytes.NewReader(data)))
+		r, err := NewReaderWithSize(bytes.NewReader(data), 4)
+		require.NoError(t, err)
+		readAll(r)
+		r, err = NewReaderWithOptions(bytes.NewReader(data), &opts)
+		require.NoError(t, err)
+		readAll(r)
+
+		// read the data as datagram
+		for _, mode := range []FeedbackMode{FeedbackNone, FeedbackReliable} {
+			queue := &testPacketQueue{packets: [][]byte{data, data}}
+			opts := Options{
+				DictSize: 4,
+				Datagram: true,
+				Feedback: mode,
+				Checksum: mode == FeedbackReliable,
+			}
+			r, err = NewReaderWithOptions(queue, &opts)
+			require.NoError(t, err)
+			for i := 0; i < 2; i++ {
+				_, _ = r.Read(buf)
+			}
+		}
+	})
+}
+
 func BenchmarkReader_Read(b *testing.B) {
 	b.Run("Ethernet IPv4 TCP", benchmarkReaderReadEthernetIPv4TCP)
 	b.Run("Ethernet IPv4 UDP", benchmarkReaderReadEthernetIPv4UDP)
@@ -821,3 +1424,49 @@ func benchmarkReaderReadCustomFrameHeader(b *testing.B) {
 		b.StopTimer()
 	})
 }
+
+func BenchmarkReader_Unbuffered(b *testing.B) {
+	output := bytes.NewBuffer(make([]byte, 0, 1024*1024))
+	w := NewWriter(output)
+
+	header := make([]byte, len(testIPv4TCPFrameHeader1))
+	copy(header, testIPv4TCPFrameHeader1)
+
+	var err error
+	for i := 0; i < 1024; i++ {
+		_, err = w.Write(header)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		// data that change frequently
+		header[17] = byte(i) + 1 // IPv4 Total Length [byte 2]
+		header[19] = byte(i) + 2 // IPv4 ID [byte 2]
+		header[25] = byte(i) + 3 // IPv4 checksum [byte 2]
+
+		header[41] = byte(i) + 4 // TCP Sequence [byte 4]
+		header[45] = byte(i) + 5 // TCP acknowledgment [byte 4]
+		header[50] = byte(i) + 6 // TCP checksum [byte 1]
+		header[51] = byte(i) + 7 // TCP checksum [byte 2]
+	}
+
+	reader := &testUnbufferedReader{
+		data: output.Bytes(),
+		loop: true,
+	}
+
+	r := NewReader(reader)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, err = r.ReadHeader()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.StopTimer()
+	b.ReportMetric(float64(reader.reads)/float64(b.N), "reads/op")
+}
@@ -0,0 +1,157 @@
+package cfh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ShardedWriter is used to compress frame headers with multiple Writers
+// concurrently, the frame headers are distributed to the shards by the
+// hash of the flow, each shard writes its own ordered sub-stream to the
+// under writer of it. The frame headers of the same flow are always in
+// the same shard, and the frame headers that are not preferred be
+// compressed are written to the first shard. It is safe for concurrent
+// use by multiple goroutines.
+type ShardedWriter struct {
+	shards []*writerShard
+}
+
+type writerShard struct {
+	mu sync.Mutex
+	w  *Writer
+}
+
+// NewShardedWriter is used to create a new ShardedWriter, each under
+// writer is the sub-stream of a shard, the options are used to create
+// the Writer of each shard.
+func NewShardedWriter(ws []io.Writer, opts *Options) (*ShardedWriter, error) {
+	err := checkShards(len(ws))
+	if err != nil {
+		return nil, err
+	}
+	sw := ShardedWriter{
+		shards: make([]*writerShard, len(ws)),
+	}
+	for i := 0; i < len(ws); i++ {
+		w, err := NewWriterWithOptions(ws[i], opts)
+		if err != nil {
+			return nil, err
+		}
+		sw.shards[i] = &writerShard{w: w}
+	}
+	return &sw, nil
+}
+
+// checkShards is used to check the number of shards.
+func checkShards(shards int) error {
+	if shards < 1 || shards > MaxDictionarySize {
+		return fmt.Errorf("invalid number of shards: %d", shards)
+	}
+	return nil
+}
+
+// Write is used to compress frame header data with the Writer of the
+// shard about the flow of it.
+func (sw *ShardedWriter) Write(b []byte) (int, error) {
+	shard := sw.shards[sw.Shard(b)]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	return shard.w.Write(b)
+}
+
+// Shard is used to get the index of the shard that the frame header
+// will be written to.
+func (sw *ShardedWriter) Shard(b []byte) int {
+	return selectContext(b, len(sw.shards))
+}
+
+// Shards is used to get the number of shards.
+func (sw *ShardedWriter) Shards() int {
+	return len(sw.shards)
+}
+
+// Flush is used to flush the pending records of all shards in buffered
+// mode, the errors about all failed shards are joined.
+func (sw *ShardedWriter) Flush() error {
+	var errs []error
+	for _, shard := range sw.shards {
+		shard.mu.Lock()
+		err := shard.w.Flush()
+		shard.mu.Unlock()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ShardedReader is used to decompress the sub-streams from ShardedWriter,
+// each shard has its own Reader, the different shards can be read by
+// multiple goroutines concurrently.
+type ShardedReader struct {
+	shards []*readerShard
+}
+
+type readerShard struct {
+	mu sync.Mutex
+	r  *Reader
+}
+
+// NewShardedReader is used to create a new ShardedReader, the number of
+// under readers and the options must be the same as the ShardedWriter.
+func NewShardedReader(rs []io.Reader, opts *Options) (*ShardedReader, error) {
+	err := checkShards(len(rs))
+	if err != nil {
+		return nil, err
+	}
+	sr := ShardedReader{
+		shards: make([]*readerShard, len(rs)),
+	}
+	for i := 0; i < len(rs); i++ {
+		r, err := NewReaderWithOptions(rs[i], opts)
+		if err != nil {
+			return nil, err
+		}
+		sr.shards[i] = &readerShard{r: r}
+	}
+	return &sr, nil
+}
+
+// Read is used to decompress frame header data from the sub-stream of
+// the shard and copy to b.
+func (sr *ShardedReader) Read(shard int, b []byte) (int, error) {
+	s, err := sr.shard(shard)
+	if err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.r.Read(b)
+}
+
+// ReadHeader is used to decompress one frame header from the sub-stream
+// of the shard, the returned slice is only valid until the next call
+// about the same shard.
+func (sr *ShardedReader) ReadHeader(shard int) ([]byte, error) {
+	s, err := sr.shard(shard)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.r.ReadHeader()
+}
+
+func (sr *ShardedReader) shard(shard int) (*readerShard, error) {
+	if shard < 0 || shard >= len(sr.shards) {
+		return nil, fmt.Errorf("invalid shard index: %d", shard)
+	}
+	return sr.shards[shard], nil
+}
+
+// Shards is used to get the number of shards.
+func (sr *ShardedReader) Shards() int {
+	return len(sr.shards)
+}
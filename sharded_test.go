@@ -0,0 +1,204 @@
+package cfh
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardedWriter(t *testing.T) {
+	const (
+		shards  = 4
+		flows   = 16
+		records = 1024
+	)
+
+	t.Run("concurrent", func(t *testing.T) {
+		outputs := make([]*bytes.Buffer, shards)
+		ws := make([]io.Writer, shards)
+		for i := 0; i < shards; i++ {
+			outputs[i] = bytes.NewBuffer(make([]byte, 0, 1024*1024))
+			ws[i] = outputs[i]
+		}
+		opts := Options{Checksum: true}
+		sw, err := NewShardedWriter(ws, &opts)
+		require.NoError(t, err)
+		require.Equal(t, shards, sw.Shards())
+
+		// each goroutine writes a flow, the IPv4 ID is the sequence
+		wg := sync.WaitGroup{}
+		for i := 0; i < flows; i++ {
+			wg.Add(1)
+			go func(flow int) {
+				defer wg.Done()
+				header := bytes.Clone(testIPv4TCPFrameHeader1)
+				binary.BigEndian.PutUint32(header[26:], uint32(flow))
+				for seq := 0; seq < records; seq++ {
+					binary.BigEndian.PutUint16(header[18:], uint16(seq))
+					_, err := sw.Write(header)
+					require.NoError(t, err)
+				}
+			}(i)
+		}
+		wg.Wait()
+		err = sw.Flush()
+		require.NoError(t, err)
+
+		rs := make([]io.Reader, shards)
+		for i := 0; i < shards; i++ {
+			rs[i] = outputs[i]
+		}
+		sr, err := NewShardedReader(rs, &opts)
+		require.NoError(t, err)
+		require.Equal(t, shards, sr.Shards())
+
+		// read all shards concurrently, the order of each flow is kept
+		var (
+			mu    sync.Mutex
+			total int
+		)
+		for i := 0; i < shards; i++ {
+			wg.Add(1)
+			go func(shard int) {
+				defer wg.Done()
+				next := make(map[uint32]uint16)
+				var count int
+				for {
+					header, err := sr.ReadHeader(shard)
+					if err == io.EOF {
+						break
+					}
+					require.NoError(t, err)
+					flow := binary.BigEndian.Uint32(header[26:])
+					require.Equal(t, next[flow], binary.BigEndian.Uint16(header[18:]))
+					require.Equal(t, shard, sw.Shard(header))
+					next[flow]++
+					count++
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				total += count
+			}(i)
+		}
+		wg.Wait()
+		require.Equal(t, flows*records, total)
+	})
+
+	t.Run("buffered mode", func(t *testing.T) {
+		outputs := make([]*bytes.Buffer, shards)
+		ws := make([]io.Writer, shards)
+		for i := 0; i < shards; i++ {
+			outputs[i] = new(bytes.Buffer)
+			ws[i] = outputs[i]
+		}
+		opts := Options{BufferSize: 4096}
+		sw, err := NewShardedWriter(ws, &opts)
+		require.NoError(t, err)
+		_, err = sw.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+		shard := sw.Shard(testIPv4TCPFrameHeader1)
+		require.Zero(t, outputs[shard].Len())
+
+		err = sw.Flush()
+		require.NoError(t, err)
+		require.NotZero(t, outputs[shard].Len())
+
+		rs := make([]io.Reader, shards)
+		for i := 0; i < shards; i++ {
+			rs[i] = outputs[i]
+		}
+		sr, err := NewShardedReader(rs, &opts)
+		require.NoError(t, err)
+		buf := make([]byte, len(testIPv4TCPFrameHeader1))
+		_, err = sr.Read(shard, buf)
+		require.NoError(t, err)
+		require.Equal(t, testIPv4TCPFrameHeader1, buf)
+	})
+
+	t.Run("failed to flush", func(t *testing.T) {
+		pr, pw := io.Pipe()
+		err := pr.Close()
+		require.NoError(t, err)
+		opts := Options{BufferSize: 4096}
+		sw, err := NewShardedWriter([]io.Writer{pw, pw}, &opts)
+		require.NoError(t, err)
+		for _, header := range testFrameHeaders {
+			_, err = sw.Write(header)
+			require.NoError(t, err)
+		}
+		err = sw.Flush()
+		require.ErrorIs(t, err, io.ErrClosedPipe)
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		sw, err := NewShardedWriter(nil, nil)
+		require.EqualError(t, err, "invalid number of shards: 0")
+		require.Nil(t, sw)
+
+		opts := Options{DictSize: -1}
+		sw, err = NewShardedWriter([]io.Writer{io.Discard}, &opts)
+		require.EqualError(t, err, "dictionary size cannot less than 1")
+		require.Nil(t, sw)
+	})
+}
+
+func TestShardedReader(t *testing.T) {
+	t.Run("invalid shard index", func(t *testing.T) {
+		sr, err := NewShardedReader([]io.Reader{bytes.NewReader(nil)}, nil)
+		require.NoError(t, err)
+
+		n, err := sr.Read(1, make([]byte, 16))
+		require.EqualError(t, err, "invalid shard index: 1")
+		require.Zero(t, n)
+		buf, err := sr.ReadHeader(-1)
+		require.EqualError(t, err, "invalid shard index: -1")
+		require.Nil(t, buf)
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		rs := make([]io.Reader, MaxDictionarySize+1)
+		sr, err := NewShardedReader(rs, nil)
+		require.EqualError(t, err, "invalid number of shards: 257")
+		require.Nil(t, sr)
+
+		opts := Options{DictSize: -1}
+		sr, err = NewShardedReader([]io.Reader{bytes.NewReader(nil)}, &opts)
+		require.EqualError(t, err, "dictionary size cannot less than 1")
+		require.Nil(t, sr)
+	})
+}
+
+func BenchmarkShardedWriter_Write(b *testing.B) {
+	ws := make([]io.Writer, 4)
+	for i := 0; i < len(ws); i++ {
+		ws[i] = io.Discard
+	}
+	sw, err := NewShardedWriter(ws, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		header := bytes.Clone(testIPv4TCPFrameHeader1)
+		var i int
+		for pb.Next() {
+			i++
+			binary.BigEndian.PutUint16(header[26:], uint16(i%64))
+			header[19] = byte(i)
+			_, err := sw.Write(header)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+
+	b.StopTimer()
+}
@@ -0,0 +1,602 @@
+package cfh
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// snapshot kinds, they are used to prevent restore
+// the Writer with the snapshot about the Reader.
+const (
+	snapshotWriter = 'W'
+	snapshotReader = 'R'
+)
+
+const snapshotVersion = 1
+
+// MarshalBinary implements encoding.BinaryMarshaler, it is used to capture
+// the status of Writer include the dictionary table, the last frame header,
+// the checkpoint status and the sticky error, the registered searchers are
+// not included. The pending records in buffered mode are not included, call
+// Flush before. The options about the stream parameters, datagram mode
+// and checkpoint are recorded to check the restored Writer.
+func (w *Writer) MarshalBinary() ([]byte, error) {
+	enc := newSnapshotEncoder(snapshotWriter)
+	enc.writeBool(w.dgram != nil)
+	enc.writeBool(w.crc)
+	enc.writeEvictor(w.evict, len(w.dict))
+	enc.writeOptions(writerSnapshotOptions(w.opts))
+	enc.writeBytes(w.params)
+	enc.writeDictionaries(w.dict)
+	enc.writeBytes(w.last.Bytes())
+	enc.writeUint64(uint64(w.records))
+	var elapsed time.Duration
+	if !w.checked.IsZero() {
+		elapsed = time.Since(w.checked)
+	}
+	enc.writeUint64(uint64(elapsed))
+	enc.writeError(w.err)
+	enc.writeDatagram(w.dgram)
+	return enc.buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, it is used to
+// restore the status of Writer from the snapshot, then the Writer can
+// continue to compress frame header and write to the current under w.
+// The registered searchers are not changed, the restored sticky error
+// only contains the original error message. It will return error if the
+// options of Writer are mismatched with the options in the snapshot.
+func (w *Writer) UnmarshalBinary(data []byte) error {
+	dec, err := newSnapshotDecoder(data, snapshotWriter)
+	if err != nil {
+		return err
+	}
+	stable := dec.readBool()
+	crc := dec.readBool()
+	dict, evict := dec.readEvictor(stable)
+	opts := dec.readOptions()
+	params := dec.readBytes()
+	dec.readDictionaries(dict)
+	dec.checkEvictor(dict, evict)
+	last := dec.readBytes()
+	records := int(dec.readUint64() % (1 << 31))
+	elapsed := time.Duration(dec.readUint64() % (1 << 63))
+	sticky := dec.readError()
+	dgram := dec.readDatagram(stable, len(dict))
+	err = dec.close()
+	if err != nil {
+		return err
+	}
+	err = opts.check(writerSnapshotOptions(w.opts))
+	if err != nil {
+		return err
+	}
+	w.dict = dict
+	w.evict = evict
+	w.params = params
+	w.dgram = dgram
+	w.crc = crc
+	w.updateLast(last)
+	w.records = records
+	w.checked = time.Now().Add(-elapsed)
+	w.err = sticky
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, it is used to capture
+// the status of Reader include the dictionary table, the last frame header,
+// the remaining data that not be read and the sticky error. The data in
+// the internal buffer of Reader is not included, so if the snapshot will
+// be used with the same under reader, it should implement io.ByteReader.
+// The options about the stream parameters and datagram mode are recorded
+// to check the restored Reader.
+func (r *Reader) MarshalBinary() ([]byte, error) {
+	enc := newSnapshotEncoder(snapshotReader)
+	enc.writeBool(r.dgram != nil)
+	enc.writeBool(r.crc)
+	enc.writeEvictor(r.evict, len(r.dict))
+	enc.writeOptions(readerSnapshotOptions(r.opts))
+	enc.writeBool(r.init)
+	enc.writeBool(r.desync)
+	enc.writeDictionaries(r.dict)
+	enc.writeBytes(r.last.Bytes())
+	enc.writeBytes(r.rem.Bytes())
+	enc.writeError(r.err)
+	enc.writeDatagram(r.dgram)
+	return enc.buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, it is used to
+// restore the status of Reader from the snapshot, then the Reader can
+// continue to decompress frame header from the current under r. The
+// options of Reader are not changed, the restored sticky error only
+// contains the original error message. It will return error if the
+// options of Reader are mismatched with the options in the snapshot.
+func (r *Reader) UnmarshalBinary(data []byte) error {
+	dec, err := newSnapshotDecoder(data, snapshotReader)
+	if err != nil {
+		return err
+	}
+	stable := dec.readBool()
+	crc := dec.readBool()
+	dict, evict := dec.readEvictor(stable)
+	opts := dec.readOptions()
+	init := dec.readBool()
+	desync := dec.readBool()
+	dec.readDictionaries(dict)
+	dec.checkEvictor(dict, evict)
+	last := dec.readBytes()
+	rem := dec.readBytes()
+	sticky := dec.readError()
+	dgram := dec.readDatagram(stable, len(dict))
+	err = dec.close()
+	if err != nil {
+		return err
+	}
+	err = opts.check(readerSnapshotOptions(r.opts))
+	if err != nil {
+		return err
+	}
+	if dgram != nil && len(r.buf) <= maxDatagramSize {
+		r.buf = make([]byte, maxDatagramSize+1)
+	}
+	r.dict = dict
+	r.evict = evict
+	r.dgram = dgram
+	r.crc = crc
+	r.init = init
+	r.desync = desync
+	r.data = nil
+	r.updateLast(last)
+	r.rem.Reset()
+	r.rem.Write(rem)
+	r.err = sticky
+	return nil
+}
+
+// snapshotOptions contains the options that are not restored from the
+// snapshot, they are used by Reset, Resync and the following records,
+// so the restored Writer or Reader must be created with the same ones.
+// The stream parameters are only compared if created with options.
+type snapshotOptions struct {
+	options  bool
+	params   streamParams
+	datagram bool
+	interval int
+	period   time.Duration
+}
+
+func writerSnapshotOptions(opts *Options) *snapshotOptions {
+	o := readerSnapshotOptions(opts)
+	if opts != nil {
+		o.interval = opts.CheckpointInterval
+		o.period = opts.CheckpointPeriod
+	}
+	return o
+}
+
+// readerSnapshotOptions not contains the checkpoint options, they are
+// only used by Writer.
+func readerSnapshotOptions(opts *Options) *snapshotOptions {
+	o := snapshotOptions{}
+	if opts != nil {
+		o.options = true
+		o.params = *opts.params()
+		o.datagram = opts.Datagram
+	}
+	return &o
+}
+
+// check is used to compare the options in snapshot with the current.
+func (o *snapshotOptions) check(current *snapshotOptions) error {
+	p, c := &o.params, &current.params
+	switch {
+	case o.options != current.options:
+		return errors.New("options are mismatched with snapshot")
+	case o.datagram != current.datagram:
+		return errors.New("datagram mode is mismatched with snapshot")
+	case p.dictSize != c.dictSize:
+		return errors.New("dictionary size is mismatched with snapshot")
+	case p.policy != c.policy:
+		return errors.New("eviction policy is mismatched with snapshot")
+	case p.pinned != c.pinned:
+		return errors.New("pinned size is mismatched with snapshot")
+	case p.flags&flagChecksum != c.flags&flagChecksum:
+		return errors.New("checksum is mismatched with snapshot")
+	case p.flags&flagBidirectional != c.flags&flagBidirectional:
+		return errors.New("bidirectional mode is mismatched with snapshot")
+	case p.flags != c.flags || p.dictHash != c.dictHash:
+		return errors.New("pre-shared dictionaries are mismatched with snapshot")
+	case o.interval != current.interval:
+		return errors.New("checkpoint interval is mismatched with snapshot")
+	case o.period != current.period:
+		return errors.New("checkpoint period is mismatched with snapshot")
+	}
+	return nil
+}
+
+type snapshotEncoder struct {
+	buf bytes.Buffer
+	tmp [8]byte
+}
+
+func newSnapshotEncoder(kind byte) *snapshotEncoder {
+	enc := snapshotEncoder{}
+	enc.buf.WriteByte(snapshotVersion)
+	enc.buf.WriteByte(kind)
+	return &enc
+}
+
+func (enc *snapshotEncoder) writeBool(b bool) {
+	if b {
+		enc.buf.WriteByte(1)
+	} else {
+		enc.buf.WriteByte(0)
+	}
+}
+
+func (enc *snapshotEncoder) writeUint16(n uint16) {
+	binary.BigEndian.PutUint16(enc.tmp[:2], n)
+	enc.buf.Write(enc.tmp[:2])
+}
+
+func (enc *snapshotEncoder) writeUint64(n uint64) {
+	binary.BigEndian.PutUint64(enc.tmp[:], n)
+	enc.buf.Write(enc.tmp[:])
+}
+
+// writeBytes will write the length and data, nil and empty are the same.
+func (enc *snapshotEncoder) writeBytes(b []byte) {
+	enc.writeUint16(uint16(len(b)))
+	enc.buf.Write(b)
+}
+
+func (enc *snapshotEncoder) writeError(err error) {
+	if err == nil {
+		enc.writeBool(false)
+		return
+	}
+	enc.writeBool(true)
+	enc.writeBytes([]byte(err.Error()))
+}
+
+func (enc *snapshotEncoder) writeDictionaries(dict [][]byte) {
+	for i := 0; i < len(dict); i++ {
+		enc.writeBytes(dict[i])
+	}
+}
+
+func (enc *snapshotEncoder) writeUint16s(s []uint16) {
+	for i := 0; i < len(s); i++ {
+		enc.writeUint16(s[i])
+	}
+}
+
+func (enc *snapshotEncoder) writeUint64s(s []uint64) {
+	for i := 0; i < len(s); i++ {
+		enc.writeUint64(s[i])
+	}
+}
+
+// writeEvictor will write the dictionary table size, eviction policy,
+// pinned size and the inner status of the evictor.
+func (enc *snapshotEncoder) writeEvictor(evict evictor, size int) {
+	enc.writeUint16(uint16(size))
+	switch e := evict.(type) {
+	case *mruEvictor:
+		enc.buf.WriteByte(byte(EvictMRU))
+		enc.buf.WriteByte(0)
+	case *pinnedEvictor:
+		enc.buf.WriteByte(byte(EvictPinned))
+		enc.buf.WriteByte(byte(e.pinned))
+	case *lruEvictor:
+		if e.pinned == 0 {
+			enc.buf.WriteByte(byte(EvictMRU))
+		} else {
+			enc.buf.WriteByte(byte(EvictPinned))
+		}
+		enc.buf.WriteByte(byte(e.pinned))
+		enc.writeUint64s(e.stamp)
+		enc.writeUint64(e.clock)
+	case *lfuEvictor:
+		enc.buf.WriteByte(byte(EvictLFU))
+		enc.buf.WriteByte(0)
+		enc.writeUint16s(e.freq)
+		enc.writeUint64s(e.stamp)
+		enc.writeUint64(e.clock)
+	case *arcEvictor:
+		enc.buf.WriteByte(byte(EvictARC))
+		enc.buf.WriteByte(0)
+		enc.writeUint16(uint16(e.p))
+		enc.buf.Write(e.list)
+		enc.writeUint64s(e.stamp)
+		enc.writeUint64(e.clock)
+		enc.writeUint16(uint16(len(e.b1)))
+		enc.writeUint64s(e.b1)
+		enc.writeUint16(uint16(len(e.b2)))
+		enc.writeUint64s(e.b2)
+	default:
+		panic(fmt.Sprintf("unknown evictor type: %T", evict))
+	}
+}
+
+func (enc *snapshotEncoder) writeOptions(o *snapshotOptions) {
+	enc.writeBool(o.options)
+	enc.writeUint16(uint16(o.params.dictSize))
+	enc.buf.WriteByte(byte(o.params.policy))
+	enc.buf.WriteByte(byte(o.params.pinned))
+	enc.buf.WriteByte(o.params.flags)
+	enc.buf.Write(o.params.dictHash[:])
+	enc.writeBool(o.datagram)
+	enc.writeUint64(uint64(o.interval))
+	enc.writeUint64(uint64(o.period))
+}
+
+func (enc *snapshotEncoder) writeDatagram(d *datagram) {
+	if d == nil {
+		return
+	}
+	enc.writeUint64(uint64(d.interval))
+	enc.buf.WriteByte(byte(d.mode))
+	enc.writeUint16(d.seq)
+	enc.writeBool(d.started)
+	enc.writeUint64(d.lost)
+	enc.writeUint64(d.seen)
+	enc.writeUint16s(d.version)
+	for i := 0; i < len(d.refs); i++ {
+		enc.writeUint64(uint64(d.refs[i]))
+	}
+	for i := 0; i < len(d.acked); i++ {
+		enc.writeBool(d.acked[i])
+	}
+	enc.writeBytes(d.feedback)
+}
+
+// snapshotDecoder will save the first error, the
+// following read methods will return zero value.
+type snapshotDecoder struct {
+	r   *bytes.Reader
+	tmp [8]byte
+	err error
+}
+
+func newSnapshotDecoder(data []byte, kind byte) (*snapshotDecoder, error) {
+	if len(data) < 2 {
+		return nil, errors.New("invalid snapshot size")
+	}
+	if data[0] != snapshotVersion {
+		return nil, fmt.Errorf("invalid snapshot version: %d", data[0])
+	}
+	if data[1] != kind {
+		return nil, fmt.Errorf("invalid snapshot kind: %d", data[1])
+	}
+	dec := snapshotDecoder{
+		r: bytes.NewReader(data[2:]),
+	}
+	return &dec, nil
+}
+
+func (dec *snapshotDecoder) read(b []byte) {
+	if dec.err != nil {
+		return
+	}
+	_, err := io.ReadFull(dec.r, b)
+	if err != nil {
+		dec.err = errors.New("snapshot is truncated")
+	}
+}
+
+func (dec *snapshotDecoder) readByte() byte {
+	dec.read(dec.tmp[:1])
+	if dec.err != nil {
+		return 0
+	}
+	return dec.tmp[0]
+}
+
+func (dec *snapshotDecoder) readBool() bool {
+	return dec.readByte() != 0
+}
+
+func (dec *snapshotDecoder) readUint16() uint16 {
+	dec.read(dec.tmp[:2])
+	if dec.err != nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(dec.tmp[:2])
+}
+
+func (dec *snapshotDecoder) readUint64() uint64 {
+	dec.read(dec.tmp[:])
+	if dec.err != nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(dec.tmp[:])
+}
+
+// readBytes will return nil if the length is zero.
+func (dec *snapshotDecoder) readBytes() []byte {
+	l := int(dec.readUint16())
+	if l == 0 {
+		return nil
+	}
+	if l > dec.r.Len() {
+		dec.err = errors.New("snapshot is truncated")
+		return nil
+	}
+	b := make([]byte, l)
+	dec.read(b)
+	return b
+}
+
+func (dec *snapshotDecoder) readError() error {
+	if !dec.readBool() {
+		return nil
+	}
+	msg := dec.readBytes()
+	if dec.err != nil {
+		return nil
+	}
+	return errors.New(string(msg))
+}
+
+func (dec *snapshotDecoder) readDictionaries(dict [][]byte) {
+	for i := 0; i < len(dict); i++ {
+		d := dec.readBytes()
+		if len(d) > MaxFrameHeaderSize {
+			dec.err = fmt.Errorf("invalid dictionary size in snapshot: %d", len(d))
+			return
+		}
+		dict[i] = d
+	}
+}
+
+func (dec *snapshotDecoder) readUint16s(s []uint16) {
+	for i := 0; i < len(s); i++ {
+		s[i] = dec.readUint16()
+	}
+}
+
+func (dec *snapshotDecoder) readUint64s(s []uint64) {
+	for i := 0; i < len(s); i++ {
+		s[i] = dec.readUint64()
+	}
+}
+
+func (dec *snapshotDecoder) readGhosts(size int) []uint64 {
+	l := int(dec.readUint16())
+	if l == 0 {
+		return nil
+	}
+	if l > size {
+		dec.err = fmt.Errorf("invalid ghost list size in snapshot: %d", l)
+		return nil
+	}
+	ghosts := make([]uint64, l)
+	dec.readUint64s(ghosts)
+	return ghosts
+}
+
+// readEvictor will create the dictionary table and the evictor with inner status.
+func (dec *snapshotDecoder) readEvictor(stable bool) ([][]byte, evictor) {
+	size := int(dec.readUint16())
+	policy := EvictionPolicy(dec.readByte())
+	pinned := int(dec.readByte())
+	if dec.err != nil {
+		return nil, nil
+	}
+	err := checkDictSize(size)
+	if err != nil {
+		dec.err = err
+		return nil, nil
+	}
+	var evict evictor
+	if stable {
+		evict, err = newStableEvictor(policy, size, pinned)
+	} else {
+		evict, err = newEvictor(policy, size, pinned)
+	}
+	if err != nil {
+		dec.err = err
+		return nil, nil
+	}
+	switch e := evict.(type) {
+	case *lruEvictor:
+		dec.readUint64s(e.stamp)
+		e.clock = dec.readUint64()
+	case *lfuEvictor:
+		dec.readUint16s(e.freq)
+		dec.readUint64s(e.stamp)
+		e.clock = dec.readUint64()
+	case *arcEvictor:
+		e.p = int(dec.readUint16())
+		dec.read(e.list)
+		dec.readUint64s(e.stamp)
+		e.clock = dec.readUint64()
+		e.b1 = dec.readGhosts(size)
+		e.b2 = dec.readGhosts(size)
+	}
+	return make([][]byte, size), evict
+}
+
+func (dec *snapshotDecoder) readOptions() *snapshotOptions {
+	o := snapshotOptions{}
+	o.options = dec.readBool()
+	o.params.dictSize = int(dec.readUint16())
+	o.params.policy = EvictionPolicy(dec.readByte())
+	o.params.pinned = int(dec.readByte())
+	o.params.flags = dec.readByte()
+	dec.read(o.params.dictHash[:])
+	o.datagram = dec.readBool()
+	o.interval = int(dec.readUint64() % (1 << 31))
+	o.period = time.Duration(dec.readUint64() % (1 << 63))
+	return &o
+}
+
+func (dec *snapshotDecoder) readDatagram(stable bool, size int) *datagram {
+	if !stable || dec.err != nil {
+		return nil
+	}
+	interval := dec.readUint64()
+	if interval < 1 || interval > 1<<31 {
+		dec.err = fmt.Errorf("invalid keyframe interval in snapshot: %d", interval)
+		return nil
+	}
+	mode := FeedbackMode(dec.readByte())
+	if mode > FeedbackReliable {
+		dec.err = fmt.Errorf("invalid feedback mode: %d", mode)
+		return nil
+	}
+	d := newDatagram(&Options{
+		DictSize:         size,
+		KeyframeInterval: int(interval),
+		Feedback:         mode,
+	})
+	d.seq = dec.readUint16()
+	d.started = dec.readBool()
+	d.lost = dec.readUint64()
+	d.seen = dec.readUint64()
+	dec.readUint16s(d.version)
+	for i := 0; i < size; i++ {
+		d.refs[i] = int(dec.readUint64() % (1 << 31))
+	}
+	for i := 0; i < len(d.acked); i++ {
+		d.acked[i] = dec.readBool()
+	}
+	d.feedback = dec.readBytes()
+	if len(d.feedback) > maxFeedbackSize || len(d.feedback)%feedbackEntrySize != 0 {
+		dec.err = fmt.Errorf("invalid feedback size in snapshot: %d", len(d.feedback))
+		return nil
+	}
+	return d
+}
+
+// checkEvictor is used to check the inner status of evictor
+// is matched with the dictionary table.
+func (dec *snapshotDecoder) checkEvictor(dict [][]byte, evict evictor) {
+	e, ok := evict.(*arcEvictor)
+	if !ok || dec.err != nil {
+		return
+	}
+	for i := 0; i < len(dict); i++ {
+		if e.list[i] > arcT2 || (e.list[i] == arcNone) != (dict[i] == nil) {
+			dec.err = errors.New("invalid evictor status in snapshot")
+			return
+		}
+	}
+}
+
+// close will return the first error and check all data is read.
+func (dec *snapshotDecoder) close() error {
+	if dec.err != nil {
+		return dec.err
+	}
+	if dec.r.Len() != 0 {
+		return errors.New("snapshot has unexpected data")
+	}
+	return nil
+}
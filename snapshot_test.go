@@ -0,0 +1,407 @@
+package cfh
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter_MarshalBinary(t *testing.T) {
+	headers := testGenerateFrameHeaders(t)
+	half := len(headers) / 2
+
+	for _, policy := range testEvictionPolicies {
+		t.Run(policy.String(), func(t *testing.T) {
+			opts := Options{
+				DictSize:   64,
+				Policy:     policy,
+				PinnedSize: 4,
+			}
+			output1 := bytes.NewBuffer(make([]byte, 0, 4*1024*1024))
+			w1, err := NewWriterWithOptions(output1, &opts)
+			require.NoError(t, err)
+			for _, header := range headers[:half] {
+				_, err = w1.Write(header)
+				require.NoError(t, err)
+			}
+
+			snapshot, err := w1.MarshalBinary()
+			require.NoError(t, err)
+
+			// migrate to a new Writer with the other under writer
+			output2 := bytes.NewBuffer(make([]byte, 0, 4*1024*1024))
+			w2, err := NewWriterWithOptions(output2, &opts)
+			require.NoError(t, err)
+			err = w2.UnmarshalBinary(snapshot)
+			require.NoError(t, err)
+
+			stream := output1.Len()
+			for _, header := range headers[half:] {
+				_, err = w1.Write(header)
+				require.NoError(t, err)
+				_, err = w2.Write(header)
+				require.NoError(t, err)
+			}
+			require.Equal(t, output1.Bytes()[stream:], output2.Bytes())
+
+			r := NewReader(output1)
+			for _, header := range headers {
+				buf := make([]byte, len(header))
+				_, err = r.Read(buf)
+				require.NoError(t, err)
+				require.Equal(t, header, buf)
+			}
+		})
+	}
+
+	t.Run("stream parameters are not written", func(t *testing.T) {
+		output1 := bytes.NewBuffer(make([]byte, 0, 64))
+		w1, err := NewWriterWithOptions(output1, nil)
+		require.NoError(t, err)
+
+		snapshot, err := w1.MarshalBinary()
+		require.NoError(t, err)
+
+		output2 := bytes.NewBuffer(make([]byte, 0, 64))
+		w2, err := NewWriterWithOptions(output2, nil)
+		require.NoError(t, err)
+		err = w2.UnmarshalBinary(snapshot)
+		require.NoError(t, err)
+
+		_, err = w2.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+		require.Equal(t, byte(cmdParams), output2.Bytes()[0])
+	})
+
+	t.Run("checkpoint", func(t *testing.T) {
+		opts := Options{
+			CheckpointInterval: 3,
+		}
+		output1 := bytes.NewBuffer(make([]byte, 0, 4*1024*1024))
+		w1, err := NewWriterWithOptions(output1, &opts)
+		require.NoError(t, err)
+		for _, header := range headers[:half+1] {
+			_, err = w1.Write(header)
+			require.NoError(t, err)
+		}
+
+		snapshot, err := w1.MarshalBinary()
+		require.NoError(t, err)
+
+		output2 := bytes.NewBuffer(make([]byte, 0, 4*1024*1024))
+		w2, err := NewWriterWithOptions(output2, &opts)
+		require.NoError(t, err)
+		err = w2.UnmarshalBinary(snapshot)
+		require.NoError(t, err)
+		require.Equal(t, w1.records, w2.records)
+
+		stream := output1.Len()
+		for _, header := range headers[half+1:] {
+			_, err = w1.Write(header)
+			require.NoError(t, err)
+			_, err = w2.Write(header)
+			require.NoError(t, err)
+		}
+		require.Equal(t, output1.Bytes()[stream:], output2.Bytes())
+	})
+
+	t.Run("mismatched options", func(t *testing.T) {
+		for _, item := range []*struct {
+			snapshot *Options
+			current  *Options
+			err      string
+		}{
+			{&Options{Datagram: true}, &Options{}, "datagram mode is mismatched with snapshot"},
+			{
+				&Options{DictSize: 2, Checksum: true}, &Options{Checksum: true},
+				"dictionary size is mismatched with snapshot",
+			},
+			{&Options{Policy: EvictLFU}, &Options{}, "eviction policy is mismatched with snapshot"},
+			{
+				&Options{Policy: EvictPinned, PinnedSize: 1},
+				&Options{Policy: EvictPinned, PinnedSize: 2},
+				"pinned size is mismatched with snapshot",
+			},
+			{&Options{Checksum: true}, &Options{}, "checksum is mismatched with snapshot"},
+			{&Options{Bidirectional: true}, &Options{}, "bidirectional mode is mismatched with snapshot"},
+			{
+				&Options{Dictionaries: [][]byte{testIPv4TCPFrameHeader1}}, &Options{},
+				"pre-shared dictionaries are mismatched with snapshot",
+			},
+			{
+				&Options{Dictionaries: [][]byte{testIPv4TCPFrameHeader1}},
+				&Options{Dictionaries: [][]byte{testIPv6TCPFrameHeader1}},
+				"pre-shared dictionaries are mismatched with snapshot",
+			},
+			{
+				&Options{CheckpointInterval: 3}, &Options{},
+				"checkpoint interval is mismatched with snapshot",
+			},
+			{
+				&Options{CheckpointPeriod: time.Second}, &Options{},
+				"checkpoint period is mismatched with snapshot",
+			},
+		} {
+			w1, err := NewWriterWithOptions(new(bytes.Buffer), item.snapshot)
+			require.NoError(t, err)
+			snapshot, err := w1.MarshalBinary()
+			require.NoError(t, err)
+
+			w2, err := NewWriterWithOptions(new(bytes.Buffer), item.current)
+			require.NoError(t, err)
+			err = w2.UnmarshalBinary(snapshot)
+			require.EqualError(t, err, item.err)
+
+			w2, err = NewWriterWithOptions(new(bytes.Buffer), item.snapshot)
+			require.NoError(t, err)
+			err = w2.UnmarshalBinary(snapshot)
+			require.NoError(t, err)
+		}
+
+		snapshot, err := NewWriter(new(bytes.Buffer)).MarshalBinary()
+		require.NoError(t, err)
+		w, err := NewWriterWithOptions(new(bytes.Buffer), nil)
+		require.NoError(t, err)
+		err = w.UnmarshalBinary(snapshot)
+		require.EqualError(t, err, "options are mismatched with snapshot")
+	})
+
+	t.Run("sticky error", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+		w := NewWriter(output)
+		w.err = errors.New("foo")
+
+		snapshot, err := w.MarshalBinary()
+		require.NoError(t, err)
+
+		w = NewWriter(output)
+		err = w.UnmarshalBinary(snapshot)
+		require.NoError(t, err)
+
+		n, err := w.Write(testIPv4TCPFrameHeader1)
+		require.EqualError(t, err, "foo")
+		require.Zero(t, n)
+	})
+}
+
+func TestReader_MarshalBinary(t *testing.T) {
+	headers := testGenerateFrameHeaders(t)
+	half := len(headers) / 2
+
+	for _, policy := range testEvictionPolicies {
+		t.Run(policy.String(), func(t *testing.T) {
+			opts := Options{
+				DictSize:   64,
+				Policy:     policy,
+				PinnedSize: 4,
+			}
+			output := bytes.NewBuffer(make([]byte, 0, 4*1024*1024))
+			w, err := NewWriterWithOptions(output, &opts)
+			require.NoError(t, err)
+			for _, header := range headers {
+				_, err = w.Write(header)
+				require.NoError(t, err)
+			}
+
+			r1, err := NewReaderWithOptions(output, &opts)
+			require.NoError(t, err)
+			for _, header := range headers[:half] {
+				buf := make([]byte, len(header))
+				_, err = r1.Read(buf)
+				require.NoError(t, err)
+				require.Equal(t, header, buf)
+			}
+
+			snapshot, err := r1.MarshalBinary()
+			require.NoError(t, err)
+
+			// migrate to a new Reader with the remaining stream
+			r2, err := NewReaderWithOptions(output, &opts)
+			require.NoError(t, err)
+			err = r2.UnmarshalBinary(snapshot)
+			require.NoError(t, err)
+
+			for _, header := range headers[half:] {
+				buf := make([]byte, len(header))
+				_, err = r2.Read(buf)
+				require.NoError(t, err)
+				require.Equal(t, header, buf)
+			}
+		})
+	}
+
+	t.Run("remaining data", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+		w := NewWriter(output)
+		_, err := w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+
+		r := NewReader(output)
+		buf := make([]byte, 16)
+		_, err = r.Read(buf)
+		require.NoError(t, err)
+
+		snapshot, err := r.MarshalBinary()
+		require.NoError(t, err)
+
+		r = NewReader(output)
+		err = r.UnmarshalBinary(snapshot)
+		require.NoError(t, err)
+
+		rem := make([]byte, len(testIPv4TCPFrameHeader1)-16)
+		_, err = r.Read(rem)
+		require.NoError(t, err)
+		require.Equal(t, testIPv4TCPFrameHeader1, append(buf, rem...))
+	})
+
+	t.Run("mismatched options", func(t *testing.T) {
+		for _, item := range []*struct {
+			snapshot *Options
+			current  *Options
+			err      string
+		}{
+			{&Options{Datagram: true}, &Options{}, "datagram mode is mismatched with snapshot"},
+			{&Options{DictSize: 2}, &Options{}, "dictionary size is mismatched with snapshot"},
+			{&Options{Checksum: true}, &Options{}, "checksum is mismatched with snapshot"},
+			{
+				&Options{Dictionaries: [][]byte{testIPv4TCPFrameHeader1}}, &Options{},
+				"pre-shared dictionaries are mismatched with snapshot",
+			},
+		} {
+			r1, err := NewReaderWithOptions(new(bytes.Buffer), item.snapshot)
+			require.NoError(t, err)
+			snapshot, err := r1.MarshalBinary()
+			require.NoError(t, err)
+
+			r2, err := NewReaderWithOptions(new(bytes.Buffer), item.current)
+			require.NoError(t, err)
+			err = r2.UnmarshalBinary(snapshot)
+			require.EqualError(t, err, item.err)
+
+			r2, err = NewReaderWithOptions(new(bytes.Buffer), item.snapshot)
+			require.NoError(t, err)
+			err = r2.UnmarshalBinary(snapshot)
+			require.NoError(t, err)
+		}
+
+		snapshot, err := NewReader(new(bytes.Buffer)).MarshalBinary()
+		require.NoError(t, err)
+		r, err := NewReaderWithOptions(new(bytes.Buffer), nil)
+		require.NoError(t, err)
+		err = r.UnmarshalBinary(snapshot)
+		require.EqualError(t, err, "options are mismatched with snapshot")
+	})
+
+	t.Run("sticky error", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+		r := NewReader(output)
+		buf := make([]byte, 16)
+		_, err := r.Read(buf)
+		require.Error(t, err)
+
+		snapshot, err := r.MarshalBinary()
+		require.NoError(t, err)
+
+		r = NewReader(output)
+		err = r.UnmarshalBinary(snapshot)
+		require.NoError(t, err)
+
+		n, err := r.Read(buf)
+		require.EqualError(t, err, "EOF")
+		require.Zero(t, n)
+	})
+}
+
+func TestUnmarshalBinary(t *testing.T) {
+	output := bytes.NewBuffer(make([]byte, 0, 64))
+	w := NewWriter(output)
+	_, err := w.Write(testIPv4TCPFrameHeader1)
+	require.NoError(t, err)
+	snapshot, err := w.MarshalBinary()
+	require.NoError(t, err)
+
+	t.Run("invalid snapshot size", func(t *testing.T) {
+		err := w.UnmarshalBinary(nil)
+		require.EqualError(t, err, "invalid snapshot size")
+	})
+
+	t.Run("invalid snapshot version", func(t *testing.T) {
+		err := w.UnmarshalBinary([]byte{0, snapshotWriter})
+		require.EqualError(t, err, "invalid snapshot version: 0")
+	})
+
+	t.Run("invalid snapshot kind", func(t *testing.T) {
+		r := NewReader(output)
+		err := r.UnmarshalBinary(snapshot)
+		require.EqualError(t, err, "invalid snapshot kind: 87")
+	})
+
+	t.Run("snapshot is truncated", func(t *testing.T) {
+		for i := 2; i < len(snapshot); i++ {
+			err := w.UnmarshalBinary(snapshot[:i])
+			require.EqualError(t, err, "snapshot is truncated")
+		}
+	})
+
+	t.Run("snapshot has unexpected data", func(t *testing.T) {
+		err := w.UnmarshalBinary(append(snapshot, 0))
+		require.EqualError(t, err, "snapshot has unexpected data")
+	})
+
+	t.Run("invalid dictionary size", func(t *testing.T) {
+		err := w.UnmarshalBinary([]byte{snapshotVersion, snapshotWriter, 0, 0, 0, 0, 0, 0})
+		require.EqualError(t, err, "dictionary size cannot less than 1")
+	})
+
+	t.Run("invalid eviction policy", func(t *testing.T) {
+		err := w.UnmarshalBinary([]byte{snapshotVersion, snapshotWriter, 0, 0, 0, 1, 123, 0})
+		require.EqualError(t, err, "invalid eviction policy: 123")
+	})
+
+	t.Run("invalid dictionary size in snapshot", func(t *testing.T) {
+		data := []byte{snapshotVersion, snapshotWriter, 0, 0, 0, 1, byte(EvictMRU), 0}
+		data = append(data, make([]byte, 1+2+1+1+1+dictHashSize+1+8+8)...) // options
+		data = append(data, 0, 0, 1, 1)
+		data = append(data, make([]byte, 257)...)
+		err := w.UnmarshalBinary(data)
+		require.EqualError(t, err, "invalid dictionary size in snapshot: 257")
+	})
+
+	t.Run("invalid ghost list size in snapshot", func(t *testing.T) {
+		arc := NewWriter(output)
+		arc.evict = newARCEvictor(len(arc.dict))
+		data, err := arc.MarshalBinary()
+		require.NoError(t, err)
+		// set the size of ghost list B1
+		offset := 2 + 1 + 1 + 2 + 1 + 1 + 2 + MaxDictionarySize + 8*MaxDictionarySize + 8
+		data[offset] = 0xFF
+
+		err = w.UnmarshalBinary(data)
+		require.EqualError(t, err, "invalid ghost list size in snapshot: 65280")
+	})
+
+	t.Run("invalid evictor status in snapshot", func(t *testing.T) {
+		arc := NewWriter(output)
+		arc.evict = newARCEvictor(len(arc.dict))
+		arc.dict[0] = []byte{1}
+		data, err := arc.MarshalBinary()
+		require.NoError(t, err)
+
+		err = w.UnmarshalBinary(data)
+		require.EqualError(t, err, "invalid evictor status in snapshot")
+	})
+
+	t.Run("unknown evictor type", func(t *testing.T) {
+		w := NewWriter(output)
+		w.evict = nil
+
+		defer func() {
+			r := recover()
+			require.NotNil(t, r)
+		}()
+		_, _ = w.MarshalBinary()
+	})
+}
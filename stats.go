@@ -0,0 +1,152 @@
+package cfh
+
+import (
+	"sync/atomic"
+)
+
+// Stats contains the statistics about the Writer or the Reader, they are
+// accumulated from the creation and not cleaned by Reset. The fields
+// about the search path are only counted by the Writer.
+type Stats struct {
+	// the number of each command in the written or read records
+	AddDictionary uint64
+	ChangedData   uint64
+	LastData      uint64
+	PreviousData  uint64
+	SwappedData   uint64
+
+	// BytesIn is the size of the frame headers written to the Writer,
+	// or the size of the stream or datagrams read by the Reader.
+	BytesIn uint64
+
+	// BytesOut is the size of the stream or datagrams written by the
+	// Writer, or the size of the frame headers read from the Reader.
+	BytesOut uint64
+
+	// Changes is the total number of changed bytes in the records
+	// about ChangedData and SwappedData.
+	Changes uint64
+
+	// Hits is the number of records that reference a dictionary, and
+	// Misses is the number of records that add a dictionary.
+	Hits   uint64
+	Misses uint64
+
+	// Evictions is the number of dictionaries that are replaced by the
+	// new one when the table is full, in datagram mode only the Writer
+	// counts it, because the Reader cannot distinguish the keyframe
+	// that replaces a dictionary from the one that refreshes it.
+	Evictions uint64
+
+	// the number of dictionary searches by each path, one for each frame
+	// header, the swapped search in bidirectional mode is not counted
+	FastSearches   uint64
+	CustomSearches uint64
+	SlowSearches   uint64
+}
+
+// AverageChanges is used to get the average number of changed bytes per
+// record about ChangedData and SwappedData.
+func (s Stats) AverageChanges() float64 {
+	n := s.ChangedData + s.SwappedData
+	if n == 0 {
+		return 0
+	}
+	return float64(s.Changes) / float64(n)
+}
+
+// HitRate is used to get the ratio of the records that reference a
+// dictionary in the records that reference or add a dictionary.
+func (s Stats) HitRate() float64 {
+	n := s.Hits + s.Misses
+	if n == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(n)
+}
+
+// statsCounter contains the counters about Stats, they are updated by
+// the Writer or the Reader, and can be loaded by other goroutines.
+type statsCounter struct {
+	addDictionary atomic.Uint64
+	changedData   atomic.Uint64
+	lastData      atomic.Uint64
+	previousData  atomic.Uint64
+	swappedData   atomic.Uint64
+
+	bytesIn  atomic.Uint64
+	bytesOut atomic.Uint64
+	changes  atomic.Uint64
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+
+	fastSearches   atomic.Uint64
+	customSearches atomic.Uint64
+	slowSearches   atomic.Uint64
+}
+
+// count is used to update the counters about one record.
+func (s *statsCounter) count(cmd byte, changes int) {
+	switch cmd {
+	case cmdAddDict:
+		s.addDictionary.Add(1)
+		s.misses.Add(1)
+	case cmdData:
+		s.changedData.Add(1)
+		s.changes.Add(uint64(changes))
+		s.hits.Add(1)
+	case cmdLast:
+		s.lastData.Add(1)
+	case cmdPrev:
+		s.previousData.Add(1)
+		s.hits.Add(1)
+	case cmdSwap:
+		s.swappedData.Add(1)
+		s.changes.Add(uint64(changes))
+		s.hits.Add(1)
+	}
+}
+
+func (s *statsCounter) load() Stats {
+	return Stats{
+		AddDictionary:  s.addDictionary.Load(),
+		ChangedData:    s.changedData.Load(),
+		LastData:       s.lastData.Load(),
+		PreviousData:   s.previousData.Load(),
+		SwappedData:    s.swappedData.Load(),
+		BytesIn:        s.bytesIn.Load(),
+		BytesOut:       s.bytesOut.Load(),
+		Changes:        s.changes.Load(),
+		Hits:           s.hits.Load(),
+		Misses:         s.misses.Load(),
+		Evictions:      s.evictions.Load(),
+		FastSearches:   s.fastSearches.Load(),
+		CustomSearches: s.customSearches.Load(),
+		SlowSearches:   s.slowSearches.Load(),
+	}
+}
+
+// isTableFull is used to check the dictionary table has no empty slot,
+// then the next added dictionary will evict one.
+func isTableFull(dict [][]byte) bool {
+	for i := 0; i < len(dict); i++ {
+		if dict[i] == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Stats is used to get the statistics about the Writer,
+// it is safe to call it concurrently with Write.
+func (w *Writer) Stats() Stats {
+	return w.stats.load()
+}
+
+// Stats is used to get the statistics about the Reader,
+// it is safe to call it concurrently with Read.
+func (r *Reader) Stats() Stats {
+	return r.stats.load()
+}
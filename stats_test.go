@@ -0,0 +1,150 @@
+package cfh
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	t.Run("stream", func(t *testing.T) {
+		h1 := testIPv4TCPFrameHeader1
+		h2 := bytes.Clone(h1)
+		h2[18]++ // IPv4 ID
+		h2[19]++
+		h3 := bytes.Repeat([]byte{1}, 16)
+		h4 := bytes.Repeat([]byte{2}, 20)
+
+		output := bytes.NewBuffer(make([]byte, 0, 256))
+		opts := Options{
+			DictSize: 2,
+		}
+		w, err := NewWriterWithOptions(output, &opts)
+		require.NoError(t, err)
+		err = w.RegisterSearcher(len(h4), func([][]byte, []byte) int {
+			return -1
+		})
+		require.NoError(t, err)
+
+		headers := [][]byte{h1, h1, h2, h3, h2, h4}
+		var size int
+		for _, header := range headers {
+			_, err = w.Write(header)
+			require.NoError(t, err)
+			size += len(header)
+		}
+
+		expected := Stats{
+			AddDictionary:  3,
+			ChangedData:    1,
+			LastData:       1,
+			PreviousData:   1,
+			BytesIn:        uint64(size),
+			BytesOut:       uint64(output.Len()),
+			Changes:        2,
+			Hits:           2,
+			Misses:         3,
+			Evictions:      1,
+			FastSearches:   3,
+			CustomSearches: 1,
+			SlowSearches:   1,
+		}
+		require.Equal(t, expected, w.Stats())
+		require.Equal(t, 2.0, w.Stats().AverageChanges())
+		require.Equal(t, 0.4, w.Stats().HitRate())
+
+		r, err := NewReaderWithOptions(output, &opts)
+		require.NoError(t, err)
+		testReadFrameHeaders(t, r, headers)
+
+		expected.BytesIn, expected.BytesOut = expected.BytesOut, expected.BytesIn
+		expected.FastSearches = 0
+		expected.CustomSearches = 0
+		expected.SlowSearches = 0
+		require.Equal(t, expected, r.Stats())
+	})
+
+	t.Run("swapped data", func(t *testing.T) {
+		request := testIPv4TCPFrameHeader1
+		reply := bytes.Clone(request)
+		swapDirection(reply)
+		reply[19]++ // IPv4 ID [byte 2]
+
+		output := bytes.NewBuffer(make([]byte, 0, 256))
+		opts := Options{
+			Bidirectional: true,
+		}
+		w, err := NewWriterWithOptions(output, &opts)
+		require.NoError(t, err)
+		_, err = w.Write(request)
+		require.NoError(t, err)
+		_, err = w.Write(reply)
+		require.NoError(t, err)
+
+		stats := w.Stats()
+		require.Equal(t, uint64(1), stats.SwappedData)
+		require.Equal(t, uint64(1), stats.Changes)
+		require.Equal(t, uint64(1), stats.Hits)
+		require.Equal(t, uint64(2), stats.FastSearches)
+
+		r, err := NewReaderWithOptions(output, &opts)
+		require.NoError(t, err)
+		testReadFrameHeaders(t, r, [][]byte{request, reply})
+
+		stats = r.Stats()
+		require.Equal(t, uint64(1), stats.SwappedData)
+		require.Equal(t, uint64(1), stats.Changes)
+		require.Equal(t, uint64(1), stats.Hits)
+	})
+
+	t.Run("datagram", func(t *testing.T) {
+		h1 := testIPv4UDPFrameHeader1
+		h2 := bytes.Clone(h1)
+		h2[19]++ // IPv4 ID
+
+		w, r, _ := testNewDatagramPair(t, &Options{Datagram: true})
+		headers := [][]byte{h1, h1, h2}
+		for _, header := range headers {
+			_, err := w.Write(header)
+			require.NoError(t, err)
+		}
+		testReadFrameHeaders(t, r, headers)
+
+		ws := w.Stats()
+		rs := r.Stats()
+		for _, stats := range []Stats{ws, rs} {
+			require.Equal(t, uint64(1), stats.AddDictionary)
+			require.Equal(t, uint64(1), stats.PreviousData)
+			require.Equal(t, uint64(1), stats.ChangedData)
+			require.Equal(t, uint64(1), stats.Changes)
+		}
+		require.Equal(t, ws.BytesOut, rs.BytesIn)
+		require.Equal(t, ws.BytesIn, rs.BytesOut)
+		require.Equal(t, uint64(3), ws.FastSearches)
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		w := NewWriter(new(bytes.Buffer))
+		_, err := w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+
+		w.Reset(new(bytes.Buffer))
+		require.Equal(t, uint64(1), w.Stats().AddDictionary)
+	})
+}
+
+func TestStats_Rate(t *testing.T) {
+	require.Zero(t, Stats{}.AverageChanges())
+	require.Zero(t, Stats{}.HitRate())
+
+	stats := Stats{
+		ChangedData: 2,
+		SwappedData: 2,
+		Changes:     10,
+		Hits:        3,
+		Misses:      1,
+	}
+	require.Equal(t, 2.5, stats.AverageChanges())
+	require.Equal(t, 0.75, stats.HitRate())
+}
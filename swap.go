@@ -0,0 +1,69 @@
+package cfh
+
+// swapDirection is used to swap the fields about the direction of the
+// Ethernet frame header in place, they are the Ethernet addresses, the
+// IP addresses, the ports, and the TCP sequence and acknowledgment
+// numbers. It will return false if the size of header is not supported.
+func swapDirection(header []byte) bool {
+	switch len(header) {
+	case ethernetIPv4TCPSize:
+		swapIPv4(header)
+		swapFields(header[38:42], header[42:46])
+	case ethernetIPv4UDPSize:
+		swapIPv4(header)
+	case ethernetIPv6TCPSize:
+		swapIPv6(header)
+		swapFields(header[58:62], header[62:66])
+	case ethernetIPv6UDPSize:
+		swapIPv6(header)
+	default:
+		return false
+	}
+	swapFields(header[:6], header[6:12])
+	return true
+}
+
+func swapIPv4(header []byte) {
+	swapFields(header[26:30], header[30:34])
+	swapFields(header[34:36], header[36:38])
+}
+
+func swapIPv6(header []byte) {
+	swapFields(header[22:38], header[38:54])
+	swapFields(header[54:56], header[56:58])
+}
+
+func swapFields(a, b []byte) {
+	for i := 0; i < len(a); i++ {
+		a[i], b[i] = b[i], a[i]
+	}
+}
+
+// bidirectional is used to check the Writer can write the record with
+// swapped direction, it is only used in stream mode.
+func (w *Writer) bidirectional() bool {
+	return w.opts != nil && w.opts.Bidirectional
+}
+
+// searchSwapped is used to search the dictionary about the reverse
+// direction of the flow, it will return -1 if it is not found.
+func (w *Writer) searchSwapped(header []byte) int {
+	if w.swp == nil {
+		w.swp = make([]byte, MaxFrameHeaderSize)
+	}
+	swp := w.swp[:len(header)]
+	copy(swp, header)
+	if !swapDirection(swp) {
+		return -1
+	}
+	idx, _ := w.search(swp)
+	return idx
+}
+
+// compareSwapped is like compareDictionary, but the dictionary is swapped.
+func (w *Writer) compareSwapped(idx int, b []byte) {
+	swp := w.swp[:len(b)]
+	copy(swp, w.dict[idx])
+	swapDirection(swp)
+	w.compareData(swp, b)
+}
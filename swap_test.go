@@ -0,0 +1,190 @@
+package cfh
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSwapDirection(t *testing.T) {
+	for _, header := range [][]byte{
+		testIPv4TCPFrameHeader1,
+		testIPv4UDPFrameHeader1,
+		testIPv6TCPFrameHeader1,
+		testIPv6UDPFrameHeader1,
+	} {
+		swapped := bytes.Clone(header)
+		ok := swapDirection(swapped)
+		require.True(t, ok)
+		require.NotEqual(t, header, swapped)
+		require.Equal(t, header[:6], swapped[6:12])
+		require.Equal(t, header[6:12], swapped[:6])
+
+		ok = swapDirection(swapped)
+		require.True(t, ok)
+		require.Equal(t, header, swapped)
+	}
+
+	ok := swapDirection(make([]byte, 16))
+	require.False(t, ok)
+}
+
+func TestWriter_Bidirectional(t *testing.T) {
+	t.Run("common", func(t *testing.T) {
+		request := testIPv4TCPFrameHeader1
+		reply := bytes.Clone(request)
+		swapDirection(reply)
+		reply[19]++ // IPv4 ID [byte 2]
+
+		output := bytes.NewBuffer(make([]byte, 0, 256))
+		opts := Options{Bidirectional: true}
+		w, err := NewWriterWithOptions(output, &opts)
+		require.NoError(t, err)
+		_, err = w.Write(request)
+		require.NoError(t, err)
+		offset := output.Len()
+		_, err = w.Write(reply)
+		require.NoError(t, err)
+		require.Nil(t, w.dict[1])
+
+		expected := []byte{cmdSwap, 0, 1, 19, reply[19]}
+		require.Equal(t, expected, output.Bytes()[offset:])
+
+		r, err := NewReaderWithOptions(output, &opts)
+		require.NoError(t, err)
+		for _, header := range [][]byte{request, reply} {
+			buf, err := r.ReadHeader()
+			require.NoError(t, err)
+			require.Equal(t, header, buf)
+		}
+		require.Equal(t, w.dict, r.dict)
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		headers := testGenerateFrameHeaders(t)
+		for i := 1; i < len(headers); i += 2 {
+			// the reply of the previous frame header
+			reply := bytes.Clone(headers[i-1])
+			if swapDirection(reply) {
+				headers[i] = reply
+			}
+		}
+
+		output := bytes.NewBuffer(make([]byte, 0, 4*1024*1024))
+		w := NewWriter(output)
+		for _, header := range headers {
+			_, err := w.Write(header)
+			require.NoError(t, err)
+		}
+		size := output.Len()
+
+		output = bytes.NewBuffer(make([]byte, 0, 4*1024*1024))
+		opts := Options{
+			Bidirectional:      true,
+			Checksum:           true,
+			CheckpointInterval: 64,
+		}
+		w, err := NewWriterWithOptions(output, &opts)
+		require.NoError(t, err)
+		for _, header := range headers {
+			_, err = w.Write(header)
+			require.NoError(t, err)
+		}
+		require.Less(t, output.Len(), size)
+
+		// the Reader will use the stream parameters
+		r := NewReader(output)
+		for _, header := range headers {
+			buf, err := r.ReadHeader()
+			require.NoError(t, err)
+			require.Equal(t, header, buf)
+		}
+	})
+
+	t.Run("custom frame header", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 256))
+		opts := Options{Bidirectional: true}
+		w, err := NewWriterWithOptions(output, &opts)
+		require.NoError(t, err)
+		_, err = w.Write([]byte{1, 2, 3, 4})
+		require.NoError(t, err)
+		_, err = w.Write([]byte{5, 6, 7, 8})
+		require.NoError(t, err)
+		require.Equal(t, byte(cmdAddDict), w.cmd)
+	})
+
+	t.Run("mismatched options", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 256))
+		opts := Options{Bidirectional: true}
+		w, err := NewWriterWithOptions(output, &opts)
+		require.NoError(t, err)
+		_, err = w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+
+		r, err := NewReaderWithOptions(output, nil)
+		require.NoError(t, err)
+		_, err = r.ReadHeader()
+		require.EqualError(t, err, "stream parameters are mismatched with options")
+	})
+}
+
+func TestReader_readChangedData(t *testing.T) {
+	for _, item := range []*struct {
+		name   string
+		data   []byte
+		errStr string
+	}{
+		{
+			"failed to read dictionary index", []byte{cmdSwap},
+			"failed to read dictionary index: record is truncated: unexpected EOF",
+		},
+		{"invalid dictionary index", []byte{cmdSwap, 0}, "invalid dictionary index: 0"},
+		{
+			"failed to read the number of changed data",
+			[]byte{cmdAddDict, 4, 1, 2, 3, 4, cmdSwap, 0},
+			"failed to read the number of changed data: record is truncated: unexpected EOF",
+		},
+		{
+			"invalid changed data size",
+			[]byte{cmdAddDict, 4, 1, 2, 3, 4, cmdSwap, 0, 5},
+			"read invalid changed data size: 5",
+		},
+		{
+			"failed to read changed data",
+			[]byte{cmdAddDict, 4, 1, 2, 3, 4, cmdSwap, 0, 1, 0},
+			"failed to read changed data: record is truncated: unexpected EOF",
+		},
+		{
+			"invalid changed data index",
+			[]byte{cmdAddDict, 4, 1, 2, 3, 4, cmdSwap, 0, 1, 4, 0},
+			"invalid changed data index: 4",
+		},
+		{
+			"invalid swapped dictionary size",
+			[]byte{cmdAddDict, 4, 1, 2, 3, 4, cmdSwap, 0, 0},
+			"invalid swapped dictionary size: 4",
+		},
+	} {
+		t.Run(item.name, func(t *testing.T) {
+			r := NewReader(bytes.NewReader(item.data))
+			buf := make([]byte, MaxFrameHeaderSize)
+			var err error
+			for err == nil {
+				_, err = r.Read(buf)
+			}
+			require.EqualError(t, err, item.errStr)
+		})
+	}
+
+	t.Run("skip record", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+		output.Write([]byte{cmdSwap, 0, 1, 0, 0, cmdReset, cmdAddDict, 1, 1})
+
+		r := NewReader(output)
+		r.desync = true
+		buf, err := r.ReadHeader()
+		require.NoError(t, err)
+		require.Equal(t, []byte{1}, buf)
+	})
+}
@@ -0,0 +1,152 @@
+package cfh
+
+import (
+	"fmt"
+)
+
+// Command is the command of the record in the compressed stream.
+type Command uint8
+
+// commands about the records.
+const (
+	// CommandAddDictionary is used to add a new dictionary, in datagram
+	// mode it is the keyframe that may refresh an existed dictionary.
+	CommandAddDictionary Command = cmdAddDict
+
+	// CommandChangedData is used to write the changed data with a dictionary.
+	CommandChangedData Command = cmdData
+
+	// CommandLastData is used to repeat the last frame header.
+	CommandLastData Command = cmdLast
+
+	// CommandPreviousData is used to repeat a dictionary without changes.
+	CommandPreviousData Command = cmdPrev
+
+	// CommandSwappedData is used to write the changed data with a
+	// dictionary about the reverse direction of the flow.
+	CommandSwappedData Command = cmdSwap
+
+	// CommandParameters is the stream parameters record.
+	CommandParameters Command = cmdParams
+
+	// CommandReset is used to reset the dictionary table.
+	CommandReset Command = cmdReset
+
+	// CommandCheckpoint is the hash of the dictionary table.
+	CommandCheckpoint Command = cmdCheckpoint
+)
+
+// String implements fmt.Stringer.
+func (c Command) String() string {
+	switch c {
+	case CommandAddDictionary:
+		return "AddDictionary"
+	case CommandChangedData:
+		return "ChangedData"
+	case CommandLastData:
+		return "LastData"
+	case CommandPreviousData:
+		return "PreviousData"
+	case CommandSwappedData:
+		return "SwappedData"
+	case CommandParameters:
+		return "Parameters"
+	case CommandReset:
+		return "Reset"
+	case CommandCheckpoint:
+		return "Checkpoint"
+	default:
+		return fmt.Sprintf("Command(%d)", uint8(c))
+	}
+}
+
+// SearchPath is the path that the Writer used to search the dictionary.
+type SearchPath uint8
+
+// paths about search dictionary.
+const (
+	// SearchFast is the fast mode about the Ethernet frame headers.
+	SearchFast SearchPath = iota
+
+	// SearchCustom is the Searcher registered by RegisterSearcher.
+	SearchCustom
+
+	// SearchSlow compares the frame header with each dictionary.
+	SearchSlow
+)
+
+// String implements fmt.Stringer.
+func (p SearchPath) String() string {
+	switch p {
+	case SearchFast:
+		return "Fast"
+	case SearchCustom:
+		return "Custom"
+	case SearchSlow:
+		return "Slow"
+	default:
+		return fmt.Sprintf("SearchPath(%d)", uint8(p))
+	}
+}
+
+// Tracer is used to observe the decisions of the Writer and the Reader,
+// it is useful to debug the diverged dictionary tables. The callbacks are
+// called synchronously by Write or Read, so they must return quickly.
+type Tracer interface {
+	// OnSearch is called after the Writer searched the dictionary about
+	// the frame header, the index is -1 if it is not found. In the
+	// bidirectional mode, the index may be the dictionary about the
+	// swapped frame header, it is called once for each frame header.
+	OnSearch(size, index int, path SearchPath)
+
+	// OnEncode is called after the Writer wrote a record, the index is
+	// the dictionary about the record, it is -1 with CommandLastData.
+	OnEncode(cmd Command, index, changes int)
+
+	// OnEvict is called when a dictionary is evicted from the full table,
+	// the index is the position of it before the new one is added.
+	OnEvict(index int)
+
+	// OnDecode is called after the Reader read a record, the index is the
+	// same as OnEncode.
+	OnDecode(cmd Command, index int)
+}
+
+// SetTracer is used to set the tracer about the Writer, nil means disable.
+// The tracer is not changed by Reset.
+func (w *Writer) SetTracer(tracer Tracer) {
+	w.tracer = tracer
+}
+
+// SetTracer is used to set the tracer about the Reader, nil means disable.
+// The tracer is not changed by Reset.
+func (r *Reader) SetTracer(tracer Tracer) {
+	r.tracer = tracer
+}
+
+// addToTable is used to add the new dictionary to the table, if the table
+// is full, the eviction is counted in stats and reported to the tracer.
+func addToTable(dict [][]byte, evict evictor, data []byte, stats *statsCounter, tracer Tracer) int {
+	full := isTableFull(dict)
+	idx := evict.add(dict, data)
+	if !full {
+		return idx
+	}
+	stats.evictions.Add(1)
+	if tracer != nil {
+		tracer.OnEvict(evictedIndex(evict, dict, idx))
+	}
+	return idx
+}
+
+// evictedIndex is used to get the index of the evicted dictionary, the
+// evictors that move dictionaries always evict the last one, the others
+// replace the evicted dictionary with the new one.
+func evictedIndex(evict evictor, dict [][]byte, idx int) int {
+	switch evict.(type) {
+	case *mruEvictor, *pinnedEvictor:
+		return len(dict) - 1
+	default:
+		return idx
+	}
+}
@@ -0,0 +1,65 @@
+//go:build go1.21
+
+package cfh
+
+import (
+	"context"
+	"log/slog"
+)
+
+// SlogTracer is a Tracer that logs each event through log/slog, the
+// events are logged at the debug level by default.
+type SlogTracer struct {
+	logger *slog.Logger
+	level  slog.Level
+}
+
+// NewSlogTracer is used to create a new tracer with the logger, if the
+// logger is nil, slog.Default is used.
+func NewSlogTracer(logger *slog.Logger) *SlogTracer {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &SlogTracer{
+		logger: logger,
+		level:  slog.LevelDebug,
+	}
+}
+
+// SetLevel is used to set the level about the logged events.
+func (t *SlogTracer) SetLevel(level slog.Level) {
+	t.level = level
+}
+
+// OnSearch implements Tracer.
+func (t *SlogTracer) OnSearch(size, index int, path SearchPath) {
+	t.logger.LogAttrs(context.Background(), t.level, "search dictionary",
+		slog.Int("size", size),
+		slog.Int("index", index),
+		slog.String("path", path.String()),
+	)
+}
+
+// OnEncode implements Tracer.
+func (t *SlogTracer) OnEncode(cmd Command, index, changes int) {
+	t.logger.LogAttrs(context.Background(), t.level, "encode record",
+		slog.String("command", cmd.String()),
+		slog.Int("index", index),
+		slog.Int("changes", changes),
+	)
+}
+
+// OnEvict implements Tracer.
+func (t *SlogTracer) OnEvict(index int) {
+	t.logger.LogAttrs(context.Background(), t.level, "evict dictionary",
+		slog.Int("index", index),
+	)
+}
+
+// OnDecode implements Tracer.
+func (t *SlogTracer) OnDecode(cmd Command, index int) {
+	t.logger.LogAttrs(context.Background(), t.level, "decode record",
+		slog.String("command", cmd.String()),
+		slog.Int("index", index),
+	)
+}
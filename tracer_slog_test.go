@@ -0,0 +1,64 @@
+//go:build go1.21
+
+package cfh
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlogTracer(t *testing.T) {
+	t.Run("common", func(t *testing.T) {
+		logs := new(bytes.Buffer)
+		handler := slog.NewTextHandler(logs, &slog.HandlerOptions{
+			Level: slog.LevelDebug,
+		})
+		tracer := NewSlogTracer(slog.New(handler))
+
+		output := bytes.NewBuffer(make([]byte, 0, 256))
+		w := NewWriter(output)
+		w.SetTracer(tracer)
+		_, err := w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+
+		r := NewReader(output)
+		r.SetTracer(tracer)
+		_, err = r.ReadHeader()
+		require.NoError(t, err)
+
+		tracer.OnEvict(3)
+
+		lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+		require.Len(t, lines, 4)
+		for i, expected := range []string{
+			`level=DEBUG msg="search dictionary" size=54 index=-1 path=Fast`,
+			`level=DEBUG msg="encode record" command=AddDictionary index=0 changes=0`,
+			`level=DEBUG msg="decode record" command=AddDictionary index=0`,
+			`level=DEBUG msg="evict dictionary" index=3`,
+		} {
+			require.Contains(t, lines[i], expected)
+		}
+	})
+
+	t.Run("level", func(t *testing.T) {
+		logs := new(bytes.Buffer)
+		handler := slog.NewTextHandler(logs, nil)
+		tracer := NewSlogTracer(slog.New(handler))
+
+		tracer.OnEvict(0)
+		require.Zero(t, logs.Len())
+
+		tracer.SetLevel(slog.LevelInfo)
+		tracer.OnEvict(0)
+		require.Contains(t, logs.String(), `level=INFO msg="evict dictionary" index=0`)
+	})
+
+	t.Run("default logger", func(t *testing.T) {
+		tracer := NewSlogTracer(nil)
+		require.Equal(t, slog.Default(), tracer.logger)
+	})
+}
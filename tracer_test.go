@@ -0,0 +1,208 @@
+package cfh
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testTracer struct {
+	events []string
+}
+
+func (t *testTracer) OnSearch(size, index int, path SearchPath) {
+	t.events = append(t.events, fmt.Sprintf("search %d %d %s", size, index, path))
+}
+
+func (t *testTracer) OnEncode(cmd Command, index, changes int) {
+	t.events = append(t.events, fmt.Sprintf("encode %s %d %d", cmd, index, changes))
+}
+
+func (t *testTracer) OnEvict(index int) {
+	t.events = append(t.events, fmt.Sprintf("evict %d", index))
+}
+
+func (t *testTracer) OnDecode(cmd Command, index int) {
+	t.events = append(t.events, fmt.Sprintf("decode %s %d", cmd, index))
+}
+
+func TestCommand_String(t *testing.T) {
+	for _, item := range []*struct {
+		cmd Command
+		str string
+	}{
+		{CommandAddDictionary, "AddDictionary"},
+		{CommandChangedData, "ChangedData"},
+		{CommandLastData, "LastData"},
+		{CommandPreviousData, "PreviousData"},
+		{CommandSwappedData, "SwappedData"},
+		{CommandParameters, "Parameters"},
+		{CommandReset, "Reset"},
+		{CommandCheckpoint, "Checkpoint"},
+		{Command(0), "Command(0)"},
+	} {
+		require.Equal(t, item.str, item.cmd.String())
+	}
+}
+
+func TestSearchPath_String(t *testing.T) {
+	for _, item := range []*struct {
+		path SearchPath
+		str  string
+	}{
+		{SearchFast, "Fast"},
+		{SearchCustom, "Custom"},
+		{SearchSlow, "Slow"},
+		{SearchPath(3), "SearchPath(3)"},
+	} {
+		require.Equal(t, item.str, item.path.String())
+	}
+}
+
+func TestTracer(t *testing.T) {
+	t.Run("stream", func(t *testing.T) {
+		h1 := testIPv4TCPFrameHeader1
+		h2 := bytes.Clone(h1)
+		h2[18]++ // IPv4 ID
+		h2[19]++
+		h3 := bytes.Repeat([]byte{1}, 16)
+		h4 := bytes.Repeat([]byte{2}, 20)
+
+		output := bytes.NewBuffer(make([]byte, 0, 256))
+		opts := Options{
+			DictSize: 2,
+		}
+		w, err := NewWriterWithOptions(output, &opts)
+		require.NoError(t, err)
+		err = w.RegisterSearcher(len(h4), func([][]byte, []byte) int {
+			return -1
+		})
+		require.NoError(t, err)
+		wt := new(testTracer)
+		w.SetTracer(wt)
+
+		headers := [][]byte{h1, h1, h2, h3, h2, h4}
+		for _, header := range headers {
+			_, err = w.Write(header)
+			require.NoError(t, err)
+		}
+		expected := []string{
+			"search 54 -1 Fast",
+			"encode AddDictionary 0 0",
+			"encode LastData -1 0",
+			"search 54 0 Fast",
+			"encode ChangedData 0 2",
+			"search 16 -1 Slow",
+			"encode AddDictionary 0 0",
+			"search 54 1 Fast",
+			"encode PreviousData 1 0",
+			"search 20 -1 Custom",
+			"evict 1",
+			"encode AddDictionary 0 0",
+		}
+		require.Equal(t, expected, wt.events)
+
+		r, err := NewReaderWithOptions(output, &opts)
+		require.NoError(t, err)
+		rt := new(testTracer)
+		r.SetTracer(rt)
+		testReadFrameHeaders(t, r, headers)
+
+		expected = []string{
+			"decode AddDictionary 0",
+			"decode LastData -1",
+			"decode ChangedData 0",
+			"decode AddDictionary 0",
+			"decode PreviousData 1",
+			"evict 1",
+			"decode AddDictionary 0",
+		}
+		require.Equal(t, expected, rt.events)
+	})
+
+	t.Run("datagram", func(t *testing.T) {
+		h1 := testIPv4TCPFrameHeader1
+		h2 := testIPv4UDPFrameHeader1
+		h3 := testIPv6TCPFrameHeader1
+
+		w, r, _ := testNewDatagramPair(t, &Options{
+			DictSize: 2,
+			Datagram: true,
+		})
+		wt := new(testTracer)
+		w.SetTracer(wt)
+		rt := new(testTracer)
+		r.SetTracer(rt)
+
+		headers := [][]byte{h1, h2, h3, h3}
+		for _, header := range headers {
+			_, err := w.Write(header)
+			require.NoError(t, err)
+		}
+		testReadFrameHeaders(t, r, headers)
+
+		expected := []string{
+			"search 54 -1 Fast",
+			"encode AddDictionary 0 0",
+			"search 42 -1 Fast",
+			"encode AddDictionary 1 0",
+			"search 74 -1 Fast",
+			"evict 0",
+			"encode AddDictionary 0 0",
+			"search 74 0 Fast",
+			"encode PreviousData 0 0",
+		}
+		require.Equal(t, expected, wt.events)
+		expected = []string{
+			"decode AddDictionary 0",
+			"decode AddDictionary 1",
+			"decode AddDictionary 0",
+			"decode PreviousData 0",
+		}
+		require.Equal(t, expected, rt.events)
+	})
+
+	t.Run("disable", func(t *testing.T) {
+		tracer := new(testTracer)
+		w := NewWriter(new(bytes.Buffer))
+		w.SetTracer(tracer)
+		w.SetTracer(nil)
+		_, err := w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+		require.Empty(t, tracer.events)
+	})
+}
+
+func TestEvictedIndex(t *testing.T) {
+	for _, item := range []*struct {
+		policy   EvictionPolicy
+		datagram bool
+		expected int
+	}{
+		{EvictMRU, false, 3},
+		{EvictPinned, false, 3},
+		{EvictLFU, false, 0},
+		{EvictARC, false, 0},
+		{EvictMRU, true, 0},
+	} {
+		opts := Options{
+			DictSize:   4,
+			Policy:     item.policy,
+			PinnedSize: 1,
+			Datagram:   item.datagram,
+		}
+		evict, err := opts.newEvictor()
+		require.NoError(t, err)
+		dict := make([][]byte, 4)
+		for i := 0; i < len(dict); i++ {
+			evict.add(dict, []byte{byte(i)})
+		}
+		tracer := new(testTracer)
+		var stats statsCounter
+		addToTable(dict, evict, []byte{4}, &stats, tracer)
+		require.Equal(t, []string{fmt.Sprintf("evict %d", item.expected)}, tracer.events)
+		require.Equal(t, uint64(1), stats.evictions.Load())
+	}
+}
@@ -0,0 +1,206 @@
+package cfh
+
+import (
+	"sort"
+)
+
+// recorder is used to record the command of each record
+// written by Writer, it also counts the output size.
+type recorder struct {
+	cmd  byte
+	size int
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.cmd = b[0]
+	r.size += len(b)
+	return len(b), nil
+}
+
+// flow contains the sample headers about the same flow.
+type flow struct {
+	headers [][]byte
+	added   [][]byte // headers that add dictionary
+	saving  int
+}
+
+// Train is used to analyse sample frame headers and select at most
+// n dictionaries that can reduce the total encoded size, the result
+// can be used as the pre-shared dictionaries with the MRU policy.
+// It runs the Writer with sample headers, then select a dictionary
+// for each flow that need to add dictionary before the pre-shared
+// dictionaries are evicted, the data of the dictionary is the most
+// common bytes of the flow headers. The selected dictionaries are
+// verified with the Writer before return, the dictionaries that can
+// not reduce the encoded size are dropped. The sample headers should
+// be captured from the beginning of the connection.
+// Use EstimateCompressionRatio to get the expected compression ratio.
+func Train(headers [][]byte, n int) [][]byte {
+	if n < 1 {
+		return nil
+	}
+	if n > MaxDictionarySize {
+		n = MaxDictionarySize
+	}
+	flows := make(map[uint64]*flow)
+	var keys []uint64
+	rec := new(recorder)
+	w := NewWriter(rec)
+	// the average number of added dictionaries that the pre-shared
+	// dictionaries will not be evicted with the MRU policy
+	window := MaxDictionarySize - n/2
+	var added int
+	for _, header := range headers {
+		if !isValidHeader(header) {
+			continue
+		}
+		_, _ = w.Write(header)
+		key := dictionaryKey(header)
+		f, ok := flows[key]
+		if !ok {
+			f = new(flow)
+			flows[key] = f
+			keys = append(keys, key)
+		}
+		f.headers = append(f.headers, header)
+		if rec.cmd != cmdAddDict {
+			continue
+		}
+		if added < window {
+			f.added = append(f.added, header)
+		}
+		added++
+	}
+	// select the dictionary for each flow and calculate the saving
+	var selected []*flow
+	dicts := make(map[*flow][]byte)
+	for _, key := range keys {
+		f := flows[key]
+		if len(f.added) == 0 {
+			continue
+		}
+		dict := commonBytes(f.headers, len(f.added[0]))
+		for _, header := range f.added {
+			saving := 2 + len(header) - 3
+			if len(header) == len(dict) {
+				saving -= 2 * countDifferences(dict, header)
+			} else {
+				saving -= len(header)
+			}
+			if saving > 0 {
+				f.saving += saving
+			}
+		}
+		if f.saving < 1 {
+			continue
+		}
+		dicts[f] = dict
+		selected = append(selected, f)
+	}
+	sort.SliceStable(selected, func(i, j int) bool {
+		return selected[i].saving > selected[j].saving
+	})
+	if len(selected) > n {
+		selected = selected[:n]
+	}
+	// the last pre-shared dictionary will be at the top of table
+	result := make([][]byte, len(selected))
+	for i := 0; i < len(selected); i++ {
+		result[len(selected)-1-i] = dicts[selected[i]]
+	}
+	return verifyDictionaries(result, headers)
+}
+
+// verifyDictionaries is used to check the selected dictionaries with the
+// Writer, because the saving of each flow is estimated. The dictionary with
+// the lowest saving is dropped while the encoded size is reduced, it will
+// return nil if the dictionaries can not reduce the encoded size.
+func verifyDictionaries(dicts, headers [][]byte) [][]byte {
+	if len(dicts) == 0 {
+		return dicts
+	}
+	ratio := EstimateCompressionRatio(dicts, headers)
+	for len(dicts) > 1 {
+		r := EstimateCompressionRatio(dicts[1:], headers)
+		if r <= ratio {
+			break
+		}
+		dicts = dicts[1:]
+		ratio = r
+	}
+	if ratio <= EstimateCompressionRatio(nil, headers) {
+		return nil
+	}
+	return dicts
+}
+
+// EstimateCompressionRatio is used to calculate the compression ratio
+// when use the pre-shared dictionaries to compress sample headers,
+// the ratio is the total size of the headers divided by the total
+// encoded size like the compression ratio in Metrics, the stream
+// parameters are not included. It will return 0 if the dictionaries
+// are invalid or no valid header.
+func EstimateCompressionRatio(dicts, headers [][]byte) float64 {
+	rec := new(recorder)
+	var w *Writer
+	if len(dicts) == 0 {
+		w = NewWriter(rec)
+	} else {
+		var err error
+		w, err = NewWriterWithDictionaries(rec, dicts)
+		if err != nil {
+			return 0
+		}
+		w.params = nil
+	}
+	var total int
+	for _, header := range headers {
+		if !isValidHeader(header) {
+			continue
+		}
+		n, _ := w.Write(header)
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(total) / float64(rec.size)
+}
+
+func isValidHeader(header []byte) bool {
+	return len(header) > 0 && len(header) <= MaxFrameHeaderSize
+}
+
+// commonBytes is used to build the data that each byte is the most
+// common byte at the same offset of the headers with the same size.
+func commonBytes(headers [][]byte, size int) []byte {
+	data := make([]byte, size)
+	var count [256]int
+	for i := 0; i < size; i++ {
+		count = [256]int{}
+		for _, header := range headers {
+			if len(header) != size {
+				continue
+			}
+			count[header[i]]++
+		}
+		var b int
+		for j := 1; j < 256; j++ {
+			if count[j] > count[b] {
+				b = j
+			}
+		}
+		data[i] = byte(b)
+	}
+	return data
+}
+
+func countDifferences(a, b []byte) int {
+	var diff int
+	for i := 0; i < len(a); i++ {
+		if a[i] != b[i] {
+			diff++
+		}
+	}
+	return diff
+}
@@ -0,0 +1,120 @@
+package cfh
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrain(t *testing.T) {
+	headers := testGenerateFrameHeaders(t)
+
+	t.Run("common", func(t *testing.T) {
+		dicts := Train(headers, 16)
+		require.NotEmpty(t, dicts)
+		require.LessOrEqual(t, len(dicts), 16)
+
+		without := EstimateCompressionRatio(nil, headers)
+		with := EstimateCompressionRatio(dicts, headers)
+		require.Greater(t, with, without)
+		t.Logf("compression ratio: %.4f -> %.4f", without, with)
+
+		// use trained dictionaries
+		output := bytes.NewBuffer(make([]byte, 0, 4*1024*1024))
+		w, err := NewWriterWithDictionaries(output, dicts)
+		require.NoError(t, err)
+		for _, header := range headers {
+			_, err = w.Write(header)
+			require.NoError(t, err)
+		}
+		r, err := NewReaderWithDictionaries(output, dicts)
+		require.NoError(t, err)
+		for _, header := range headers {
+			buf := make([]byte, len(header))
+			_, err = r.Read(buf)
+			require.NoError(t, err)
+			require.Equal(t, header, buf)
+		}
+	})
+
+	t.Run("common bytes", func(t *testing.T) {
+		samples := [][]byte{
+			testIPv4TCPFrameHeader3,
+			testIPv4TCPFrameHeader1,
+			testIPv4TCPFrameHeader3,
+			testIPv4TCPFrameHeader1,
+			testIPv4TCPFrameHeader1,
+		}
+		dicts := Train(samples, 4)
+		require.Equal(t, [][]byte{testIPv4TCPFrameHeader1}, dicts)
+	})
+
+	t.Run("select the best dictionaries", func(t *testing.T) {
+		samples := [][]byte{
+			testIPv4UDPFrameHeader1,
+			testIPv4TCPFrameHeader1,
+			testIPv6TCPFrameHeader1,
+		}
+		dicts := Train(samples, 2)
+		require.Equal(t, [][]byte{testIPv4TCPFrameHeader1, testIPv6TCPFrameHeader1}, dicts)
+	})
+
+	t.Run("window", func(t *testing.T) {
+		// the pre-shared dictionaries will be evicted after 255
+		// dictionaries are added when n is 2, so the flow that is
+		// first seen after them is not selected though it saves more
+		window := MaxDictionarySize - 2/2
+		samples := make([][]byte, 0, window+1)
+		for i := 0; i < window; i++ {
+			samples = append(samples, bytes.Repeat([]byte{byte(i)}, 16))
+		}
+		samples = append(samples, testIPv4TCPFrameHeader1)
+		dicts := Train(samples, 2)
+		expected := [][]byte{
+			bytes.Repeat([]byte{1}, 16),
+			bytes.Repeat([]byte{0}, 16),
+		}
+		require.Equal(t, expected, dicts)
+
+		// the flow is selected if it is in the window
+		samples[window-1] = testIPv4TCPFrameHeader1
+		dicts = Train(samples, 2)
+		require.Contains(t, dicts, testIPv4TCPFrameHeader1)
+	})
+
+	t.Run("skip invalid headers", func(t *testing.T) {
+		samples := [][]byte{nil, make([]byte, MaxFrameHeaderSize+1)}
+		require.Empty(t, Train(samples, 4))
+	})
+
+	t.Run("invalid number", func(t *testing.T) {
+		require.Nil(t, Train(headers, 0))
+		dicts := Train(headers, MaxDictionarySize+1)
+		require.NotEmpty(t, dicts)
+		require.LessOrEqual(t, len(dicts), MaxDictionarySize)
+		without := EstimateCompressionRatio(nil, headers)
+		require.Greater(t, EstimateCompressionRatio(dicts, headers), without)
+	})
+}
+
+func TestEstimateCompressionRatio(t *testing.T) {
+	t.Run("common", func(t *testing.T) {
+		headers := [][]byte{testIPv4TCPFrameHeader1, testIPv4TCPFrameHeader1}
+		size := 2 + len(testIPv4TCPFrameHeader1) + 1
+		expected := float64(2*len(testIPv4TCPFrameHeader1)) / float64(size)
+		require.Equal(t, expected, EstimateCompressionRatio(nil, headers))
+
+		dicts := [][]byte{testIPv4TCPFrameHeader1}
+		expected = float64(2*len(testIPv4TCPFrameHeader1)) / float64(2+1)
+		require.Equal(t, expected, EstimateCompressionRatio(dicts, headers))
+	})
+
+	t.Run("invalid dictionaries", func(t *testing.T) {
+		require.Zero(t, EstimateCompressionRatio([][]byte{nil}, testFrameHeaders))
+	})
+
+	t.Run("no valid header", func(t *testing.T) {
+		require.Zero(t, EstimateCompressionRatio(nil, [][]byte{nil}))
+	})
+}
@@ -2,9 +2,9 @@ package cfh
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
 	"io"
+	"time"
 )
 
 // Searcher is used to fast search dictionaries for custom frame header.
@@ -14,13 +14,31 @@ type Searcher = func(dict [][]byte, header []byte) (index int)
 
 // Writer is used to compress frame header data.
 type Writer struct {
-	w    io.Writer
-	ses  map[int]Searcher
-	dict [][]byte
-	last bytes.Buffer
-	chg  bytes.Buffer
-	buf  bytes.Buffer
-	err  error
+	w      io.Writer
+	opts   *Options
+	ses    map[int]Searcher
+	dict   [][]byte
+	evict  evictor
+	params []byte
+	dgram  *datagram
+	crc    bool
+	last   bytes.Buffer
+	chg    bytes.Buffer
+	buf    bytes.Buffer
+	swp    []byte // the swapped frame header
+	cmd    byte   // the command of the last record
+	stats  statsCounter
+	tracer Tracer
+	err    error
+
+	// about buffered mode
+	pend    bytes.Buffer
+	pending int
+	batch   bool
+
+	// about checkpoint
+	records int
+	checked time.Time
 }
 
 // NewWriter is used to create a new compressor with 256 dictionaries.
@@ -34,18 +52,56 @@ func NewWriter(w io.Writer) *Writer {
 
 // NewWriterWithSize is used to create a new compressor with custom number of dictionaries.
 func NewWriterWithSize(w io.Writer, size int) (*Writer, error) {
-	if size < 1 {
-		return nil, errors.New("dictionary size cannot less than 1")
-	}
-	if size > MaxDictionarySize {
-		return nil, errors.New("dictionary size cannot greater than 256")
+	err := checkDictSize(size)
+	if err != nil {
+		return nil, err
 	}
 	return &Writer{
-		w:    w,
-		dict: make([][]byte, size),
+		w:     w,
+		dict:  make([][]byte, size),
+		evict: new(mruEvictor),
 	}, nil
 }
 
+// NewWriterWithOptions is used to create a new compressor with options,
+// the stream parameters will be written before the first record.
+func NewWriterWithOptions(w io.Writer, opts *Options) (*Writer, error) {
+	opts, err := opts.apply()
+	if err != nil {
+		return nil, err
+	}
+	evict, err := opts.newEvictor()
+	if err != nil {
+		return nil, err
+	}
+	dict := make([][]byte, opts.DictSize)
+	seedDictionaries(dict, evict, opts.Dictionaries)
+	writer := Writer{
+		w:     w,
+		opts:  opts,
+		dict:  dict,
+		evict: evict,
+		crc:   opts.Checksum,
+	}
+	writer.resetCheckpoint()
+	if opts.Datagram {
+		writer.dgram = newDatagram(opts)
+		writer.dgram.seed(dict)
+	} else {
+		writer.params = opts.params().encode()
+	}
+	return &writer, nil
+}
+
+// NewWriterWithDictionaries is used to create a new compressor with
+// pre-shared dictionaries, the Reader must use the same dictionaries.
+func NewWriterWithDictionaries(w io.Writer, dicts [][]byte) (*Writer, error) {
+	opts := Options{
+		Dictionaries: dicts,
+	}
+	return NewWriterWithOptions(w, &opts)
+}
+
 // Write is used to compress frame header data and write to the under w.
 func (w *Writer) Write(b []byte) (int, error) {
 	l := len(b)
@@ -53,12 +109,20 @@ func (w *Writer) Write(b []byte) (int, error) {
 		return 0, nil
 	}
 	if l > MaxFrameHeaderSize {
-		return 0, errors.New("write too large data")
+		return 0, fmt.Errorf("%w: %d", ErrHeaderTooLarge, l)
 	}
 	if w.err != nil {
 		return 0, w.err
 	}
-	n, err := w.write(b)
+	var (
+		n   int
+		err error
+	)
+	if w.dgram != nil {
+		n, err = w.writeDatagram(b)
+	} else {
+		n, err = w.write(b)
+	}
 	if err != nil {
 		w.err = err
 	}
@@ -68,79 +132,181 @@ func (w *Writer) Write(b []byte) (int, error) {
 func (w *Writer) write(b []byte) (int, error) {
 	n := len(b)
 	w.buf.Reset()
+	// write stream parameters with the first record
+	if w.params != nil {
+		w.buf.Write(w.params)
+	}
+	checkpoint := w.needCheckpoint()
+	if checkpoint {
+		w.writeCheckpoint()
+	}
 	// check data is as same as the last
 	if bytes.Equal(w.last.Bytes(), b) {
 		w.buf.WriteByte(cmdLast)
-		_, err := w.w.Write(w.buf.Bytes())
+		w.cmd = cmdLast
+		w.writeChecksum(b)
+		err := w.output()
 		if err != nil {
 			return 0, err
 		}
+		w.flushed(checkpoint)
+		w.account(-1, n, 0)
 		return n, nil
 	}
 	// search the dictionary
-	idx := w.searchDictionary(b)
+	idx, swap := w.searchDictionary(b)
+	if idx != -1 {
+		if swap {
+			w.compareSwapped(idx, b)
+		} else {
+			w.compareDictionary(idx, b)
+		}
+		// the number of changed data cannot be stored in one byte
+		if w.chg.Len()/2 > 255 {
+			idx = -1
+		}
+	}
 	if idx == -1 {
 		w.buf.WriteByte(cmdAddDict)
 		w.buf.WriteByte(byte(n))
 		w.buf.Write(b)
-		_, err := w.w.Write(w.buf.Bytes())
+		w.cmd = cmdAddDict
+		w.writeChecksum(b)
+		err := w.output()
 		if err != nil {
 			return 0, err
 		}
-		w.addDictionary(b)
+		w.flushed(checkpoint)
+		idx = w.addDictionary(b)
+		w.account(idx, n, 0)
 		w.updateLast(b)
 		return n, nil
 	}
-	// compare the new data with the dictionary
-	dict := w.dict[idx]
-	for i := 0; i < n; i++ {
-		if dict[i] == b[i] {
-			continue
-		}
-		w.chg.WriteByte(byte(i))
-		w.chg.WriteByte(b[i])
-		// update dictionary data
-		dict[i] = b[i]
-	}
-	if w.chg.Len() == 0 {
+	// update dictionary data
+	copy(w.dict[idx], b)
+	changes := w.chg.Len() / 2
+	switch {
+	case swap:
+		w.buf.WriteByte(cmdSwap)
+		w.buf.WriteByte(byte(idx))
+		w.buf.WriteByte(byte(w.chg.Len() / 2))
+		w.buf.Write(w.chg.Bytes())
+		w.chg.Reset()
+		w.cmd = cmdSwap
+	case w.chg.Len() == 0:
 		w.buf.WriteByte(cmdPrev)
 		w.buf.WriteByte(byte(idx))
-	} else {
+		w.cmd = cmdPrev
+	default:
 		w.buf.WriteByte(cmdData)
 		w.buf.WriteByte(byte(idx))
 		w.buf.WriteByte(byte(w.chg.Len() / 2))
 		w.buf.Write(w.chg.Bytes())
 		w.chg.Reset()
+		w.cmd = cmdData
 	}
+	w.writeChecksum(b)
 	// write the actual changed data
-	_, err := w.w.Write(w.buf.Bytes())
+	err := w.output()
 	if err != nil {
 		return 0, err
 	}
-	// move the dictionary to the top
-	w.moveDictionary(idx)
+	w.flushed(checkpoint)
+	w.account(idx, n, changes)
+	// update the status of the reused dictionary
+	w.evict.access(w.dict, idx)
 	w.updateLast(b)
 	return n, nil
 }
 
-func (w *Writer) searchDictionary(header []byte) int {
+// compareDictionary is used to write the changed data between
+// the dictionary and the frame header to the chg buffer.
+func (w *Writer) compareDictionary(idx int, b []byte) {
+	w.compareData(w.dict[idx], b)
+}
+
+func (w *Writer) compareData(dict, b []byte) {
+	w.chg.Reset()
+	for i := 0; i < len(b); i++ {
+		if dict[i] == b[i] {
+			continue
+		}
+		w.chg.WriteByte(byte(i))
+		w.chg.WriteByte(b[i])
+	}
+}
+
+// writeChecksum is used to append the checksum to the record if enabled.
+func (w *Writer) writeChecksum(data []byte) {
+	if !w.crc {
+		return
+	}
+	crc := checksum(data)
+	w.buf.WriteByte(byte(crc >> 8))
+	w.buf.WriteByte(byte(crc))
+}
+
+// flushed is used to update the status after the record is written.
+func (w *Writer) flushed(checkpoint bool) {
+	w.params = nil
+	if checkpoint {
+		w.resetCheckpoint()
+	}
+	w.records++
+}
+
+// account is used to update the statistics after the record is written.
+func (w *Writer) account(idx, n, changes int) {
+	w.stats.count(w.cmd, changes)
+	w.stats.bytesIn.Add(uint64(n))
+	w.stats.bytesOut.Add(uint64(w.buf.Len()))
+	if w.tracer != nil {
+		w.tracer.OnEncode(Command(w.cmd), idx, changes)
+	}
+}
+
+// searchDictionary is used to search the dictionary about the frame header,
+// in bidirectional mode the swapped frame header is also searched if it is
+// not found, swap is true if the dictionary is about the reverse direction.
+// The search is counted once in stats and reported once to the tracer.
+func (w *Writer) searchDictionary(header []byte) (idx int, swap bool) {
+	idx, path := w.search(header)
+	if idx == -1 && w.bidirectional() {
+		idx = w.searchSwapped(header)
+		swap = idx != -1
+	}
+	switch path {
+	case SearchFast:
+		w.stats.fastSearches.Add(1)
+	case SearchCustom:
+		w.stats.customSearches.Add(1)
+	case SearchSlow:
+		w.stats.slowSearches.Add(1)
+	}
+	if w.tracer != nil {
+		w.tracer.OnSearch(len(header), idx, path)
+	}
+	return idx, swap
+}
+
+func (w *Writer) search(header []byte) (int, SearchPath) {
 	size := len(header)
 	if w.ses != nil {
 		if searcher, ok := w.ses[size]; ok {
-			return searcher(w.dict, header)
+			return searcher(w.dict, header), SearchCustom
 		}
 	}
 	switch {
 	case size == ethernetIPv4TCPSize:
-		return w.fastSearchDictEthernetIPv4TCP(header)
+		return w.fastSearchDictEthernetIPv4TCP(header), SearchFast
 	case size == ethernetIPv4UDPSize:
-		return w.fastSearchDictEthernetIPv4UDP(header)
+		return w.fastSearchDictEthernetIPv4UDP(header), SearchFast
 	case size == ethernetIPv6TCPSize:
-		return w.fastSearchDictEthernetIPv6TCP(header)
+		return w.fastSearchDictEthernetIPv6TCP(header), SearchFast
 	case size == ethernetIPv6UDPSize:
-		return w.fastSearchDictEthernetIPv6UDP(header)
+		return w.fastSearchDictEthernetIPv6UDP(header), SearchFast
 	default:
-		return w.slowSearchDict(header)
+		return w.slowSearchDict(header), SearchSlow
 	}
 }
 
@@ -276,30 +442,84 @@ next:
 	return dictIdx
 }
 
-func (w *Writer) addDictionary(data []byte) {
-	// remove the oldest dictionary
-	for i := len(w.dict) - 1; i > 0; i-- {
-		w.dict[i] = w.dict[i-1]
-	}
+func (w *Writer) addDictionary(data []byte) int {
 	dict := make([]byte, len(data))
 	copy(dict, data)
-	w.dict[0] = dict
+	return addToTable(w.dict, w.evict, dict, &w.stats, w.tracer)
 }
 
-func (w *Writer) moveDictionary(idx int) {
-	if idx == 0 {
-		return
+func (w *Writer) updateLast(data []byte) {
+	w.last.Reset()
+	w.last.Write(data)
+}
+
+// Reset is used to discard the status and sticky error of Writer and
+// switch to write to the new w, the dictionary table is reset to the
+// initial status, it is used to reuse the Writer on a new transport.
+// The registered searchers are not changed.
+func (w *Writer) Reset(wr io.Writer) {
+	w.w = wr
+	w.resetTable()
+	if w.dgram != nil {
+		w.dgram.reset()
+		w.dgram.seed(w.dict)
+	} else if w.opts != nil {
+		w.params = w.opts.params().encode()
+	}
+	w.resetCheckpoint()
+	w.discardPending()
+	w.err = nil
+}
+
+// Resync is used to write a reset record to the under w, then the Writer
+// and the Reader will reset the dictionary table to the initial status
+// at the same time. It will discard the sticky error before write. In
+// buffered mode, the pending records are flushed with the reset record.
+func (w *Writer) Resync() error {
+	if w.err != nil {
+		w.discardPending()
+	}
+	w.buf.Reset()
+	if w.params != nil {
+		w.buf.Write(w.params)
+	}
+	if w.dgram != nil {
+		w.dgram.writeSequence(&w.buf)
 	}
-	dict := w.dict[idx]
-	for i := idx; i > 0; i-- {
-		w.dict[i] = w.dict[i-1]
+	w.buf.WriteByte(cmdReset)
+	var err error
+	if w.pend.Len() != 0 {
+		w.pend.Write(w.buf.Bytes())
+		err = w.flush()
+	} else {
+		_, err = w.w.Write(w.buf.Bytes())
+	}
+	if err != nil {
+		w.err = err
+		return err
+	}
+	w.stats.bytesOut.Add(uint64(w.buf.Len()))
+	w.params = nil
+	w.resetTable()
+	if w.dgram != nil {
+		w.dgram.resetVersions()
+		w.dgram.seed(w.dict)
 	}
-	w.dict[0] = dict
+	w.err = nil
+	return nil
 }
 
-func (w *Writer) updateLast(data []byte) {
+// resetTable is used to clean all dictionaries and the last frame
+// header, then add the pre-shared dictionaries to the table again.
+func (w *Writer) resetTable() {
+	for i := 0; i < len(w.dict); i++ {
+		w.dict[i] = nil
+	}
+	w.evict.reset()
+	if w.opts != nil {
+		seedDictionaries(w.dict, w.evict, w.opts.Dictionaries)
+	}
 	w.last.Reset()
-	w.last.Write(data)
 }
 
 // RegisterSearcher is used to register custom searcher
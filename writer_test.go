@@ -51,6 +51,114 @@ func TestNewWriter(t *testing.T) {
 	})
 }
 
+func TestNewWriterWithOptions(t *testing.T) {
+	t.Run("common", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+
+		w, err := NewWriterWithOptions(output, nil)
+		require.NoError(t, err)
+		require.Len(t, w.dict, MaxDictionarySize)
+
+		n, err := w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+		require.Equal(t, len(testIPv4TCPFrameHeader1), n)
+
+		// stream parameters are only written once
+		n, err = w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+		require.Equal(t, len(testIPv4TCPFrameHeader1), n)
+
+		expected := []byte{cmdParams, MaxDictionarySize - 1, byte(EvictMRU), 0, 0}
+		expected = append(expected, cmdAddDict, byte(len(testIPv4TCPFrameHeader1)))
+		expected = append(expected, testIPv4TCPFrameHeader1...)
+		expected = append(expected, cmdLast)
+		require.Equal(t, expected, output.Bytes())
+	})
+
+	t.Run("invalid dictionary size", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+
+		opts := Options{
+			DictSize: MaxDictionarySize + 1,
+		}
+		w, err := NewWriterWithOptions(output, &opts)
+		require.EqualError(t, err, "dictionary size cannot greater than 256")
+		require.Nil(t, w)
+	})
+
+	t.Run("invalid eviction policy", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+
+		opts := Options{
+			Policy: 123,
+		}
+		w, err := NewWriterWithOptions(output, &opts)
+		require.EqualError(t, err, "invalid eviction policy: 123")
+		require.Nil(t, w)
+	})
+
+	t.Run("invalid feedback mode", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+
+		opts := Options{
+			Datagram: true,
+			Feedback: 123,
+		}
+		w, err := NewWriterWithOptions(output, &opts)
+		require.EqualError(t, err, "invalid feedback mode: 123")
+		require.Nil(t, w)
+	})
+}
+
+func TestNewWriterWithDictionaries(t *testing.T) {
+	t.Run("common", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+
+		dicts := [][]byte{testIPv4TCPFrameHeader1, testIPv6UDPFrameHeader1}
+		w, err := NewWriterWithDictionaries(output, dicts)
+		require.NoError(t, err)
+
+		n, err := w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+		require.Equal(t, len(testIPv4TCPFrameHeader1), n)
+
+		hash := dictionariesHash(dicts)
+		expected := []byte{cmdParams, MaxDictionarySize - 1, byte(EvictMRU), 0, flagDictionaries}
+		expected = append(expected, hash[:]...)
+		expected = append(expected, cmdPrev, 1)
+		require.Equal(t, expected, output.Bytes())
+
+		// pre-shared dictionaries are copied
+		w.dict[0][0]++
+		require.Equal(t, testIPv4TCPFrameHeader1, dicts[0])
+	})
+
+	t.Run("too many pre-shared dictionaries", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+
+		opts := Options{
+			DictSize:     1,
+			Dictionaries: testFrameHeaders,
+		}
+		w, err := NewWriterWithOptions(output, &opts)
+		require.EqualError(t, err, "too many pre-shared dictionaries")
+		require.Nil(t, w)
+	})
+
+	t.Run("invalid pre-shared dictionary size", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+
+		w, err := NewWriterWithDictionaries(output, [][]byte{{}})
+		require.EqualError(t, err, "invalid pre-shared dictionary size: 0")
+		require.Nil(t, w)
+
+		dicts := [][]byte{make([]byte, MaxFrameHeaderSize+1)}
+		w, err = NewWriterWithDictionaries(output, dicts)
+		require.EqualError(t, err, "invalid pre-shared dictionary size: 257")
+		require.Nil(t, w)
+	})
+}
+
 func TestWriter_Write(t *testing.T) {
 	output := bytes.NewBuffer(make([]byte, 0, 4096))
 
@@ -121,7 +229,8 @@ func TestWriter_Write(t *testing.T) {
 
 		data := bytes.Repeat([]byte{0}, MaxFrameHeaderSize+1)
 		n, err := w.Write(data)
-		require.EqualError(t, err, "write too large data")
+		require.EqualError(t, err, "frame header is too large: 257")
+		require.ErrorIs(t, err, ErrHeaderTooLarge)
 		require.Zero(t, n)
 	})
 
@@ -362,6 +471,109 @@ func TestWriter_RegisterSearcher(t *testing.T) {
 	})
 }
 
+func TestWriter_Reset(t *testing.T) {
+	dicts := [][]byte{testIPv4TCPFrameHeader1}
+
+	output1 := bytes.NewBuffer(make([]byte, 0, 4096))
+	w, err := NewWriterWithDictionaries(output1, dicts)
+	require.NoError(t, err)
+	w.err = errors.New("foo")
+
+	output2 := bytes.NewBuffer(make([]byte, 0, 4096))
+	w.Reset(output2)
+	require.Equal(t, testIPv4TCPFrameHeader1, w.dict[0])
+	for _, header := range testFrameHeaders {
+		_, err = w.Write(header)
+		require.NoError(t, err)
+	}
+
+	output3 := bytes.NewBuffer(make([]byte, 0, 4096))
+	w.Reset(output3)
+	require.Equal(t, testIPv4TCPFrameHeader1, w.dict[0])
+	require.Nil(t, w.dict[1])
+	require.Zero(t, w.last.Len())
+	for _, header := range testFrameHeaders {
+		_, err = w.Write(header)
+		require.NoError(t, err)
+	}
+
+	// the stream parameters are written again
+	require.Equal(t, output2.Bytes(), output3.Bytes())
+	require.Zero(t, output1.Len())
+}
+
+func TestWriter_Resync(t *testing.T) {
+	t.Run("common", func(t *testing.T) {
+		dicts := [][]byte{testIPv4TCPFrameHeader1}
+		output := bytes.NewBuffer(make([]byte, 0, 4096))
+
+		w, err := NewWriterWithDictionaries(output, dicts)
+		require.NoError(t, err)
+		for _, header := range testFrameHeaders {
+			_, err = w.Write(header)
+			require.NoError(t, err)
+		}
+		err = w.Resync()
+		require.NoError(t, err)
+		require.Equal(t, byte(cmdReset), output.Bytes()[output.Len()-1])
+		for _, header := range testFrameHeaders {
+			_, err = w.Write(header)
+			require.NoError(t, err)
+		}
+
+		r, err := NewReaderWithDictionaries(output, dicts)
+		require.NoError(t, err)
+		for i := 0; i < 2; i++ {
+			for _, header := range testFrameHeaders {
+				buf := make([]byte, len(header))
+				_, err = r.Read(buf)
+				require.NoError(t, err)
+				require.Equal(t, header, buf)
+			}
+		}
+		require.Equal(t, w.dict, r.dict)
+	})
+
+	t.Run("with stream parameters", func(t *testing.T) {
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+
+		w, err := NewWriterWithOptions(output, nil)
+		require.NoError(t, err)
+		err = w.Resync()
+		require.NoError(t, err)
+		err = w.Resync()
+		require.NoError(t, err)
+
+		expected := []byte{cmdParams, MaxDictionarySize - 1, byte(EvictMRU), 0, 0, cmdReset, cmdReset}
+		require.Equal(t, expected, output.Bytes())
+	})
+
+	t.Run("discard sticky error", func(t *testing.T) {
+		pr, pw := io.Pipe()
+		err := pr.Close()
+		require.NoError(t, err)
+
+		w := NewWriter(pw)
+		_, err = w.Write(testIPv4TCPFrameHeader1)
+		require.Equal(t, io.ErrClosedPipe, err)
+
+		err = w.Resync()
+		require.Equal(t, io.ErrClosedPipe, err)
+
+		output := bytes.NewBuffer(make([]byte, 0, 64))
+		w.w = output
+		err = w.Resync()
+		require.NoError(t, err)
+
+		n, err := w.Write(testIPv4TCPFrameHeader1)
+		require.NoError(t, err)
+		require.Equal(t, len(testIPv4TCPFrameHeader1), n)
+
+		err = pw.Close()
+		require.NoError(t, err)
+	})
+}
+
 func TestWriter_Fuzz(t *testing.T) {
 	output := bytes.NewBuffer(make([]byte, 0, 4*1024*1024))
 	headers := testGenerateFrameHeaders(t)
@@ -700,3 +912,56 @@ func benchmarkWriterWriteCustomFrameHeader(b *testing.B) {
 		b.StopTimer()
 	})
 }
+
+func FuzzRoundTrip(f *testing.F) {
+	f.Add(byte(0), byte(54), bytes.Join(testFrameHeaders, nil))
+	f.Add(byte(1), byte(0), bytes.Repeat([]byte{1, 2, 3, 4}, 256))
+	f.Add(byte(2), byte(3), []byte{1, 2, 3, 1, 2, 4, 5, 6, 7, 1, 2, 4})
+	f.Add(byte(4|8), byte(20), bytes.Repeat([]byte{0, 1}, 100))
+
+	f.Fuzz(func(t *testing.T, flags byte, size byte, data []byte) {
+		// split data to the frame headers with the same size
+		l := decodeDictSize(size)
+		var headers [][]byte
+		for len(data) > 0 {
+			n := minInt(l, len(data))
+			headers = append(headers, data[:n])
+			data = data[n:]
+		}
+		opts := Options{
+			DictSize: int(flags>>4) + 1,
+			Policy:   EvictionPolicy(flags & 3),
+			Datagram: flags&4 != 0,
+			Checksum: flags&8 != 0,
+		}
+		if opts.Policy == EvictPinned {
+			if opts.DictSize < 2 {
+				opts.DictSize = 2
+			}
+			opts.PinnedSize = 1
+		}
+
+		queue := new(testPacketQueue)
+		var output bytes.Buffer
+		var (
+			wr io.Writer = &output
+			rd io.Reader = &output
+		)
+		if opts.Datagram {
+			wr = queue
+			rd = queue
+		}
+		w, err := NewWriterWithOptions(wr, &opts)
+		require.NoError(t, err)
+		r, err := NewReaderWithOptions(rd, &opts)
+		require.NoError(t, err)
+		buf := make([]byte, MaxFrameHeaderSize)
+		for _, header := range headers {
+			_, err = w.Write(header)
+			require.NoError(t, err)
+			n, err := r.Read(buf[:len(header)])
+			require.NoError(t, err)
+			require.Equal(t, header, buf[:n])
+		}
+	})
+}